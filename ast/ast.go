@@ -16,6 +16,7 @@ const (
 	RETURNSTATEMENT NodeType = "RETURNSTATEMENT"
 	EXPRESSIONSTATEMENT NodeType = "EXPRESSIONSTATEMENT"
 	INTEGERLITERAL NodeType = "INTEGERLITERAL"
+	FLOATLITERAL NodeType = "FLOATLITERAL"
 	PREFIXEXPRESSION NodeType = "PREFIXEXPRESSION"
 	INFIXEXPRESSION NodeType = "INFIXEXPRESSION"
 	BOOLEAN NodeType = "BOOLEAN"
@@ -28,15 +29,21 @@ const (
 	INDEXEXPRESSION NodeType = "INDEXEXPRESSION"
 	HASHLITERAL NodeType = "HASHLITERAL"
 	ASSIGNSTATEMENT NodeType = "ASSIGNSTATEMENT"
+	INDEXASSIGNSTATEMENT NodeType = "INDEXASSIGNSTATEMENT"
 	ASSIGNEXPRESSION NodeType = "ASSIGNEXPRESSION"
 	WHILESTATEMENT NodeType = "WHILESTATEMENT"
+	FORSTATEMENT NodeType = "FORSTATEMENT"
+	FORINSTATEMENT NodeType = "FORINSTATEMENT"
 	FUNCTIONDEFINITIONSTATEMENT NodeType = "FUNCTIONDEFINITIONSTATEMENT"
+	BREAKSTATEMENT NodeType = "BREAKSTATEMENT"
+	CONTINUESTATEMENT NodeType = "CONTINUESTATEMENT"
 
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Span() Span // 节点在源码中的范围
 
 	Tag() string // for drawing ast tree
 }
@@ -105,6 +112,7 @@ type LetStatement struct {
 	Name *Identifier
 	Value Expression
 	Id int64
+	End token.Position // 最后一个token之后的位置，由parser设置
 }
 
 func (ls *LetStatement) statementNode() {
@@ -138,6 +146,7 @@ type ReturnStatement struct {
 	Token token.Token
 	ReturnValue Expression
 	Id int64
+	End token.Position // 最后一个token之后的位置，由parser设置
 }
 
 func (rs *ReturnStatement) statementNode() {
@@ -168,6 +177,7 @@ type ExpressionStatement struct {
 	Token token.Token
 	Expression Expression
 	Id int64
+	End token.Position // 最后一个token之后的位置，由parser设置
 }
 
 func (es *ExpressionStatement) String() string {
@@ -212,6 +222,28 @@ func (this *IntegerLiteral) Tag() string {
 	return fmt.Sprintf("[%s]%d", INTEGERLITERAL, this.Id)
 }
 
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+	Id int64
+}
+
+func (fl *FloatLiteral) expressionNode() {
+
+}
+
+func (fl *FloatLiteral) TokenLiteral() string {
+	return fl.Token.Literal
+}
+
+func (fl *FloatLiteral) String() string {
+	return fl.Token.Literal
+}
+
+func (this *FloatLiteral) Tag() string {
+	return fmt.Sprintf("[%s]%d", FLOATLITERAL, this.Id)
+}
+
 type PrefixExpression struct {
 	Token token.Token
 	Operator string
@@ -300,6 +332,7 @@ type IfExpression struct {
 	Condition Expression
 	Consequence *BlockStatement
 	Alternative *BlockStatement
+	ElseIf *IfExpression // else if分支，整条链最后一个分支才可能有Alternative
 	Id int64
 }
 
@@ -318,7 +351,10 @@ func (ie *IfExpression) String() string {
 	out.WriteString(" ")
 	out.WriteString(ie.Consequence.String())
 
-	if ie.Alternative != nil {
+	if ie.ElseIf != nil {
+		out.WriteString("else ")
+		out.WriteString(ie.ElseIf.String())
+	}else if ie.Alternative != nil {
 		out.WriteString("else ")
 		out.WriteString(ie.Alternative.String())
 	}
@@ -334,6 +370,7 @@ type BlockStatement struct {
 	Token token.Token
 	Statements []Statement
 	Id int64
+	End token.Position // 最后一个token之后的位置，由parser设置
 }
 
 func (bs *BlockStatement) statementNode() {
@@ -410,6 +447,7 @@ type CallExpression struct {
 	Function Expression // Identifier or FunctionLiteral
 	Arguments []Expression
 	Id int64
+	End token.Position // 最后一个token之后的位置，由parser设置
 }
 
 func (ce *CallExpression) expressionNode()  {
@@ -465,6 +503,7 @@ type ArrayLiteral struct {
 	Token token.Token // the '[' token
 	Elements []Expression
 	Id int64
+	End token.Position // 最后一个token之后的位置，由parser设置
 }
 
 func (al *ArrayLiteral) expressionNode() {
@@ -500,6 +539,7 @@ type IndexExpression struct {
 	Left Expression
 	Index Expression
 	Id int64
+	End token.Position // 最后一个token之后的位置，由parser设置
 }
 
 func (ie *IndexExpression) expressionNode() {
@@ -531,6 +571,7 @@ type HashLiteral struct {
 	Token token.Token
 	Pairs map[Expression]Expression
 	Id int64
+	End token.Position // 最后一个token之后的位置，由parser设置
 }
 
 func (hl *HashLiteral) expressionNode() {
@@ -546,8 +587,8 @@ func (hl *HashLiteral) String() string {
 
 	var pairs []string
 
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.Keys() {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 
 	out.WriteString("{")
@@ -564,8 +605,10 @@ func (this *HashLiteral) Tag() string {
 type AssignStatement struct {
 	Token token.Token
 	Lhs *Identifier
+	Operator string // =，或者复合赋值运算符+=、-=、*=、/=、%=
 	Rhs Expression
 	Id int64
+	End token.Position // 最后一个token之后的位置，由parser设置
 }
 
 func (as *AssignStatement) statementNode()  {
@@ -580,7 +623,7 @@ func (as *AssignStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(as.Lhs.String())
-	out.WriteString(" = ")
+	out.WriteString(" " + as.Operator + " ")
 	out.WriteString(as.Rhs.String())
 	out.WriteString(";")
 	return out.String()
@@ -589,6 +632,43 @@ func (as *AssignStatement) String() string {
 func (this *AssignStatement) Tag() string {
 	return fmt.Sprintf("[%s]%d", ASSIGNSTATEMENT, this.Id)
 }
+// IndexAssignStatement /**
+/*
+给数组的元素或者哈希的键赋值：arr[i] = v、hash["k"] += v，直接修改原来的对象。
+Token是语句的第一个token，Target是等号左边的索引表达式
+ */
+type IndexAssignStatement struct {
+	Token token.Token
+	Target *IndexExpression
+	Operator string // 跟AssignStatement一样
+	Value Expression
+	Id int64
+	End token.Position // 最后一个token之后的位置，由parser设置
+}
+
+func (ias *IndexAssignStatement) statementNode()  {
+
+}
+
+func (ias *IndexAssignStatement) TokenLiteral() string {
+	return ias.Token.Literal
+}
+
+func (ias *IndexAssignStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ias.Target.Left.String())
+	out.WriteString("[")
+	out.WriteString(ias.Target.Index.String())
+	out.WriteString("] " + ias.Operator + " ")
+	out.WriteString(ias.Value.String())
+	out.WriteString(";")
+	return out.String()
+}
+
+func (this *IndexAssignStatement) Tag() string {
+	return fmt.Sprintf("[%s]%d", INDEXASSIGNSTATEMENT, this.Id)
+}
 /*
 type AssignExpression struct {
 	Token token.Token
@@ -654,6 +734,142 @@ func (this *WhileStatement) Tag() string {
 	return fmt.Sprintf("[%s]%d", WHILESTATEMENT, this.Id)
 }
 
+// ForStatement /**
+/*
+C风格的for循环：for (init; condition; post) { body }。三部分都可以省略，没有条件时一直循环，
+continue跳到post。init里用let定义的变量跟while循环体里定义的一样，属于所在的函数作用域
+ */
+type ForStatement struct {
+	Token token.Token // the 'for' token
+	Init Statement
+	Condition Expression
+	Post Statement
+	Body *BlockStatement
+	Id int64
+}
+
+func (fs *ForStatement) statementNode() {
+
+}
+
+func (fs *ForStatement) TokenLiteral() string {
+	return fs.Token.Literal
+}
+
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	if fs.Init != nil {
+		out.WriteString(strings.TrimSuffix(fs.Init.String(), ";"))
+	}
+	out.WriteString("; ")
+	if fs.Condition != nil {
+		out.WriteString(fs.Condition.String())
+	}
+	out.WriteString("; ")
+	if fs.Post != nil {
+		out.WriteString(strings.TrimSuffix(fs.Post.String(), ";"))
+	}
+	out.WriteString(") {\n")
+	out.WriteString(fs.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
+func (this *ForStatement) Tag() string {
+	return fmt.Sprintf("[%s]%d", FORSTATEMENT, this.Id)
+}
+
+// ForInStatement /**
+/*
+遍历数组、哈希或者字符串：for (v in iterable)、for (k, v in iterable)。
+两个循环变量时Key是序号或者哈希的键，Value是元素；只有一个时Key是nil，遍历哈希时Value得到的是键。
+哈希按键排序后遍历，顺序是确定的
+ */
+type ForInStatement struct {
+	Token token.Token // the 'for' token
+	Key *Identifier
+	Value *Identifier
+	Iterable Expression
+	Body *BlockStatement
+	Id int64
+}
+
+func (fis *ForInStatement) statementNode() {
+
+}
+
+func (fis *ForInStatement) TokenLiteral() string {
+	return fis.Token.Literal
+}
+
+func (fis *ForInStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	if fis.Key != nil {
+		out.WriteString(fis.Key.String() + ", ")
+	}
+	out.WriteString(fis.Value.String())
+	out.WriteString(" in ")
+	out.WriteString(fis.Iterable.String())
+	out.WriteString(") {\n")
+	out.WriteString(fis.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
+func (this *ForInStatement) Tag() string {
+	return fmt.Sprintf("[%s]%d", FORINSTATEMENT, this.Id)
+}
+
+type BreakStatement struct {
+	Token token.Token // the 'break' token
+	Id int64
+	End token.Position // 最后一个token之后的位置，由parser设置
+}
+
+func (bs *BreakStatement) statementNode() {
+
+}
+
+func (bs *BreakStatement) TokenLiteral() string {
+	return bs.Token.Literal
+}
+
+func (bs *BreakStatement) String() string {
+	return bs.Token.Literal + ";"
+}
+
+func (this *BreakStatement) Tag() string {
+	return fmt.Sprintf("[%s]%d", BREAKSTATEMENT, this.Id)
+}
+
+type ContinueStatement struct {
+	Token token.Token // the 'continue' token
+	Id int64
+	End token.Position // 最后一个token之后的位置，由parser设置
+}
+
+func (cs *ContinueStatement) statementNode() {
+
+}
+
+func (cs *ContinueStatement) TokenLiteral() string {
+	return cs.Token.Literal
+}
+
+func (cs *ContinueStatement) String() string {
+	return cs.Token.Literal + ";"
+}
+
+func (this *ContinueStatement) Tag() string {
+	return fmt.Sprintf("[%s]%d", CONTINUESTATEMENT, this.Id)
+}
+
 type FunctionDefinitionStatement struct {
 	Token token.Token
 	Id int64
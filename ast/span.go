@@ -0,0 +1,178 @@
+package ast
+
+import (
+	"fmt"
+	"glue/token"
+	"reflect"
+	"sort"
+)
+
+// Span /**
+/*
+节点在源码中的范围，Start是节点第一个token的位置，End是最后一个token之后的位置。
+语句的范围包括末尾可选的分号，表达式外面的括号不算在表达式的范围里。
+列号跟token一样按字符计数，截取源码要用Offset
+ */
+type Span struct {
+	Start token.Position
+	End token.Position
+}
+
+func (s Span) String() string {
+	return fmt.Sprintf("%s-%d:%d", s.Start, s.End.Line, s.End.Column)
+}
+
+// Contains 位置是否在范围内，按字节偏移判断
+func (s Span) Contains(pos token.Position) bool {
+	return s.Start.Offset <= pos.Offset && pos.Offset < s.End.Offset
+}
+
+// startOf 二元运算、调用、索引从左边的子表达式开始，有语法错误的AST里子表达式可能是nil，这时用节点自己的token
+func startOf(exp Expression, tok token.Token) token.Position {
+	if exp == nil || reflect.ValueOf(exp).IsNil() {
+		return tok.Pos()
+	}
+	return exp.Span().Start
+}
+
+// endOf 前缀、二元运算的右边，if、函数、while的块
+func endOf(node Node, tok token.Token) token.Position {
+	if node == nil || reflect.ValueOf(node).IsNil() {
+		return tok.End
+	}
+	return node.Span().End
+}
+
+func (p *Program) Span() Span {
+	if len(p.Statements) == 0 {
+		return Span{}
+	}
+	return Span{Start: p.Statements[0].Span().Start, End: p.Statements[len(p.Statements)-1].Span().End}
+}
+
+func (i *Identifier) Span() Span {
+	return Span{Start: i.Token.Pos(), End: i.Token.End}
+}
+
+func (ls *LetStatement) Span() Span {
+	return Span{Start: ls.Token.Pos(), End: ls.End}
+}
+
+func (rs *ReturnStatement) Span() Span {
+	return Span{Start: rs.Token.Pos(), End: rs.End}
+}
+
+func (es *ExpressionStatement) Span() Span {
+	return Span{Start: es.Token.Pos(), End: es.End}
+}
+
+func (il *IntegerLiteral) Span() Span {
+	return Span{Start: il.Token.Pos(), End: il.Token.End}
+}
+
+func (fl *FloatLiteral) Span() Span {
+	return Span{Start: fl.Token.Pos(), End: fl.Token.End}
+}
+
+func (pe *PrefixExpression) Span() Span {
+	return Span{Start: pe.Token.Pos(), End: endOf(pe.Right, pe.Token)}
+}
+
+func (ie *InfixExpression) Span() Span {
+	return Span{Start: startOf(ie.Left, ie.Token), End: endOf(ie.Right, ie.Token)}
+}
+
+func (b *Boolean) Span() Span {
+	return Span{Start: b.Token.Pos(), End: b.Token.End}
+}
+
+func (ie *IfExpression) Span() Span {
+	if ie.ElseIf != nil {
+		return Span{Start: ie.Token.Pos(), End: ie.ElseIf.Span().End}
+	}
+	if ie.Alternative != nil {
+		return Span{Start: ie.Token.Pos(), End: ie.Alternative.Span().End}
+	}
+	return Span{Start: ie.Token.Pos(), End: endOf(ie.Consequence, ie.Token)}
+}
+
+func (bs *BlockStatement) Span() Span {
+	return Span{Start: bs.Token.Pos(), End: bs.End}
+}
+
+func (fl *FunctionLiteral) Span() Span {
+	return Span{Start: fl.Token.Pos(), End: endOf(fl.Body, fl.Token)}
+}
+
+func (ce *CallExpression) Span() Span {
+	return Span{Start: startOf(ce.Function, ce.Token), End: ce.End}
+}
+
+func (sl *StringLiteral) Span() Span {
+	return Span{Start: sl.Token.Pos(), End: sl.Token.End}
+}
+
+// Keys 哈希字面量的键按在源码中出现的顺序排列，Pairs是map，遍历的顺序不固定
+func (hl *HashLiteral) Keys() []Expression {
+	keys := make([]Expression, 0, len(hl.Pairs))
+	for key := range hl.Pairs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i].Span().Start.Offset, keys[j].Span().Start.Offset
+		if a != b {
+			return a < b
+		}
+		// 手工构造的AST没有位置信息
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+func (al *ArrayLiteral) Span() Span {
+	return Span{Start: al.Token.Pos(), End: al.End}
+}
+
+func (ie *IndexExpression) Span() Span {
+	return Span{Start: startOf(ie.Left, ie.Token), End: ie.End}
+}
+
+func (hl *HashLiteral) Span() Span {
+	return Span{Start: hl.Token.Pos(), End: hl.End}
+}
+
+func (as *AssignStatement) Span() Span {
+	return Span{Start: as.Token.Pos(), End: as.End}
+}
+
+func (ias *IndexAssignStatement) Span() Span {
+	return Span{Start: ias.Token.Pos(), End: ias.End}
+}
+
+func (ws *WhileStatement) Span() Span {
+	return Span{Start: ws.Token.Pos(), End: endOf(ws.Body, ws.Token)}
+}
+
+func (fs *ForStatement) Span() Span {
+	return Span{Start: fs.Token.Pos(), End: endOf(fs.Body, fs.Token)}
+}
+
+func (fis *ForInStatement) Span() Span {
+	return Span{Start: fis.Token.Pos(), End: endOf(fis.Body, fis.Token)}
+}
+
+func (bs *BreakStatement) Span() Span {
+	return Span{Start: bs.Token.Pos(), End: bs.End}
+}
+
+func (cs *ContinueStatement) Span() Span {
+	return Span{Start: cs.Token.Pos(), End: cs.End}
+}
+
+// FunctionDefinitionStatement的Token是函数体的右花括号，从fn关键字开始
+func (fd *FunctionDefinitionStatement) Span() Span {
+	if fd.FnLiteral == nil {
+		return Span{Start: fd.Token.Pos(), End: fd.Token.End}
+	}
+	return fd.FnLiteral.Span()
+}
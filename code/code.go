@@ -41,6 +41,15 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	OpGreaterThanOrEqual
+	OpMod
+	OpLessThan
+	OpLessThanOrEqual
+	OpSetIndex
+	OpIndexKeep
+	OpSetFree
+	OpIter
+	OpIterNext
 )
 
 type Definition struct {
@@ -79,6 +88,16 @@ var definitions = map[Opcode]*Definition{
 	OpClosure: {"OpClosure", []int{2,1}},
 	OpGetFree: {"OpGetFree", []int{1}},
 	OpCurrentClosure: { "OpCurrentClosure", []int{}}, // 处理闭包递归的问题
+	OpGreaterThanOrEqual: {"OpGreaterThanOrEqual", []int{}},
+	OpMod: {"OpMod", []int{}},
+	// <和<=不能靠交换操作数用>和>=实现，那样右边的操作数会先求值，跟evaluator从左到右的顺序不一样
+	OpLessThan: {"OpLessThan", []int{}},
+	OpLessThanOrEqual: {"OpLessThanOrEqual", []int{}},
+	OpSetIndex: {"OpSetIndex", []int{}}, // 栈顶依次是值、索引、被赋值的数组或者哈希，三个都弹出，不压入结果
+	OpIndexKeep: {"OpIndexKeep", []int{}}, // 跟OpIndex一样，但是不弹出数组（哈希）和索引，给复合赋值用
+	OpSetFree: {"OpSetFree", []int{1}}, // 弹出栈顶的值存到当前闭包捕获的自由变量里
+	OpIter: {"OpIter", []int{}}, // 弹出数组、哈希或者字符串，压入它的迭代器
+	OpIterNext: {"OpIterNext", []int{2, 1}}, // 第一个操作数是取完时跳转的目标，第二个是循环变量的个数，也就是每次压入的值的个数
 }
 
 func Lookup(op byte) (*Definition, error) {
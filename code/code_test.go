@@ -78,4 +78,44 @@ func TestReadOperands(t *testing.T) {
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+func TestSourceMapLookup(t *testing.T) {
+	sourceMap := SourceMap{
+		{Offset: 0, Line: 1, Column: 1},
+		{Offset: 3, Line: 1, Column: 5},
+		{Offset: 7, Line: 2, Column: 1},
+	}
+
+	tests := []struct {
+		offset       int
+		expectedLine int
+		expectedCol  int
+	}{
+		{0, 1, 1},
+		{2, 1, 1},
+		{3, 1, 5},
+		{6, 1, 5},
+		{7, 2, 1},
+		{100, 2, 1},
+	}
+
+	for _, tt := range tests {
+		pos, ok := sourceMap.Lookup(tt.offset)
+		if !ok {
+			t.Fatalf("no position found for offset %d", tt.offset)
+		}
+		if pos.Line != tt.expectedLine || pos.Column != tt.expectedCol {
+			t.Errorf("wrong position for offset %d. want=%d:%d, got=%d:%d",
+				tt.offset, tt.expectedLine, tt.expectedCol, pos.Line, pos.Column)
+		}
+	}
+
+	if _, ok := (SourceMap{}).Lookup(0); ok {
+		t.Errorf("empty source map should not find any position")
+	}
+
+	truncated := sourceMap.Truncate(3)
+	if len(truncated) != 1 {
+		t.Errorf("wrong length after truncate. want=1, got=%d", len(truncated))
+	}
+}
@@ -0,0 +1,48 @@
+package code
+
+import "sort"
+
+// SourcePosition /**
+/*
+一条指令对应的源码位置，Offset是指令在指令序列中的偏移量
+ */
+type SourcePosition struct {
+	Offset int
+	Line int
+	Column int
+}
+
+// SourceMap /**
+/*
+指令偏移量到源码位置的映射表，按Offset升序排列。
+只在源码位置发生变化的指令处记录一项，查找时取Offset不大于目标偏移量的最后一项，
+所以一项记录覆盖的是从它开始直到下一项之前的所有指令
+ */
+type SourceMap []SourcePosition
+
+// Lookup /**
+/*
+查找偏移量offset处的指令对应的源码位置，offset可以指向指令中间（比如操作数），结果是一样的
+ */
+func (sm SourceMap) Lookup(offset int) (SourcePosition, bool) {
+	i := sort.Search(len(sm), func(i int) bool {
+		return sm[i].Offset > offset
+	})
+	if i == 0 {
+		return SourcePosition{}, false
+	}
+
+	return sm[i-1], true
+}
+
+// Truncate /**
+/*
+丢弃从offset开始（包括offset）的所有记录，编译器删除末尾指令时需要同步删除对应的位置信息
+ */
+func (sm SourceMap) Truncate(offset int) SourceMap {
+	i := sort.Search(len(sm), func(i int) bool {
+		return sm[i].Offset >= offset
+	})
+
+	return sm[:i]
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"glue/compiler"
+	"glue/dap"
+	"glue/debugger"
+	"glue/format"
+	"glue/lexer"
+	"glue/lsp"
+	"glue/parser"
+	"glue/vm"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 进程退出码，跟直接执行源文件时保持一致
+const (
+	exitOK = 0
+	exitNotFormatted = 1 // fmt -check发现有没格式化的文件
+	exitUsage = 2
+	exitParseError = 10
+	exitRuntimeError = 20
+	exitCompileError = 30
+	exitIOError = 40
+)
+
+type command struct {
+	usage string
+	run func(args []string) int
+}
+
+// commands 子命令表，glue <command> [args]，不是子命令的第一个参数按源文件处理
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"compile": {"compile <file.gl> [-o file.glc]  compile source to a bytecode file", compileCommand},
+		"run": {"run <file.glc>  execute a compiled bytecode file", runCommand},
+		"disasm": {"disasm <file.gl|file.glc>  disassemble the main program and every function", disasmCommand},
+		"dap": {"dap  serve the Debug Adapter Protocol on stdin/stdout for editors", dapCommand},
+		"lsp": {"lsp  serve the Language Server Protocol on stdin/stdout for editors", lspCommand},
+		"fmt": {"fmt [-w] [-check] <file.gl>...  format source files in the canonical style", fmtCommand},
+		"debug": {"debug <file.gl> [-b line]...  run a source file in the interactive step debugger", debugCommand},
+		"help": {"help  show this message", helpCommand},
+	}
+}
+
+func helpCommand(args []string) int {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println("usage: glue [-i] [-engine vm|eval] <file.gl>")
+	fmt.Println("       glue <command> [arguments]")
+	fmt.Println()
+	fmt.Println("commands:")
+	for _, name := range names {
+		fmt.Printf("  %s\n", commands[name].usage)
+	}
+	return exitOK
+}
+
+// parseArgs /**
+/*
+flag包遇到第一个非flag参数就停止解析，这里允许源文件名出现在flag前面，比如 glue compile foo.gl -o foo.glc
+ */
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func compileCommand(args []string) int {
+	fs := flag.NewFlagSet("compile", flag.ContinueOnError)
+	output := fs.String("o", "", "output file, defaults to the source file name with a .glc extension")
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(files) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: glue "+commands["compile"].usage)
+		return exitUsage
+	}
+	src := files[0]
+	if *output == "" {
+		*output = strings.TrimSuffix(src, filepath.Ext(src)) + ".glc"
+	}
+
+	bytecode, code := compileFile(src)
+	if bytecode == nil {
+		return code
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIOError
+	}
+	err = bytecode.Encode(f, filepath.Base(src))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "write %s: %s\n", *output, err)
+		os.Remove(*output)
+		return exitIOError
+	}
+	return exitOK
+}
+
+func runCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: glue "+commands["run"].usage)
+		return exitUsage
+	}
+
+	bytecode, _, code := loadBytecode(args[0])
+	if bytecode == nil {
+		return code
+	}
+
+	machine := vm.New(bytecode)
+	if err := machine.Run(); err != nil {
+		if re, ok := err.(*vm.RuntimeError); ok {
+			fmt.Fprint(os.Stderr, re.StackTrace())
+		}else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return exitRuntimeError
+	}
+	return exitOK
+}
+
+func disasmCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: glue "+commands["disasm"].usage)
+		return exitUsage
+	}
+	file := args[0]
+
+	// 既可以是编译好的字节码文件，也可以是源文件，源文件在输出中可以带上对应的源码行
+	var source []string
+	var bytecode *compiler.Bytecode
+	var code int
+	if isSourceFile(file) {
+		if bytecode, code = compileFile(file); bytecode == nil {
+			return code
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitIOError
+		}
+		source = strings.Split(string(data), "\n")
+	}else if bytecode, _, code = loadBytecode(file); bytecode == nil {
+		return code
+	}
+
+	if err := compiler.Disassemble(os.Stdout, bytecode, source); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIOError
+	}
+	return exitOK
+}
+
+// lineList 可以重复出现的行号参数，比如 -b 3 -b 7
+type lineList []int
+
+func (l *lineList) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *lineList) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid line number %q", s)
+	}
+	*l = append(*l, n)
+	return nil
+}
+
+func debugCommand(args []string) int {
+	fs := flag.NewFlagSet("debug", flag.ContinueOnError)
+	var breakpoints lineList
+	fs.Var(&breakpoints, "b", "set a breakpoint on the source line, can be repeated")
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(files) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: glue "+commands["debug"].usage)
+		return exitUsage
+	}
+	src := files[0]
+
+	// 调试需要源码和符号表，不支持字节码文件
+	bytecode, symbols, code := compileFileWithSymbols(src)
+	if bytecode == nil {
+		return code
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIOError
+	}
+
+	d := debugger.New(symbols, strings.Split(string(data), "\n"), os.Stdin, os.Stdout)
+	for _, line := range breakpoints {
+		d.SetBreakpoint(line)
+	}
+	machine := vm.New(bytecode)
+	d.Attach(machine)
+	if err := machine.Run(); err != nil {
+		if errors.Is(err, debugger.ErrQuit) {
+			return exitOK
+		}
+		if re, ok := err.(*vm.RuntimeError); ok {
+			fmt.Fprint(os.Stderr, re.StackTrace())
+		}else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return exitRuntimeError
+	}
+	return exitOK
+}
+
+// dapCommand /**
+/*
+stdin/stdout用作DAP的传输通道，脚本里print输出到stdout的内容会破坏协议消息，
+所以把os.Stdout换成管道，读到的内容作为output事件转发给编辑器
+ */
+func dapCommand(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: glue "+commands["dap"].usage)
+		return exitUsage
+	}
+
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIOError
+	}
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdout
+		w.Close()
+	}()
+
+	server := dap.NewServer(os.Stdin, stdout)
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				server.Output("stdout", string(buf[:n]))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	// 程序结束后关闭管道，等输出都转发完再发送exited事件，编辑器才不会丢掉最后的输出
+	server.OnExit(func() {
+		w.Close()
+		<-forwarded
+	})
+
+	if err := server.Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIOError
+	}
+	return exitOK
+}
+
+func lspCommand(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: glue "+commands["lsp"].usage)
+		return exitUsage
+	}
+
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIOError
+	}
+	return exitOK
+}
+
+// fmtCommand /**
+/*
+默认把格式化的结果输出到stdout；-w直接改写源文件，已经格式化过的文件不动；
+-check只列出没有格式化的文件，有的话退出码非0，给CI用
+ */
+func fmtCommand(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := fs.Bool("w", false, "write the result back to the source file instead of stdout")
+	check := fs.Bool("check", false, "list files whose formatting differs and exit with a non-zero status")
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(files) == 0 || *write && *check {
+		fmt.Fprintln(os.Stderr, "usage: glue "+commands["fmt"].usage)
+		return exitUsage
+	}
+
+	code := exitOK
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = exitIOError
+			continue
+		}
+		out, err := format.Source(data)
+		if err != nil {
+			if fe, ok := err.(*format.Error); ok {
+				for _, msg := range fe.Errors {
+					fmt.Fprintf(os.Stderr, "%s:%s\n", file, msg)
+				}
+			}else {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+			}
+			code = exitParseError
+			continue
+		}
+
+		switch {
+		case *check:
+			if string(out) != string(data) {
+				fmt.Println(file)
+				if code == exitOK {
+					code = exitNotFormatted
+				}
+			}
+		case *write:
+			if string(out) == string(data) {
+				continue
+			}
+			info, err := os.Stat(file)
+			if err == nil {
+				err = os.WriteFile(file, out, info.Mode().Perm())
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				code = exitIOError
+			}
+		default:
+			os.Stdout.Write(out)
+		}
+	}
+	return code
+}
+
+// isSourceFile 不是字节码文件（文件头不是字节码的魔数）就当作源文件
+func isSourceFile(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, _, err = compiler.DecodeBytecode(f, nil)
+	return errors.Is(err, compiler.ErrNotBytecode)
+}
+
+// compileFile 解析并编译源文件，出错时输出错误信息，返回nil和对应的退出码
+func compileFile(src string) (*compiler.Bytecode, int) {
+	bytecode, _, code := compileFileWithSymbols(src)
+	return bytecode, code
+}
+
+// compileFileWithSymbols 同compileFile，另外返回编译得到的全局符号表
+func compileFileWithSymbols(src string) (*compiler.Bytecode, *compiler.SymbolTable, int) {
+	if _, err := os.Stat(src); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, exitIOError
+	}
+
+	l := lexer.NewFromFile(src)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if p.HasError() {
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", src, msg)
+		}
+		return nil, nil, exitParseError
+	}
+
+	c := compiler.New()
+	err := c.Compile(program)
+	printDiagnostics(src, c.Diagnostics())
+	if err != nil {
+		return nil, nil, exitCompileError
+	}
+	return c.Bytecode(), c.SymbolTable(), exitOK
+}
+
+// loadBytecode 读取编译好的字节码文件，出错时输出错误信息，返回nil和对应的退出码
+func loadBytecode(file string) (*compiler.Bytecode, *compiler.Metadata, int) {
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, exitIOError
+	}
+	defer f.Close()
+
+	bytecode, meta, err := compiler.DecodeBytecode(f, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+		return nil, nil, exitIOError
+	}
+	return bytecode, meta, exitOK
+}
+
+func printDiagnostics(file string, diagnostics compiler.Diagnostics) {
+	for _, d := range diagnostics {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", file, d)
+	}
+}
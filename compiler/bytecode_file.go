@@ -0,0 +1,397 @@
+package compiler
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"glue/code"
+	"glue/object"
+	"io"
+	"math"
+)
+
+// 编译结果的二进制文件格式（.glc），所有整数都是大端序，跟指令操作数的编码方式一致：
+//
+//	magic          4字节 "GLUC"
+//	version        uint16
+//	sourceFile     string                源文件名，只用于展示
+//	builtins       uint32个数 + string*  编译时内置函数的名字，OpGetBuiltin的操作数是它们的索引
+//	constants      uint32个数 + constant*
+//	instructions   bytes                 主指令序列
+//	sourceMap      sourceMap             主指令序列的源码位置映射
+//
+// string和bytes都是uint32长度加内容，sourceMap是uint32个数加(offset, line, column)三个uint32。
+// constant是1字节类型标记加内容：
+//
+//	Integer           int64
+//	Float             float64的IEEE 754位模式
+//	String            string
+//	CompiledFunction  name string, numLocals uint32, numParameters uint32, instructions bytes, sourceMap
+//
+// 函数体里引用的常量和主指令序列在同一个常量池里，所以函数不需要嵌套存储自己的常量
+
+const (
+	BytecodeMagic = "GLUC"
+	BytecodeVersion uint16 = 1
+)
+
+const (
+	constantInteger byte = iota + 1
+	constantFloat
+	constantString
+	constantCompiledFunction
+)
+
+var ErrNotBytecode = errors.New("not a glue bytecode file")
+
+// Metadata /**
+/*
+字节码文件头部的信息，跟执行无关，加载时用来做兼容性检查和展示
+ */
+type Metadata struct {
+	Version uint16
+	SourceFile string
+	Builtins []string
+}
+
+// Encode /**
+/*
+把字节码写成.glc格式，sourceFile是编译的源文件名，可以为空
+ */
+func (b *Bytecode) Encode(w io.Writer, sourceFile string) error {
+	bw := bufio.NewWriter(w)
+	e := &encoder{w: bw}
+
+	e.write([]byte(BytecodeMagic))
+	e.uint16(BytecodeVersion)
+	e.string(sourceFile)
+
+	builtins := b.Builtins
+	if builtins == nil {
+		builtins = object.DefaultBuiltins()
+	}
+	names := builtins.Names()
+	e.uint32(len(names))
+	for _, name := range names {
+		e.string(name)
+	}
+
+	e.uint32(len(b.Constants))
+	for i, constant := range b.Constants {
+		if err := e.constant(constant); err != nil {
+			return fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+
+	e.bytes(b.Instructions)
+	e.sourceMap(b.SourceMap)
+
+	if e.err != nil {
+		return e.err
+	}
+	return bw.Flush()
+}
+
+// DecodeBytecode /**
+/*
+读取Encode写出的字节码，builtins是执行时要用的内置函数表，为nil时使用标准内置函数表。
+除了格式本身，还会检查版本号、文件里的内置函数是否跟builtins一致，以及指令是否都能被识别，
+不兼容的文件在加载时就报错，而不是等到VM执行到一半才出问题
+ */
+func DecodeBytecode(r io.Reader, builtins *object.BuiltinRegistry) (*Bytecode, *Metadata, error) {
+	if builtins == nil {
+		builtins = object.DefaultBuiltins()
+	}
+
+	d := &decoder{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(BytecodeMagic))
+	if _, err := io.ReadFull(d.r, magic); err != nil || string(magic) != BytecodeMagic {
+		return nil, nil, ErrNotBytecode
+	}
+
+	meta := &Metadata{}
+	meta.Version = d.uint16()
+	if d.err == nil && meta.Version != BytecodeVersion {
+		return nil, nil, fmt.Errorf("unsupported bytecode version %d, want %d", meta.Version, BytecodeVersion)
+	}
+	meta.SourceFile = d.string()
+
+	numBuiltins := d.uint32()
+	for i := 0; i < numBuiltins && d.err == nil; i++ {
+		meta.Builtins = append(meta.Builtins, d.string())
+	}
+	if d.err != nil {
+		return nil, nil, d.failed()
+	}
+	if err := checkBuiltins(meta.Builtins, builtins); err != nil {
+		return nil, nil, err
+	}
+
+	bytecode := &Bytecode{Builtins: builtins}
+	numConstants := d.uint32()
+	for i := 0; i < numConstants && d.err == nil; i++ {
+		bytecode.Constants = append(bytecode.Constants, d.constant())
+	}
+	bytecode.Instructions = d.bytes()
+	bytecode.SourceMap = d.sourceMap()
+	if d.err != nil {
+		return nil, nil, d.failed()
+	}
+
+	// 主程序没有局部变量
+	if err := checkInstructions(bytecode.Instructions, len(bytecode.Constants), len(meta.Builtins), 0); err != nil {
+		return nil, nil, fmt.Errorf("main instructions: %w", err)
+	}
+	for i, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			if err := checkInstructions(fn.Instructions, len(bytecode.Constants), len(meta.Builtins), fn.NumLocals); err != nil {
+				return nil, nil, fmt.Errorf("constant %d (fn %s): %w", i, fn.DisplayName(), err)
+			}
+		}
+	}
+
+	return bytecode, meta, nil
+}
+
+// checkBuiltins 文件里的内置函数必须是运行时内置函数表的前缀，运行时可以新增，但不能删除或者调换顺序
+func checkBuiltins(names []string, builtins *object.BuiltinRegistry) error {
+	if len(names) > builtins.Len() {
+		return fmt.Errorf("bytecode needs %d builtins, runtime only has %d", len(names), builtins.Len())
+	}
+	for i, name := range names {
+		if builtins.At(i).Name != name {
+			return fmt.Errorf("builtin %d is %q in bytecode but %q in runtime", i, name, builtins.At(i).Name)
+		}
+	}
+	return nil
+}
+
+// checkInstructions /**
+/*
+检查一段指令能不能安全地交给VM执行：指令完整，常量、内置函数、全局变量和局部变量的索引都在范围内，
+跳转目标是某条指令的开头。跳转目标也可以是指令序列的末尾，主程序最后一条语句是循环时条件不成立就跳到那里结束执行
+ */
+func checkInstructions(ins code.Instructions, numConstants, numBuiltins, numLocals int) error {
+	starts := make(map[int]bool)
+	var jumps [][2]int // 跳转指令的位置和目标
+	for i := 0; i < len(ins); {
+		starts[i] = true
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return fmt.Errorf("offset %d: %w", i, err)
+		}
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			return fmt.Errorf("offset %d: truncated %s", i, def.Name)
+		}
+		operands, _ := code.ReadOperands(def, ins[i+1:])
+		switch code.Opcode(ins[i]) {
+		case code.OpConstant, code.OpClosure:
+			if operands[0] >= numConstants {
+				return fmt.Errorf("offset %d: constant index %d out of range", i, operands[0])
+			}
+		case code.OpGetBuiltin:
+			if operands[0] >= numBuiltins {
+				return fmt.Errorf("offset %d: builtin index %d out of range", i, operands[0])
+			}
+		case code.OpGetGlobal, code.OpSetGlobal:
+			if operands[0] >= GlobalSize {
+				return fmt.Errorf("offset %d: global index %d out of range", i, operands[0])
+			}
+		case code.OpGetLocal, code.OpSetLocal:
+			if operands[0] >= numLocals {
+				return fmt.Errorf("offset %d: local index %d out of range", i, operands[0])
+			}
+		case code.OpJump, code.OpJumpNotTruthy, code.OpIterNext:
+			jumps = append(jumps, [2]int{i, operands[0]})
+		}
+		i += 1 + width
+	}
+	for _, jump := range jumps {
+		if target := jump[1]; target != len(ins) && !starts[target] {
+			return fmt.Errorf("offset %d: jump target %d out of range", jump[0], target)
+		}
+	}
+	return nil
+}
+
+// encoder 写入出错后后面的写入都忽略，最后统一检查err，省得每一步都判断
+type encoder struct {
+	w io.Writer
+	err error
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(p)
+}
+
+func (e *encoder) uint16(n uint16) {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], n)
+	e.write(buf[:])
+}
+
+func (e *encoder) uint32(n int) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(n))
+	e.write(buf[:])
+}
+
+func (e *encoder) uint64(n uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], n)
+	e.write(buf[:])
+}
+
+func (e *encoder) bytes(p []byte) {
+	e.uint32(len(p))
+	e.write(p)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+func (e *encoder) sourceMap(sm code.SourceMap) {
+	e.uint32(len(sm))
+	for _, pos := range sm {
+		e.uint32(pos.Offset)
+		e.uint32(pos.Line)
+		e.uint32(pos.Column)
+	}
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.write([]byte{constantInteger})
+		e.uint64(uint64(obj.Value))
+	case *object.Float:
+		e.write([]byte{constantFloat})
+		e.uint64(math.Float64bits(obj.Value))
+	case *object.String:
+		e.write([]byte{constantString})
+		e.string(obj.Value)
+	case *object.CompiledFunction:
+		e.write([]byte{constantCompiledFunction})
+		e.string(obj.Name)
+		e.uint32(obj.NumLocals)
+		e.uint32(obj.NumParameters)
+		e.bytes(obj.Instructions)
+		e.sourceMap(obj.SourceMap)
+	default:
+		return fmt.Errorf("can't serialize constant of type %s", obj.Type())
+	}
+	return nil
+}
+
+type decoder struct {
+	r *bufio.Reader
+	err error
+}
+
+func (d *decoder) failed() error {
+	if d.err == io.EOF || d.err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("truncated bytecode file")
+	}
+	return d.err
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	buf := make([]byte, n)
+	_, d.err = io.ReadFull(d.r, buf)
+	return buf
+}
+
+func (d *decoder) uint16() uint16 {
+	buf := d.read(2)
+	if d.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(buf)
+}
+
+func (d *decoder) uint32() int {
+	buf := d.read(4)
+	if d.err != nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(buf))
+}
+
+func (d *decoder) uint64() uint64 {
+	buf := d.read(8)
+	if d.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(buf)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if d.err != nil {
+		return nil
+	}
+	// 长度是从文件里读的，不可信，按块读，文件被截断时不会先分配一大块内存
+	var out []byte
+	for n > 0 && d.err == nil {
+		chunk := n
+		if chunk > 64*1024 {
+			chunk = 64 * 1024
+		}
+		out = append(out, d.read(chunk)...)
+		n -= chunk
+	}
+	return out
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) sourceMap() code.SourceMap {
+	n := d.uint32()
+	var sm code.SourceMap
+	for i := 0; i < n && d.err == nil; i++ {
+		sm = append(sm, code.SourcePosition{Offset: d.uint32(), Line: d.uint32(), Column: d.uint32()})
+	}
+	return sm
+}
+
+func (d *decoder) constant() object.Object {
+	tag := d.read(1)
+	if d.err != nil {
+		return nil
+	}
+
+	switch tag[0] {
+	case constantInteger:
+		return &object.Integer{Value: int64(d.uint64())}
+	case constantFloat:
+		return &object.Float{Value: math.Float64frombits(d.uint64())}
+	case constantString:
+		return &object.String{Value: d.string()}
+	case constantCompiledFunction:
+		fn := &object.CompiledFunction{}
+		fn.Name = d.string()
+		fn.NumLocals = d.uint32()
+		fn.NumParameters = d.uint32()
+		fn.Instructions = d.bytes()
+		fn.SourceMap = d.sourceMap()
+		return fn
+	default:
+		d.err = fmt.Errorf("unknown constant type %d", tag[0])
+		return nil
+	}
+}
@@ -0,0 +1,154 @@
+package compiler
+
+import (
+	"bytes"
+	"glue/code"
+	"glue/object"
+	"reflect"
+	"testing"
+)
+
+func TestBytecodeEncodeDecode(t *testing.T) {
+	input := `
+let ratio = 3 / 4.0;
+let greet = fn(name) { "hello, " + name };
+let counter = fn() {
+	let n = 0;
+	fn inc() { n + 1 }
+};
+greet("glue");
+counter()();
+len([1, 2, 3]);
+`
+	program := parse(input)
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	expected := compiler.Bytecode()
+
+	var buf bytes.Buffer
+	if err := expected.Encode(&buf, "test.gl"); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	actual, meta, err := DecodeBytecode(&buf, nil)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	if meta.Version != BytecodeVersion || meta.SourceFile != "test.gl" {
+		t.Errorf("wrong metadata. got=%+v", meta)
+	}
+	if len(meta.Builtins) != len(object.Builtins) {
+		t.Errorf("wrong number of builtins. want=%d, got=%d", len(object.Builtins), len(meta.Builtins))
+	}
+	if !bytes.Equal(actual.Instructions, expected.Instructions) {
+		t.Errorf("wrong instructions.\nwant=%q\ngot =%q", expected.Instructions, actual.Instructions)
+	}
+	if !reflect.DeepEqual(actual.SourceMap, expected.SourceMap) {
+		t.Errorf("wrong source map.\nwant=%v\ngot =%v", expected.SourceMap, actual.SourceMap)
+	}
+	if len(actual.Constants) != len(expected.Constants) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d", len(expected.Constants), len(actual.Constants))
+	}
+	for i, want := range expected.Constants {
+		got := actual.Constants[i]
+		if wantFn, ok := want.(*object.CompiledFunction); ok {
+			gotFn, ok := got.(*object.CompiledFunction)
+			if !ok {
+				t.Errorf("constant %d is not CompiledFunction. got=%T", i, got)
+				continue
+			}
+			if gotFn.Name != wantFn.Name || gotFn.NumLocals != wantFn.NumLocals ||
+				gotFn.NumParameters != wantFn.NumParameters ||
+				!bytes.Equal(gotFn.Instructions, wantFn.Instructions) ||
+				!reflect.DeepEqual(gotFn.SourceMap, wantFn.SourceMap) {
+				t.Errorf("constant %d: wrong function.\nwant=%+v\ngot =%+v", i, wantFn, gotFn)
+			}
+			continue
+		}
+		if got.Type() != want.Type() || got.Inspect() != want.Inspect() {
+			t.Errorf("constant %d: want=%s %s, got=%s %s", i, want.Type(), want.Inspect(), got.Type(), got.Inspect())
+		}
+	}
+}
+
+func TestDecodeInvalidBytecode(t *testing.T) {
+	program := parse(`let f = fn(x) { x * 2 }; f(21);`)
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := compiler.Bytecode().Encode(&buf, ""); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	valid := buf.Bytes()
+
+	badVersion := append([]byte{}, valid...)
+	badVersion[len(BytecodeMagic)+1] = 99
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"source file", []byte("let a = 1;")},
+		{"version", badVersion},
+		{"truncated", valid[:len(valid)-3]},
+		{"truncated header", valid[:len(BytecodeMagic)+1]},
+	}
+
+	for _, tt := range tests {
+		_, _, err := DecodeBytecode(bytes.NewReader(tt.data), nil)
+		if err == nil {
+			t.Errorf("%s: expected error, got none", tt.name)
+		}
+	}
+}
+
+func TestDecodeRejectsBadOperands(t *testing.T) {
+	fn := &object.CompiledFunction{
+		Instructions: concatInstructions([]code.Instructions{
+			code.Make(code.OpGetLocal, 1),
+			code.Make(code.OpReturnValue),
+		}),
+		NumLocals: 1,
+		NumParameters: 1,
+	}
+
+	tests := []struct {
+		name string
+		instructions []code.Instructions
+		constants []object.Object
+		valid bool
+	}{
+		// 主程序最后是循环时条件不成立会跳到指令序列末尾
+		{"jump to end", []code.Instructions{code.Make(code.OpFalse), code.Make(code.OpJumpNotTruthy, 7), code.Make(code.OpJump, 0)}, nil, true},
+		{"jump out of range", []code.Instructions{code.Make(code.OpJump, 100)}, nil, false},
+		{"jump into operand", []code.Instructions{code.Make(code.OpTrue), code.Make(code.OpJumpNotTruthy, 2)}, nil, false},
+		{"iteration target out of range", []code.Instructions{code.Make(code.OpArray, 0), code.Make(code.OpIter), code.Make(code.OpIterNext, 50, 1)}, nil, false},
+		{"local in main", []code.Instructions{code.Make(code.OpGetLocal, 0)}, nil, false},
+		{"local out of range", []code.Instructions{code.Make(code.OpClosure, 0, 0)}, []object.Object{fn}, false},
+	}
+
+	for _, tt := range tests {
+		bytecode := &Bytecode{
+			Instructions: concatInstructions(tt.instructions),
+			Constants: tt.constants,
+			Builtins: object.DefaultBuiltins(),
+		}
+		var buf bytes.Buffer
+		if err := bytecode.Encode(&buf, ""); err != nil {
+			t.Fatalf("%s: encode error: %s", tt.name, err)
+		}
+		_, _, err := DecodeBytecode(&buf, nil)
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected error, got none", tt.name)
+		}
+	}
+}
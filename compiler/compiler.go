@@ -5,12 +5,18 @@ import (
 	"glue/ast"
 	"glue/code"
 	"glue/object"
+	"glue/token"
 	"sort"
 )
 
+// GlobalSize 全局变量个数的上限，VM按这个大小分配全局变量表，加载字节码文件时用它检查全局变量的索引
+const GlobalSize = 65536
+
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants []object.Object
+	SourceMap code.SourceMap // 主指令序列的源码位置映射，函数的映射在各自的CompiledFunction中
+	Builtins *object.BuiltinRegistry // 编译时使用的内置函数表，OpGetBuiltin的操作数是其中的索引
 }
 
 type EmittedInstruction struct {
@@ -22,6 +28,21 @@ type CompilationScope struct {
 	instructions code.Instructions
 	lastInstruction EmittedInstruction
 	previousInstruction EmittedInstruction
+
+	sourceMap code.SourceMap
+
+	// 当前函数作用域内正在编译的循环，最内层的在最后。每个函数有自己的循环栈，所以闭包看不到外层函数的循环
+	loops []*loopScope
+}
+
+// loopScope /**
+/*
+记录一个循环中break和continue生成的跳转指令的位置，循环编译完之后统一回填跳转目标
+ */
+type loopScope struct {
+	breakPositions []int
+	continuePositions []int
+	iterator bool // for-in循环，循环期间迭代器在栈顶
 }
 
 type Compiler struct {
@@ -36,18 +57,25 @@ type Compiler struct {
 
 	scopes []CompilationScope // 行为上，作用域是个栈
 	scopeIndex int // 栈指针
+
+	position code.SourcePosition // 正在编译的节点在源码中的位置，生成的指令都记到这个位置上
+
+	diagnostics Diagnostics // 编译过程中收集到的错误和警告，按发现的先后顺序排列
 }
 
 func New() *Compiler {
+	return NewWithBuiltins(object.DefaultBuiltins())
+}
+
+// NewWithBuiltins 使用指定的内置函数表，脚本里只能调用其中的内置函数
+func NewWithBuiltins(builtins *object.BuiltinRegistry) *Compiler {
 	mainScope := CompilationScope{
 		instructions: code.Instructions{},
 		lastInstruction: EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
 	}
 	symbolTable := NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	symbolTable.DefineBuiltins(builtins)
 	return &Compiler{
 		//instructions: code.Instructions{},
 		constants: []object.Object{},
@@ -72,6 +100,10 @@ func (c *Compiler) Info() {
 	fmt.Printf("symbolTables len: %#v\n", i)
 }
 
+// NewWithState /**
+/*
+在已有的符号表和常量池上继续编译（REPL逐行编译）。内置函数表取自符号表，s没有调用过DefineBuiltins时使用标准内置函数表
+ */
 func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	compiler := New()
 	compiler.symbolTable = s
@@ -84,6 +116,44 @@ func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
 
+// 复合赋值运算符对应的运算指令
+var compoundOperators = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+	"%=": code.OpMod,
+}
+
+// emitCompoundOperator 复合赋值时生成运算指令，普通的赋值什么也不做
+func (c *Compiler) emitCompoundOperator(tok token.Token, operator string) {
+	if operator == "=" {
+		return
+	}
+	op, ok := compoundOperators[operator]
+	if !ok {
+		c.errorf(tok, "unknown operator %s", operator)
+		return
+	}
+	c.emit(op)
+}
+
+// setSymbol /**
+/*
+把栈顶的值存到变量里。闭包捕获自由变量时复制了一份值，给自由变量赋值只改闭包自己的这一份，
+同一个闭包后面的调用能看到新值，定义这个变量的外层函数看不到
+ */
+func (c *Compiler) setSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
@@ -99,22 +169,53 @@ func (c *Compiler) loadSymbol(s Symbol) {
 	}
 }
 
+// Compile /**
+/*
+编译一个节点。遇到错误不会立即返回，而是记录一条诊断信息后继续编译剩下的部分，这样一遍就能把所有错误都找出来。
+本次编译中产生了error级别的诊断时返回Diagnostics（只包含本次产生的），否则返回nil，warning不影响编译结果，
+可以通过c.Diagnostics()取到
+ */
 func (c *Compiler) Compile(node ast.Node) error {
+	start := len(c.diagnostics)
+	c.compile(node)
+
+	errs := c.diagnostics[start:].Errors()
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Diagnostics 返回编译器到目前为止收集到的所有诊断信息，包括warning
+func (c *Compiler) Diagnostics() Diagnostics {
+	return c.diagnostics
+}
+
+// SymbolTable 当前作用域的符号表，编译结束后就是全局符号表，调试器用它按名字查找全局变量
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+func (c *Compiler) compile(node ast.Node) {
+	// 编译一个节点前先切换到它的源码位置，编译完恢复成外层节点的位置，这样像OpAdd、OpCall这种在子节点之后才生成的指令
+	// 也能对应到正确的位置。没有位置信息的token（行号为0）沿用外层节点的位置
+	if tok, ok := nodeToken(node); ok && tok.LineNum > 0 {
+		outer := c.position
+		c.position = code.SourcePosition{Line: tok.LineNum, Column: tok.ColumnNum}
+		defer func() {
+			c.position = outer
+		}()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
-			}
+			c.compile(s)
 		}
 	case *ast.LetStatement:
 		// 生成符号，加入到当前作用域对应的符号表，当前作用域是在编译函数字面量的时候确定的，在此处处理 case *ast.FunctionLiteral:
 		symbol := c.symbolTable.Define(node.Name.Value)
-		err := c.Compile(node.Value)
-		if err != nil {
-			return err
-		}
+		c.compile(node.Value)
 
 		//编译器只需要确定当前处理的符号是个本地变量还是全局变量，而不需要关心嵌套了几层
 		// 只要是局部变量就生成局部指令OpSetLocal, 而到底要从哪一层作用域取出绑定的数据有VM在运行时完成
@@ -128,31 +229,49 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.AssignStatement:
 		symbol, ok := c.symbolTable.Resolve(node.Lhs.Value)
 		if !ok {
-			// 先粗暴处理下错误信息
-			panic(fmt.Sprintf("undefined symbol %s.", node.Lhs.Value))
-		}
-		err := c.Compile(node.Rhs)
-		if err != nil {
-			return err
-		}
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		}else {
-			c.emit(code.OpSetLocal, symbol.Index)
-		}
+			c.errorf(node.Lhs.Token, "undefined variable %s", node.Lhs.Value)
+			// 右边的表达式照常编译，里面可能还有别的错误
+			c.compile(node.Rhs)
+			c.emit(code.OpPop)
+			return
+		}
+		switch symbol.Scope {
+		case BuiltinScope:
+			c.errorf(node.Lhs.Token, "cannot assign to builtin function %s", node.Lhs.Value)
+			c.compile(node.Rhs)
+			c.emit(code.OpPop)
+			return
+		case FunctionScope:
+			c.errorf(node.Lhs.Token, "cannot assign to function %s inside its own body", node.Lhs.Value)
+			c.compile(node.Rhs)
+			c.emit(code.OpPop)
+			return
+		}
+		// 复合赋值先取出变量原来的值，再跟右边的值做运算
+		if node.Operator != "=" {
+			c.loadSymbol(symbol)
+		}
+		c.compile(node.Rhs)
+		c.emitCompoundOperator(node.Token, node.Operator)
+		c.setSymbol(symbol)
+	case *ast.IndexAssignStatement:
+		// 先求出被赋值的对象和索引，再求值，跟读取时的顺序一致。
+		// 复合赋值用OpIndexKeep取出原来的值，对象和索引留在栈上给OpSetIndex用，所以它们只求一次值
+		c.compile(node.Target.Left)
+		c.compile(node.Target.Index)
+		if node.Operator != "=" {
+			c.emit(code.OpIndexKeep)
+		}
+		c.compile(node.Value)
+		c.emitCompoundOperator(node.Token, node.Operator)
+		c.emit(code.OpSetIndex)
 	case *ast.ExpressionStatement:
-		err := c.Compile(node.Expression)
-		if err != nil {
-			return err
-		}
+		c.compile(node.Expression)
 		c.emit(code.OpPop)
 	case *ast.FunctionDefinitionStatement:
 		// 生成符号，加入到当前作用域对应的符号表，当前作用域是在编译函数字面量的时候确定的，在此处处理 case *ast.FunctionLiteral:
 		symbol := c.symbolTable.Define(node.FnLiteral.Name.Value)
-		err := c.Compile(node.FnLiteral)
-		if err != nil {
-			return err
-		}
+		c.compile(node.FnLiteral)
 
 		//编译器只需要确定当前处理的符号是个本地变量还是全局变量，而不需要关心嵌套了几层
 		// 只要是局部变量就生成局部指令OpSetLocal, 而到底要从哪一层作用域取出绑定的数据有VM在运行时完成
@@ -164,40 +283,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpSetLocal, symbol.Index)
 		}
 	case *ast.PrefixExpression:
-		err := c.Compile(node.Right)
-		if err != nil {
-			return err
-		}
+		c.compile(node.Right)
 		switch node.Operator {
 		case "!":
 			c.emit(code.OpBang)
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			c.errorf(node.Token, "unknown operator %s", node.Operator)
 		}
 	case *ast.InfixExpression:
-		if node.Operator == "<" { // reorder the operands for "<"
-			err := c.Compile(node.Right)
-			if err != nil {
-				return err
-			}
-			err = c.Compile(node.Left)
-			if err != nil {
-				return err
-			}
-			c.emit(code.OpGreaterThan)
-			return nil
-		}
-
-		err := c.Compile(node.Left)
-		if err != nil {
-			return err
-		}
-		err = c.Compile(node.Right)
-		if err != nil {
-			return err
+		if node.Operator == "&&" || node.Operator == "||" {
+			c.compileLogicalExpression(node)
+			return
 		}
+		c.compile(node.Left)
+		c.compile(node.Right)
 		switch node.Operator {
 		case "+":
 			c.emit(code.OpAdd)
@@ -207,65 +308,199 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanOrEqual)
+		case "<":
+			c.emit(code.OpLessThan)
+		case "<=":
+			c.emit(code.OpLessThanOrEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknow operator %s", node.Operator)
+			c.errorf(node.Token, "unknown operator %s", node.Operator)
 		}
 	case *ast.IfExpression:
-		err := c.Compile(node.Condition)
-		if err != nil {
-			return err
-		}
+		// else if链依次编译成平铺的分支：条件不成立跳到下一个分支的条件，每个分支执行完都直接跳到整个链的末尾
+		var jumpPositions []int
+		for {
+			c.compile(node.Condition)
+
+			//9999这里只是个随意占位符，小于65535就可以，因为操作数目前设置宽度为2字节，超出2字节会导致指令对齐出错
+			// if not true, jump over the consequence block
+			jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+			c.compile(node.Consequence)
+			//如果以后不把if作为表达式，而是作为语句，这里的tricky处理需要去掉
+			if c.lastInstructionIs(code.OpPop) {
+				c.removeLastPop()
+			}else {
+				// 块以let、赋值、while等语句结尾（或者是空块）时不会产生值，补一个null，保证if表达式总有一个值留在栈上
+				c.emit(code.OpNull)
+			}
 
-		//9999这里只是个随意占位符，小于65535就可以，因为操作数目前设置宽度为2字节，超出2字节会导致指令对齐出错
-		// if not true, jump over the consequence block
-		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
-		err = c.Compile(node.Consequence)
-		if err != nil {
-			return err
-		}
-		//如果以后不把if作为表达式，而是作为语句，这里的tricky处理需要去掉
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
+			// jump over the alternative block
+			jumpPositions = append(jumpPositions, c.emit(code.OpJump, 9999))
 
-		// jump over the alternative block
-		jumpPos := c.emit(code.OpJump, 9999)
+			afterConsequencePos := len(c.currentInstructions())
+			c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
 
-		afterConsequencePos := len(c.currentInstructions())
-		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+			if node.ElseIf == nil {
+				break
+			}
+			node = node.ElseIf
+		}
 
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
 		}else {
 
-			err := c.Compile(node.Alternative)
-			if err != nil {
-				return err
-			}
+			c.compile(node.Alternative)
 
 			if c.lastInstructionIs(code.OpPop) {
 				c.removeLastPop()
+			}else {
+				c.emit(code.OpNull)
 			}
 		}
 
 		afterAlternativePos := len(c.currentInstructions())
-		c.changeOperand(jumpPos, afterAlternativePos)
+		for _, pos := range jumpPositions {
+			c.changeOperand(pos, afterAlternativePos)
+		}
+	case *ast.WhileStatement:
+		// 循环开始的位置，也就是条件表达式的第一条指令，循环体执行完后跳回这里重新计算条件
+		loopStartPos := len(c.currentInstructions())
+
+		c.compile(node.Condition)
+
+		// 条件不成立就跳出循环，目标地址要等循环体编译完才知道，先占位，后面回填
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		c.enterLoop()
+		c.compile(node.Body)
+
+		c.emit(code.OpJump, loopStartPos)
+
+		afterBodyPos := len(c.currentInstructions())
+		c.changeOperand(jumpNotTruthyPos, afterBodyPos)
+
+		loop := c.leaveLoop()
+		for _, pos := range loop.breakPositions {
+			c.changeOperand(pos, afterBodyPos)
+		}
+		for _, pos := range loop.continuePositions {
+			c.changeOperand(pos, loopStartPos)
+		}
+	case *ast.ForStatement:
+		if node.Init != nil {
+			c.compile(node.Init)
+		}
+
+		loopStartPos := len(c.currentInstructions())
+		jumpNotTruthyPos := -1
+		if node.Condition != nil {
+			c.compile(node.Condition)
+			jumpNotTruthyPos = c.emit(code.OpJumpNotTruthy, 9999)
+		}
+
+		c.enterLoop()
+		c.compile(node.Body)
+
+		// continue跳到post，post执行完再回到条件
+		postPos := len(c.currentInstructions())
+		if node.Post != nil {
+			c.compile(node.Post)
+		}
+		c.emit(code.OpJump, loopStartPos)
+
+		afterBodyPos := len(c.currentInstructions())
+		if jumpNotTruthyPos >= 0 {
+			c.changeOperand(jumpNotTruthyPos, afterBodyPos)
+		}
+
+		loop := c.leaveLoop()
+		for _, pos := range loop.breakPositions {
+			c.changeOperand(pos, afterBodyPos)
+		}
+		for _, pos := range loop.continuePositions {
+			c.changeOperand(pos, postPos)
+		}
+	case *ast.ForInStatement:
+		// 循环期间迭代器一直在栈顶，OpIterNext每次取出下一个元素压到它上面，取完时弹出迭代器跳出循环
+		c.compile(node.Iterable)
+		c.emit(code.OpIter)
+
+		// 循环变量跟let定义的变量一样属于所在的函数作用域
+		value := c.symbolTable.Define(node.Value.Value)
+		var key Symbol
+		count := 1
+		if node.Key != nil {
+			key = c.symbolTable.Define(node.Key.Value)
+			count = 2
+		}
+
+		loopStartPos := len(c.currentInstructions())
+		iterNextPos := c.emit(code.OpIterNext, 9999, count)
+		c.setSymbol(value)
+		if node.Key != nil {
+			c.setSymbol(key)
+		}
+
+		c.enterLoop()
+		c.currentLoop().iterator = true
+		c.compile(node.Body)
+		c.emit(code.OpJump, loopStartPos)
+
+		afterBodyPos := len(c.currentInstructions())
+		c.changeOperand(iterNextPos, afterBodyPos)
+
+		loop := c.leaveLoop()
+		for _, pos := range loop.breakPositions {
+			c.changeOperand(pos, afterBodyPos)
+		}
+		for _, pos := range loop.continuePositions {
+			c.changeOperand(pos, loopStartPos)
+		}
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			c.errorf(node.Token, "break statement not within a loop")
+			return
+		}
+		// 提前跳出for-in循环时迭代器还在栈顶，先把它弹出
+		if loop.iterator {
+			c.emit(code.OpPop)
+		}
+		pos := c.emit(code.OpJump, 9999)
+		loop.breakPositions = append(loop.breakPositions, pos)
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			c.errorf(node.Token, "continue statement not within a loop")
+			return
+		}
+		pos := c.emit(code.OpJump, 9999)
+		loop.continuePositions = append(loop.continuePositions, pos)
 	case *ast.BlockStatement:
-		for _, s := range node.Statements {
-			err := c.Compile(s)
-			if err != nil {
-				return err
+		for i, s := range node.Statements {
+			c.compile(s)
+			if isTerminator(s) && i < len(node.Statements)-1 {
+				tok, _ := nodeToken(node.Statements[i+1])
+				c.warnf(tok, "unreachable code")
+				// 后面的语句还是要编译，里面的错误一样要报告
 			}
 		}
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -275,7 +510,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			c.errorf(node.Token, "undefined variable %s", node.Value)
+			// 补一个值占住栈上的位置，让后面生成的指令保持结构正确，反正有错误的程序不会被执行
+			c.emit(code.OpNull)
+			return
 		}
 		c.loadSymbol(symbol)
 	case *ast.StringLiteral:
@@ -283,10 +521,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpConstant, c.addConstant(str))
 	case *ast.ArrayLiteral:
 		for _, el := range node.Elements {
-			err := c.Compile(el)
-			if err != nil {
-				return err
-			}
+			c.compile(el)
 		}
 		c.emit(code.OpArray, len(node.Elements))
 	case *ast.HashLiteral:
@@ -300,25 +535,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return keys[i].String() <keys[j].String()
 		})
 		for _, k := range keys {
-			err := c.Compile(k)
-			if err != nil {
-				return err
-			}
-			err = c.Compile(node.Pairs[k])
-			if err != nil {
-				return err
-			}
+			c.compile(k)
+			c.compile(node.Pairs[k])
 		}
 		c.emit(code.OpHash, len(node.Pairs)*2)
 	case *ast.IndexExpression:
-		err := c.Compile(node.Left)
-		if err != nil {
-			return err
-		}
-		err = c.Compile(node.Index)
-		if err != nil {
-			return err
-		}
+		c.compile(node.Left)
+		c.compile(node.Index)
 
 		c.emit(code.OpIndex)
 	case *ast.FunctionLiteral:
@@ -340,10 +563,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.symbolTable.Define(p.Value)
 		}
 
-		err := c.Compile(node.Body)
-		if err != nil {
-			return err
-		}
+		c.compile(node.Body)
 		if c.lastInstructionIs(code.OpPop) {
 			c.replaceLastPopWithReturn()
 		}
@@ -353,6 +573,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// 暂存自由变量，因为下面c.leaveScope()后，局部作用域就释放了，也就是对应的符号表就销毁了，因为指令已经生成完毕了
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions // 形式参数也看做局部变量
+		localNames := make([]string, numLocals)
+		for _, symbol := range c.symbolTable.Symbols() {
+			if symbol.Scope == LocalScope {
+				localNames[symbol.Index] = symbol.Name
+			}
+		}
+		sourceMap := c.scopes[c.scopeIndex].sourceMap
 		//对函数字面量的解析完成了，退出当前函数的作用域
 		instructions := c.leaveScope()
 
@@ -362,10 +589,17 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		compiledFn := &object.CompiledFunction{
-			Name: node.String(),
 			Instructions: instructions,
 			NumLocals: numLocals,
 			NumParameters: len(node.Parameters),
+			SourceMap: sourceMap,
+			LocalNames: localNames,
+		}
+		for _, s := range freeSymbols {
+			compiledFn.FreeNames = append(compiledFn.FreeNames, s.Name)
+		}
+		if node.Name != nil {
+			compiledFn.Name = node.Name.Value
 		}
 
 		// 字面量，包括函数定义，统统看做常量，常量池里存储的依旧是object.CompiledFunction对象，VM执行到OpClosure指令才把它转化成object.Closure对象
@@ -374,27 +608,55 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
 
 	case *ast.ReturnStatement:
-		err := c.Compile(node.ReturnValue)
-		if err != nil {
-			return err
-		}
+		c.compile(node.ReturnValue)
 		c.emit(code.OpReturnValue)
 	case *ast.CallExpression:
-		err := c.Compile(node.Function)
-		if err != nil {
-			return err
-		}
+		c.compile(node.Function)
 
 		for _, a := range node.Arguments {
-			err := c.Compile(a)
-			if err != nil {
-				return err
-			}
+			c.compile(a)
 		}
 
 		c.emit(code.OpCall, len(node.Arguments)) // 操作数是相对于本指令在stack上的偏移量
 	}
-	return nil
+}
+
+// compileLogicalExpression /**
+/*
+&&和||是短路求值的，右边的操作数不一定会执行，所以不能像其他中缀表达式那样先把两边都算出来再用一条指令计算，
+而是用条件跳转实现，结果总是布尔值：
+	a && b:  a; JNT f; b; JNT f; OpTrue; OpJump end; f: OpFalse; end:
+	a || b:  a; JNT r; OpTrue; OpJump end; r: b; JNT f; OpTrue; OpJump end; f: OpFalse; end:
+ */
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) {
+	var jumpToFalsePositions []int
+	var jumpToEndPositions []int
+
+	c.compile(node.Left)
+	if node.Operator == "&&" {
+		jumpToFalsePositions = append(jumpToFalsePositions, c.emit(code.OpJumpNotTruthy, 9999))
+	}else {
+		jumpToRightPos := c.emit(code.OpJumpNotTruthy, 9999)
+		c.emit(code.OpTrue)
+		jumpToEndPositions = append(jumpToEndPositions, c.emit(code.OpJump, 9999))
+		c.changeOperand(jumpToRightPos, len(c.currentInstructions()))
+	}
+
+	c.compile(node.Right)
+	jumpToFalsePositions = append(jumpToFalsePositions, c.emit(code.OpJumpNotTruthy, 9999))
+	c.emit(code.OpTrue)
+	jumpToEndPositions = append(jumpToEndPositions, c.emit(code.OpJump, 9999))
+
+	falsePos := len(c.currentInstructions())
+	for _, pos := range jumpToFalsePositions {
+		c.changeOperand(pos, falsePos)
+	}
+	c.emit(code.OpFalse)
+
+	endPos := len(c.currentInstructions())
+	for _, pos := range jumpToEndPositions {
+		c.changeOperand(pos, endPos)
+	}
 }
 
 func (c *Compiler) replaceLastPopWithReturn() {
@@ -421,10 +683,31 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	pos := c.addInstruction(ins)
 
 	c.setLastInstruction(op, pos)
+	c.addSourcePosition(pos)
 
 	return pos
 }
 
+/**
+位置跟上一条记录相同就不重复记录了，一条记录覆盖到下一条记录之前的所有指令
+ */
+func (c *Compiler) addSourcePosition(pos int) {
+	if c.position.Line == 0 {
+		return
+	}
+	sourceMap := c.scopes[c.scopeIndex].sourceMap
+	if n := len(sourceMap); n > 0 {
+		last := sourceMap[n-1]
+		if last.Line == c.position.Line && last.Column == c.position.Column {
+			return
+		}
+	}
+
+	position := c.position
+	position.Offset = pos
+	c.scopes[c.scopeIndex].sourceMap = append(sourceMap, position)
+}
+
 func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 	if len(c.currentInstructions()) == 0 {
 		return false
@@ -441,6 +724,7 @@ func (c *Compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.scopes[c.scopeIndex].sourceMap = c.scopes[c.scopeIndex].sourceMap.Truncate(last.Position)
 }
 
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int)  {
@@ -468,9 +752,15 @@ func (c *Compiler) addInstruction(ins []byte) int {
 第一层指令，全局指令
  */
 func (c *Compiler) Bytecode() *Bytecode {
+	builtins := c.symbolTable.Builtins()
+	if builtins == nil {
+		builtins = object.DefaultBuiltins()
+	}
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants: c.constants,
+		SourceMap: c.scopes[c.scopeIndex].sourceMap,
+		Builtins: builtins,
 	}
 }
 
@@ -489,12 +779,40 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction[]byte) {
 然后找到旧指令的位置逐字节替换旧指令的内容
  */
 func (c *Compiler) changeOperand(opPos int, operand int) {
-	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
+	instructions := c.currentInstructions()
+	op := code.Opcode(instructions[opPos])
+	// 只替换第一个操作数，其余的操作数（比如OpIterNext的循环变量个数）保持不变
+	def, err := code.Lookup(byte(op))
+	if err != nil {
+		return
+	}
+	operands, _ := code.ReadOperands(def, instructions[opPos+1:])
+	operands[0] = operand
+	newInstruction := code.Make(op, operands...)
 
 	c.replaceInstruction(opPos, newInstruction)
 }
 
+func (c *Compiler) currentLoop() *loopScope {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+func (c *Compiler) enterLoop() {
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, &loopScope{})
+}
+
+func (c *Compiler) leaveLoop() *loopScope {
+	loop := c.currentLoop()
+	loops := c.scopes[c.scopeIndex].loops
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+
+	return loop
+}
+
 func (c *Compiler) enterScope() {
 	scope := CompilationScope{
 		instructions: code.Instructions{},
@@ -520,3 +838,53 @@ func (c *Compiler) leaveScope() code.Instructions {
 
 	return instructions
 }
+
+/*
+取出节点对应的token，用来确定节点在源码中的位置。只列出了会生成指令的节点
+ */
+func nodeToken(node ast.Node) (token.Token, bool) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		return node.Token, true
+	case *ast.AssignStatement:
+		return node.Token, true
+	case *ast.IndexAssignStatement:
+		return node.Token, true
+	case *ast.ReturnStatement:
+		return node.Token, true
+	case *ast.ExpressionStatement:
+		return node.Token, true
+	case *ast.FunctionDefinitionStatement:
+		return node.FnLiteral.Token, true
+	case *ast.WhileStatement:
+		return node.Token, true
+	case *ast.ForStatement:
+		return node.Token, true
+	case *ast.ForInStatement:
+		return node.Token, true
+	case *ast.BreakStatement:
+		return node.Token, true
+	case *ast.ContinueStatement:
+		return node.Token, true
+	case *ast.PrefixExpression:
+		return node.Token, true
+	case *ast.InfixExpression:
+		return node.Token, true
+	case *ast.IfExpression:
+		return node.Token, true
+	case *ast.Identifier:
+		return node.Token, true
+	case *ast.ArrayLiteral:
+		return node.Token, true
+	case *ast.HashLiteral:
+		return node.Token, true
+	case *ast.IndexExpression:
+		return node.Token, true
+	case *ast.FunctionLiteral:
+		return node.Token, true
+	case *ast.CallExpression:
+		return node.Token, true
+	default:
+		return token.Token{}, false
+	}
+}
@@ -220,11 +220,11 @@ func TestBooleanExpressions(t *testing.T) {
 		},
 		{
 			input: "1 < 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
 				code.Make(code.OpPop),
 			},
 		},
@@ -281,6 +281,91 @@ func TestBooleanExpressions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestComparisonAndLogicalOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "1 <= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "5 % 2",
+			expectedConstants: []interface{}{5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0008
+				code.Make(code.OpTrue),
+				// 0009
+				code.Make(code.OpJump, 13),
+				// 0012
+				code.Make(code.OpFalse),
+				// 0013
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "true || false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.Make(code.OpTrue),
+				// 0005
+				code.Make(code.OpJump, 17),
+				// 0008
+				code.Make(code.OpFalse),
+				// 0009
+				code.Make(code.OpJumpNotTruthy, 16),
+				// 0012
+				code.Make(code.OpTrue),
+				// 0013
+				code.Make(code.OpJump, 17),
+				// 0016
+				code.Make(code.OpFalse),
+				// 0017
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -331,10 +416,369 @@ if (true) { 10 }; 3333;
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			// else if链的每个分支都直接跳到末尾
+			input: `
+if (false) { 10 } else if (true) { 20 } else { 30 }; 3333;
+`,
+			expectedConstants: []interface{}{10, 20, 30, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpFalse),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 23),
+				// 0010
+				code.Make(code.OpTrue),
+				// 0011
+				code.Make(code.OpJumpNotTruthy, 20),
+				// 0014
+				code.Make(code.OpConstant, 1),
+				// 0017
+				code.Make(code.OpJump, 23),
+				// 0020
+				code.Make(code.OpConstant, 2),
+				// 0023
+				code.Make(code.OpPop),
+				// 0024
+				code.Make(code.OpConstant, 3),
+				// 0027
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+if (false) { 10 } else if (true) { 20 }; 3333;
+`,
+			expectedConstants: []interface{}{10, 20, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpFalse),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 21),
+				// 0010
+				code.Make(code.OpTrue),
+				// 0011
+				code.Make(code.OpJumpNotTruthy, 20),
+				// 0014
+				code.Make(code.OpConstant, 1),
+				// 0017
+				code.Make(code.OpJump, 21),
+				// 0020
+				code.Make(code.OpNull),
+				// 0021
+				code.Make(code.OpPop),
+				// 0022
+				code.Make(code.OpConstant, 2),
+				// 0025
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestWhileStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+while (true) { 10 } 3333;
+`,
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 11),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 0),
+				// 0011
+				code.Make(code.OpConstant, 1),
+				// 0014
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+if (true) { while (false) { } }
+`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 15),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 11),
+				// 0008
+				code.Make(code.OpJump, 4),
+				// 0011
+				code.Make(code.OpNull),
+				// 0012
+				code.Make(code.OpJump, 16),
+				// 0015
+				code.Make(code.OpNull),
+				// 0016
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestBreakAndContinue(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+while (true) { break; continue; }
+`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 13),
+				// 0004
+				code.Make(code.OpJump, 13),
+				// 0007
+				code.Make(code.OpJump, 0),
+				// 0010
+				code.Make(code.OpJump, 0),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+for (let i = 0; i < 3; i += 1) { continue; }
+`,
+			expectedConstants: []interface{}{0, 3, 1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpConstant, 1),
+				// 0012
+				code.Make(code.OpLessThan),
+				// 0013
+				code.Make(code.OpJumpNotTruthy, 32),
+				// 0016
+				code.Make(code.OpJump, 19),
+				// 0019
+				code.Make(code.OpGetGlobal, 0),
+				// 0022
+				code.Make(code.OpConstant, 2),
+				// 0025
+				code.Make(code.OpAdd),
+				// 0026
+				code.Make(code.OpSetGlobal, 0),
+				// 0029
+				code.Make(code.OpJump, 6),
+			},
+		},
+		{
+			input: `
+for (;;) { break; }
+`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpJump, 6),
+				// 0003
+				code.Make(code.OpJump, 0),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestForInStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+for (x in [1]) { x; continue; }
+`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIter),
+				// 0007
+				code.Make(code.OpIterNext, 24, 1),
+				// 0011
+				code.Make(code.OpSetGlobal, 0),
+				// 0014
+				code.Make(code.OpGetGlobal, 0),
+				// 0017
+				code.Make(code.OpPop),
+				// 0018
+				code.Make(code.OpJump, 7),
+				// 0021
+				code.Make(code.OpJump, 7),
+			},
+		},
+		{
+			// 两个循环变量时先压入键再压入值，break之前先弹出迭代器
+			input: `
+for (k, v in {1: 2}) { break; }
+`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpConstant, 1),
+				// 0006
+				code.Make(code.OpHash, 2),
+				// 0009
+				code.Make(code.OpIter),
+				// 0010
+				code.Make(code.OpIterNext, 27, 2),
+				// 0014
+				code.Make(code.OpSetGlobal, 0),
+				// 0017
+				code.Make(code.OpSetGlobal, 1),
+				// 0020
+				code.Make(code.OpPop),
+				// 0021
+				code.Make(code.OpJump, 27),
+				// 0024
+				code.Make(code.OpJump, 10),
+			},
+		},
+		{
+			input: `
+fn(a) { for (x in a) { } }
+`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal, 0),
+					// 0002
+					code.Make(code.OpIter),
+					// 0003
+					code.Make(code.OpIterNext, 12, 1),
+					// 0007
+					code.Make(code.OpSetLocal, 1),
+					// 0009
+					code.Make(code.OpJump, 3),
+					// 0012
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 	runCompilerTests(t, tests)
 }
 
+func TestBreakAndContinueOutsideLoop(t *testing.T) {
+	tests := []string{
+		"break;",
+		"continue;",
+		"while (true) { let f = fn() { break; }; }",
+		"for (x in [1]) { let f = fn() { continue; }; }",
+	}
+
+	for _, input := range tests {
+		program := parse(input)
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Errorf("input %q: expected compile error, got none", input)
+		}
+	}
+}
+
+func TestCompilerDiagnostics(t *testing.T) {
+	tests := []struct {
+		input string
+		expectedErr bool
+		expected []string
+	}{
+		{
+			"a = 1;",
+			true,
+			[]string{"1:1: error: undefined variable a"},
+		},
+		{
+			"let x = y + 1;\nx = z;\nfn(){ w }",
+			true,
+			[]string{
+				"1:9: error: undefined variable y",
+				"2:5: error: undefined variable z",
+				"3:7: error: undefined variable w",
+			},
+		},
+		{
+			"fn() { return 1; 2; }",
+			false,
+			[]string{"1:18: warning: unreachable code"},
+		},
+		{
+			"let x = 1; x = 2;",
+			false,
+			nil,
+		},
+		{
+			"len += 1;\nlet f = fn() { f = 1; };",
+			true,
+			[]string{
+				"1:1: error: cannot assign to builtin function len",
+				"2:16: error: cannot assign to function f inside its own body",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		compiler := New()
+		err := compiler.Compile(program)
+		if (err != nil) != tt.expectedErr {
+			t.Errorf("input %q: wrong error result. want=%t, got=%v", tt.input, tt.expectedErr, err)
+		}
+
+		diagnostics := compiler.Diagnostics()
+		if len(diagnostics) != len(tt.expected) {
+			t.Errorf("input %q: wrong number of diagnostics. want=%d, got=%d (%s)",
+				tt.input, len(tt.expected), len(diagnostics), diagnostics.Error())
+			continue
+		}
+		for i, want := range tt.expected {
+			if got := diagnostics[i].String(); got != want {
+				t.Errorf("input %q: diagnostic %d wrong. want=%q, got=%q", tt.input, i, want, got)
+			}
+		}
+	}
+}
+
 func TestGlobalLetStatements(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -527,6 +971,125 @@ func TestIndexExpressions(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestCompoundAssignment(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "let a = 1; a += 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: "fn() { let a = 1; a %= 2 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpMod),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// 数组和索引只求一次，OpIndexKeep把它们留在栈上给OpSetIndex用
+			input: "let a = [1]; a[0] *= 3;",
+			expectedConstants: []interface{}{1, 0, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpIndexKeep),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpMul),
+				code.Make(code.OpSetIndex),
+			},
+		},
+		{
+			// 自由变量用OpGetFree取值，用OpSetFree存回闭包
+			input: "fn(a) { fn() { a += 1 } }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpReturn),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "let a = [1]; a[0] = 2;",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+			},
+		},
+		{
+			input: "fn() { let h = {}; h[1][2] = 3 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				3,
+				[]code.Instructions{
+					code.Make(code.OpHash, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpIndex),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpSetIndex),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
 func TestFunctions(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -1065,4 +1628,65 @@ wrapper();
 		},
 	}
 	runCompilerTests(t, tests)
-}
\ No newline at end of file
+}
+func TestSourceMap(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x) {
+  x * a
+};
+f(2)`
+
+	compiler := New()
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	expected := code.SourceMap{
+		// 0000 OpConstant 0, 0003 OpSetGlobal 0
+		{Offset: 0, Line: 1, Column: 1},
+		// 0006 OpClosure 2 0
+		{Offset: 6, Line: 2, Column: 9},
+		// 0010 OpSetGlobal 1
+		{Offset: 10, Line: 2, Column: 1},
+		// 0013 OpGetGlobal 1
+		{Offset: 13, Line: 5, Column: 1},
+		// 0016 OpConstant 1, 0019 OpCall 1
+		{Offset: 16, Line: 5, Column: 2},
+		// 0021 OpPop
+		{Offset: 21, Line: 5, Column: 1},
+	}
+	testSourceMap(t, expected, bytecode.SourceMap)
+
+	fn, ok := bytecode.Constants[1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 1 is not a function. got=%T", bytecode.Constants[1])
+	}
+	if fn.Name != "f" {
+		t.Errorf("wrong function name. want=f, got=%q", fn.Name)
+	}
+	expected = code.SourceMap{
+		// 0000 OpGetLocal 0
+		{Offset: 0, Line: 3, Column: 3},
+		// 0002 OpGetGlobal 0
+		{Offset: 2, Line: 3, Column: 7},
+		// 0005 OpMul
+		{Offset: 5, Line: 3, Column: 5},
+		// 0006 OpReturnValue
+		{Offset: 6, Line: 3, Column: 3},
+	}
+	testSourceMap(t, expected, fn.SourceMap)
+}
+
+func testSourceMap(t *testing.T, expected, actual code.SourceMap) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("wrong source map length. want=%d, got=%d (%+v)", len(expected), len(actual), actual)
+	}
+	for i, pos := range expected {
+		if actual[i] != pos {
+			t.Errorf("wrong source position %d. want=%+v, got=%+v", i, pos, actual[i])
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package compiler
+
+import (
+	"fmt"
+	"glue/ast"
+	"glue/token"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Diagnostic /**
+/*
+编译器发现的一个问题。Token是出问题的AST节点上的token，位置信息从它上面取，
+行号为0说明这个节点没有位置信息（比如直接构造的AST）
+ */
+type Diagnostic struct {
+	Severity Severity
+	Message string
+	Token token.Token
+}
+
+func (d *Diagnostic) Line() int {
+	return d.Token.LineNum
+}
+
+func (d *Diagnostic) Column() int {
+	return d.Token.ColumnNum
+}
+
+func (d *Diagnostic) String() string {
+	if d.Token.LineNum > 0 {
+		return fmt.Sprintf("%d:%d: %s: %s", d.Token.LineNum, d.Token.ColumnNum, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+}
+
+// Diagnostics /**
+/*
+一次编译收集到的所有诊断信息，实现了error接口，Compile出错时返回的就是它，
+调用方可以用errors.As取出来逐条处理
+ */
+type Diagnostics []*Diagnostic
+
+func (ds Diagnostics) Error() string {
+	lines := make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Errors 只保留error级别的诊断信息
+func (ds Diagnostics) Errors() Diagnostics {
+	var errs Diagnostics
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			errs = append(errs, d)
+		}
+	}
+	return errs
+}
+
+func (c *Compiler) errorf(tok token.Token, format string, args ...interface{}) {
+	c.report(SeverityError, tok, format, args...)
+}
+
+func (c *Compiler) warnf(tok token.Token, format string, args ...interface{}) {
+	c.report(SeverityWarning, tok, format, args...)
+}
+
+func (c *Compiler) report(severity Severity, tok token.Token, format string, args ...interface{}) {
+	c.diagnostics = append(c.diagnostics, &Diagnostic{
+		Severity: severity,
+		Message: fmt.Sprintf(format, args...),
+		Token: tok,
+	})
+}
+
+// isTerminator 判断一条语句执行后是否一定会跳走，块中在它后面的语句都执行不到
+func isTerminator(s ast.Statement) bool {
+	switch s.(type) {
+	case *ast.ReturnStatement, *ast.BreakStatement, *ast.ContinueStatement:
+		return true
+	default:
+		return false
+	}
+}
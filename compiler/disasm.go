@@ -0,0 +1,173 @@
+package compiler
+
+import (
+	"bufio"
+	"fmt"
+	"glue/code"
+	"glue/object"
+	"io"
+	"strings"
+)
+
+// Disassemble /**
+/*
+把整个程序反汇编成可读的文本：先是主指令序列，然后是常量池，最后依次是常量池里的每个函数。
+函数里定义的函数也在同一个常量池里，所以按常量池顺序输出就覆盖了所有嵌套的函数。
+
+每条指令一行，是跳转目标的指令在偏移量前标一个'>'，注释里给出操作数的含义（常量的值、跳转目标、闭包的自由变量个数、
+内置函数名等）以及源码位置。source是源码按行切分的内容，不为nil时在源码行变化的地方把这一行源码也输出出来
+ */
+func Disassemble(w io.Writer, b *Bytecode, source []string) error {
+	bw := bufio.NewWriter(w)
+	d := &disassembler{w: bw, constants: b.Constants, builtins: b.Builtins, source: source}
+
+	d.function("<main>", b.Instructions, b.SourceMap)
+
+	fmt.Fprintf(bw, "\n== constants ==\n")
+	for i, constant := range b.Constants {
+		fmt.Fprintf(bw, "%4d  %s\n", i, d.describeConstant(constant))
+	}
+
+	for i, constant := range b.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			fmt.Fprintln(bw)
+			d.function(fmt.Sprintf("fn %s (constant %d, params %d, locals %d)",
+				fn.DisplayName(), i, fn.NumParameters, fn.NumLocals), fn.Instructions, fn.SourceMap)
+		}
+	}
+
+	return bw.Flush()
+}
+
+type disassembler struct {
+	w io.Writer
+	constants []object.Object
+	builtins *object.BuiltinRegistry
+	source []string
+}
+
+func (d *disassembler) function(title string, ins code.Instructions, sourceMap code.SourceMap) {
+	fmt.Fprintf(d.w, "== %s ==\n", title)
+
+	targets := jumpTargets(ins)
+	lastLine := 0
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(d.w, " %04d  ERROR: %s\n", i, err)
+			i++
+			continue
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+
+		pos, hasPos := sourceMap.Lookup(i)
+		if hasPos && pos.Line != lastLine {
+			lastLine = pos.Line
+			if line, ok := d.sourceLine(pos.Line); ok {
+				fmt.Fprintf(d.w, "       ; %d: %s\n", pos.Line, line)
+			}
+		}
+
+		marker := " "
+		if targets[i] {
+			marker = ">"
+		}
+
+		text := def.Name
+		for _, operand := range operands {
+			text += fmt.Sprintf(" %d", operand)
+		}
+		var notes []string
+		if note := d.describeOperands(code.Opcode(ins[i]), operands); note != "" {
+			notes = append(notes, note)
+		}
+		if hasPos {
+			notes = append(notes, fmt.Sprintf("%d:%d", pos.Line, pos.Column))
+		}
+
+		if len(notes) > 0 {
+			fmt.Fprintf(d.w, "%s%04d  %-24s ; %s\n", marker, i, text, strings.Join(notes, "  "))
+		}else {
+			fmt.Fprintf(d.w, "%s%04d  %s\n", marker, i, text)
+		}
+
+		i += 1 + read
+	}
+}
+
+func (d *disassembler) sourceLine(line int) (string, bool) {
+	if line < 1 || line > len(d.source) {
+		return "", false
+	}
+	return strings.TrimRight(d.source[line-1], "\r\n"), true
+}
+
+func (d *disassembler) describeOperands(op code.Opcode, operands []int) string {
+	switch op {
+	case code.OpConstant:
+		return d.describeConstantRef(operands[0])
+	case code.OpClosure:
+		return fmt.Sprintf("%s, %s", d.describeConstantRef(operands[0]), plural(operands[1], "free var"))
+	case code.OpJump, code.OpJumpNotTruthy:
+		return fmt.Sprintf("-> %04d", operands[0])
+	case code.OpIterNext:
+		return fmt.Sprintf("-> %04d, %s", operands[0], plural(operands[1], "var"))
+	case code.OpGetBuiltin:
+		if d.builtins != nil {
+			if builtin := d.builtins.At(operands[0]); builtin != nil {
+				return "builtin " + builtin.Name
+			}
+		}
+	case code.OpCall:
+		return plural(operands[0], "arg")
+	case code.OpArray:
+		return plural(operands[0], "element")
+	case code.OpHash:
+		return plural(operands[0]/2, "pair")
+	}
+	return ""
+}
+
+func (d *disassembler) describeConstantRef(index int) string {
+	if index >= len(d.constants) {
+		return fmt.Sprintf("constant %d out of range", index)
+	}
+	return fmt.Sprintf("[%d] %s", index, d.describeConstant(d.constants[index]))
+}
+
+func (d *disassembler) describeConstant(obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.String:
+		return fmt.Sprintf("%s %q", obj.Type(), obj.Value)
+	case *object.CompiledFunction:
+		return fmt.Sprintf("fn %s", obj.DisplayName())
+	default:
+		return fmt.Sprintf("%s %s", obj.Type(), obj.Inspect())
+	}
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// jumpTargets 找出指令序列中所有跳转指令的目标偏移量
+func jumpTargets(ins code.Instructions) map[int]bool {
+	targets := make(map[int]bool)
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			i++
+			continue
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		switch code.Opcode(ins[i]) {
+		case code.OpJump, code.OpJumpNotTruthy, code.OpIterNext:
+			targets[operands[0]] = true
+		}
+		i += 1 + read
+	}
+	return targets
+}
@@ -0,0 +1,49 @@
+package compiler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `let x = if (true) { 10 } else { "ten" };
+let adder = fn(a) { fn(b) { a + b } };
+len(adder(1)(2));`
+
+	program := parse(input)
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := Disassemble(&out, compiler.Bytecode(), strings.Split(input, "\n")); err != nil {
+		t.Fatalf("disassemble error: %s", err)
+	}
+	listing := out.String()
+
+	expected := []string{
+		"== <main> ==",
+		"; 1: let x = if (true) { 10 } else { \"ten\" };",
+		" 0001  OpJumpNotTruthy 10       ; -> 0010  1:9",
+		" 0004  OpConstant 0             ; [0] INTEGER 10  1:21",
+		">0010  OpConstant 1             ; [1] STRING \"ten\"  1:33",
+		">0013  OpSetGlobal 0            ; 1:1",
+		"OpClosure 3 0            ; [3] fn adder, 0 free vars",
+		"OpGetBuiltin 0           ; builtin len",
+		"OpCall 1                 ; 1 arg",
+		"== constants ==",
+		"   1  STRING \"ten\"",
+		"== fn <anonymous> (constant 2, params 1, locals 1) ==",
+		"; 2: let adder = fn(a) { fn(b) { a + b } };",
+		"OpGetFree 0",
+		"== fn adder (constant 3, params 1, locals 1) ==",
+		"OpClosure 2 1            ; [2] fn <anonymous>, 1 free var",
+	}
+	for _, want := range expected {
+		if !strings.Contains(listing, want) {
+			t.Errorf("listing does not contain %q.\n%s", want, listing)
+		}
+	}
+}
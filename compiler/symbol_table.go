@@ -1,5 +1,10 @@
 package compiler
 
+import (
+	"glue/object"
+	"sort"
+)
+
 type SymbolScope string
 
 const (
@@ -23,6 +28,8 @@ type SymbolTable struct {
 	numDefinitions int
 
 	FreeSymbols []Symbol
+
+	builtins *object.BuiltinRegistry // DefineBuiltins定义的内置函数表，只有最外层的符号表有
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -88,6 +95,24 @@ func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 
 }
 
+// Symbols /**
+/*
+当前这一层符号表里定义的所有符号（不包括外层的），按作用域和索引排序。同名的变量重复定义时只有最后一个
+ */
+func (s *SymbolTable) Symbols() []Symbol {
+	symbols := make([]Symbol, 0, len(s.store))
+	for _, symbol := range s.store {
+		symbols = append(symbols, symbol)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].Scope != symbols[j].Scope {
+			return symbols[i].Scope < symbols[j].Scope
+		}
+		return symbols[i].Index < symbols[j].Index
+	})
+	return symbols
+}
+
 func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	symbol := Symbol{
 		Name: name,
@@ -99,6 +124,25 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	return symbol
 }
 
+// DefineBuiltins /**
+/*
+把注册表里的内置函数按索引定义成BuiltinScope的符号，并记下这个注册表，编译器生成字节码时会把它带上，VM按它解析OpGetBuiltin
+ */
+func (s *SymbolTable) DefineBuiltins(r *object.BuiltinRegistry) {
+	for i, name := range r.Names() {
+		s.DefineBuiltin(i, name)
+	}
+	s.builtins = r
+}
+
+// Builtins 返回最外层符号表上定义的内置函数表，没有定义过返回nil
+func (s *SymbolTable) Builtins() *object.BuiltinRegistry {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s.builtins
+}
+
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols)-1}
@@ -0,0 +1,538 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"glue/compiler"
+	"glue/debugger"
+	"glue/internal/wire"
+	"glue/lexer"
+	"glue/object"
+	"glue/parser"
+	"glue/vm"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const threadID = 1 // glue是单线程的，只有一个线程
+
+// 变量引用号：全局变量是1，第i个栈帧（0是最内层）的局部变量是2+2i，自由变量是3+2i
+const globalsReference = 1
+
+// Server /**
+/*
+通过DAP协议调试一个glue源文件，编辑器（DAP客户端）从in发来请求，响应和事件写入out。
+launch编译源文件，configurationDone之后在单独的goroutine里执行VM，VM在调试钩子里暂停时阻塞，
+等待客户端发来continue、next等请求。暂停期间可以查询调用栈和变量
+ */
+type Server struct {
+	in *bufio.Reader
+	out io.Writer
+
+	writeMu sync.Mutex // 响应和事件可能同时从处理请求的goroutine和VM的goroutine写出
+	seq int
+
+	// 下面的字段在VM的goroutine和处理请求的goroutine之间共享，由mu保护
+	mu sync.Mutex
+	stepper *debugger.Stepper
+	machine *vm.VM
+	symbols *compiler.SymbolTable
+	program string // 源文件的绝对路径
+	codeLines map[int]bool // 有指令的源码行，断点只能设在这些行上
+	entry bool // 下一次暂停是stopOnEntry引起的
+	paused bool
+	quit bool
+	started bool
+
+	resume chan struct{} // VM暂停时阻塞在这里，客户端要求继续执行时发送
+	done chan struct{} // VM执行结束后关闭
+
+	onExit func()
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in: bufio.NewReader(in),
+		out: out,
+		stepper: debugger.NewStepper(debugger.Continue),
+		resume: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Serve /**
+/*
+处理请求，直到客户端发来disconnect请求或者输入结束。返回前会中止还在执行的程序
+ */
+func (s *Server) Serve() error {
+	defer s.stop()
+
+	for {
+		data, err := wire.ReadMessage(s.in)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var req Request
+		if err := json.Unmarshal(data, &req); err != nil {
+			return fmt.Errorf("dap: %s", err)
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if req.Command == "disconnect" {
+			s.stop()
+			return s.respond(&req, nil)
+		}
+		if err := s.handle(&req); err != nil {
+			return err
+		}
+	}
+}
+
+// Output 发送output事件，在编辑器的调试控制台里显示，category是stdout、stderr或者console
+func (s *Server) Output(category, text string) error {
+	return s.send(&Event{Type: "event", Event: "output", Body: OutputEventBody{Category: category, Output: text}})
+}
+
+// OnExit 设置程序执行结束、发送exited事件之前调用的函数，比如把重定向的程序输出全部转发出去
+func (s *Server) OnExit(f func()) {
+	s.onExit = f
+}
+
+func (s *Server) handle(req *Request) error {
+	var body interface{}
+	var err error
+
+	switch req.Command {
+	case "initialize":
+		body = Capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsEvaluateForHovers: true,
+			SupportsTerminateRequest: true,
+		}
+		if err := s.respond(req, body); err != nil {
+			return err
+		}
+		return s.event("initialized", nil)
+	case "launch":
+		err = s.launch(req)
+	case "setBreakpoints":
+		body, err = s.setBreakpoints(req)
+	case "setExceptionBreakpoints":
+		body = map[string]interface{}{}
+	case "configurationDone":
+		err = s.configurationDone()
+	case "threads":
+		body = map[string]interface{}{"threads": []Thread{{ID: threadID, Name: "main"}}}
+	case "stackTrace":
+		body, err = s.stackTrace()
+	case "scopes":
+		body, err = s.scopes(req)
+	case "variables":
+		body, err = s.variables(req)
+	case "evaluate":
+		body, err = s.evaluate(req)
+	case "continue":
+		return s.continueWith(req, debugger.Continue, map[string]interface{}{"allThreadsContinued": true})
+	case "next":
+		return s.continueWith(req, debugger.StepOver, nil)
+	case "stepIn":
+		return s.continueWith(req, debugger.StepInto, nil)
+	case "stepOut":
+		return s.continueWith(req, debugger.StepOut, nil)
+	case "pause":
+		s.mu.Lock()
+		if !s.paused {
+			s.stepper.Resume(debugger.Pause)
+		}
+		s.mu.Unlock()
+	case "terminate":
+		s.stop()
+	default:
+		err = fmt.Errorf("unsupported request %q", req.Command)
+	}
+
+	if err != nil {
+		return s.send(&Response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: err.Error()})
+	}
+	return s.respond(req, body)
+}
+
+func (s *Server) launch(req *Request) error {
+	var args LaunchArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return err
+	}
+	if args.Program == "" {
+		return errors.New("launch: program is required")
+	}
+	program, err := filepath.Abs(args.Program)
+	if err != nil {
+		return err
+	}
+
+	bytecode, symbols, err := s.compile(program)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.machine != nil {
+		return errors.New("launch: a program is already launched")
+	}
+	s.program = program
+	s.symbols = symbols
+	s.codeLines = codeLines(bytecode)
+	s.machine = vm.New(bytecode)
+	if !args.NoDebug {
+		s.machine.SetDebugHook(s.hook)
+		if args.StopOnEntry {
+			s.entry = true
+			s.stepper.Resume(debugger.StepInto)
+		}
+	}
+	return nil
+}
+
+// compile 编译源文件，解析错误和编译诊断信息通过output事件发给客户端
+func (s *Server) compile(program string) (*compiler.Bytecode, *compiler.SymbolTable, error) {
+	if _, err := os.Stat(program); err != nil {
+		return nil, nil, err
+	}
+
+	p := parser.New(lexer.NewFromFile(program))
+	node := p.ParseProgram()
+	if p.HasError() {
+		for _, msg := range p.Errors() {
+			s.Output("stderr", fmt.Sprintf("%s:%s\n", program, msg))
+		}
+		return nil, nil, errors.New("launch: parse error")
+	}
+
+	c := compiler.New()
+	err := c.Compile(node)
+	for _, d := range c.Diagnostics() {
+		s.Output("stderr", fmt.Sprintf("%s:%s\n", program, d))
+	}
+	if err != nil {
+		return nil, nil, errors.New("launch: compile error")
+	}
+	return c.Bytecode(), c.SymbolTable(), nil
+}
+
+// codeLines 主程序和所有函数的指令对应的源码行
+func codeLines(bytecode *compiler.Bytecode) map[int]bool {
+	lines := map[int]bool{}
+	for _, pos := range bytecode.SourceMap {
+		lines[pos.Line] = true
+	}
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			for _, pos := range fn.SourceMap {
+				lines[pos.Line] = true
+			}
+		}
+	}
+	return lines
+}
+
+func (s *Server) setBreakpoints(req *Request) (interface{}, error) {
+	var args SetBreakpointsArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.machine == nil {
+		return nil, errors.New("setBreakpoints: no program launched")
+	}
+
+	// 只调试一个源文件，其他文件的断点都无效
+	path, _ := filepath.Abs(args.Source.Path)
+	sameFile := path == s.program
+	if sameFile {
+		s.stepper.ClearBreakpoints()
+	}
+
+	breakpoints := make([]Breakpoint, len(args.Breakpoints))
+	for i, bp := range args.Breakpoints {
+		breakpoints[i] = Breakpoint{Line: bp.Line, Source: &args.Source}
+		switch {
+		case !sameFile:
+			breakpoints[i].Message = "not the launched program"
+		case !s.codeLines[bp.Line]:
+			breakpoints[i].Message = "no code on this line"
+		default:
+			breakpoints[i].Verified = true
+			s.stepper.SetBreakpoint(bp.Line)
+		}
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+func (s *Server) configurationDone() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.machine == nil {
+		return errors.New("configurationDone: no program launched")
+	}
+	if s.started {
+		return nil
+	}
+	s.started = true
+	go s.run(s.machine)
+	return nil
+}
+
+func (s *Server) run(machine *vm.VM) {
+	defer close(s.done)
+
+	// 客户端要求中止时程序没有正常退出，只发送terminated事件
+	err := machine.Run()
+	if s.onExit != nil {
+		s.onExit()
+	}
+	if !errors.Is(err, debugger.ErrQuit) {
+		exitCode := 0
+		if re, ok := err.(*vm.RuntimeError); ok {
+			exitCode = 1
+			s.Output("stderr", re.StackTrace())
+		}else if err != nil {
+			exitCode = 1
+			s.Output("stderr", err.Error()+"\n")
+		}
+		s.event("exited", ExitedEventBody{ExitCode: exitCode})
+	}
+	s.event("terminated", nil)
+}
+
+// hook 在VM的goroutine里执行，需要暂停时发送stopped事件，然后阻塞到客户端要求继续执行
+func (s *Server) hook(machine *vm.VM) error {
+	s.mu.Lock()
+	if s.quit {
+		s.mu.Unlock()
+		return debugger.ErrQuit
+	}
+	reason := string(s.stepper.Check(machine))
+	if reason == "" {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.entry {
+		reason = "entry"
+		s.entry = false
+	}
+	s.paused = true
+	s.mu.Unlock()
+
+	s.event("stopped", StoppedEventBody{Reason: reason, ThreadID: threadID, AllThreadsStopped: true})
+	<-s.resume
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.quit {
+		return debugger.ErrQuit
+	}
+	return nil
+}
+
+// continueWith 先响应请求再恢复执行，保证客户端先收到响应，然后才可能收到下一个stopped事件
+func (s *Server) continueWith(req *Request, mode debugger.StepMode, body interface{}) error {
+	s.mu.Lock()
+	paused := s.paused
+	if paused {
+		s.stepper.Resume(mode)
+		s.paused = false
+	}
+	s.mu.Unlock()
+
+	if !paused {
+		return s.send(&Response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: "program is not paused"})
+	}
+	if err := s.respond(req, body); err != nil {
+		return err
+	}
+	s.resume <- struct{}{}
+	return nil
+}
+
+// stop 中止正在执行的程序并等待VM的goroutine结束
+func (s *Server) stop() {
+	s.mu.Lock()
+	s.quit = true
+	started, paused := s.started, s.paused
+	s.paused = false
+	s.mu.Unlock()
+
+	if !started {
+		return
+	}
+	if paused {
+		select {
+		case s.resume <- struct{}{}:
+		case <-s.done:
+		}
+	}
+	<-s.done
+}
+
+// pausedMachine 返回暂停中的VM，只有暂停时才能查看调用栈和变量
+func (s *Server) pausedMachine() (*vm.VM, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.paused {
+		return nil, errors.New("program is not paused")
+	}
+	return s.machine, nil
+}
+
+func (s *Server) stackTrace() (interface{}, error) {
+	machine, err := s.pausedMachine()
+	if err != nil {
+		return nil, err
+	}
+
+	source := &Source{Name: filepath.Base(s.program), Path: s.program}
+	frames := make([]StackFrame, 0)
+	for i, entry := range machine.Frames() {
+		frames = append(frames, StackFrame{
+			ID: i + 1, // 0在DAP里有特殊含义，栈帧编号从1开始
+			Name: entry.Function,
+			Source: source,
+			Line: entry.Line,
+			Column: entry.Column,
+		})
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+func (s *Server) scopes(req *Request) (interface{}, error) {
+	var args ScopesArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+	if _, err := s.pausedMachine(); err != nil {
+		return nil, err
+	}
+
+	frame := args.FrameID - 1
+	scopes := []Scope{
+		{Name: "Locals", VariablesReference: 2 + 2*frame},
+		{Name: "Closure", VariablesReference: 3 + 2*frame},
+		{Name: "Globals", VariablesReference: globalsReference},
+	}
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+func (s *Server) variables(req *Request) (interface{}, error) {
+	var args VariablesArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+	machine, err := s.pausedMachine()
+	if err != nil {
+		return nil, err
+	}
+
+	var vars []vm.Variable
+	ref := args.VariablesReference
+	switch {
+	case ref == globalsReference:
+		vars = s.globals(machine)
+	case ref >= 2 && ref%2 == 0:
+		vars, err = machine.Locals((ref - 2) / 2)
+	case ref >= 3:
+		vars, err = machine.FreeVariables((ref - 3) / 2)
+	default:
+		err = fmt.Errorf("invalid variables reference %d", ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	variables := make([]Variable, len(vars))
+	for i, v := range vars {
+		variables[i] = toVariable(v)
+	}
+	return map[string]interface{}{"variables": variables}, nil
+}
+
+func (s *Server) globals(machine *vm.VM) []vm.Variable {
+	var vars []vm.Variable
+	for _, symbol := range s.symbols.Symbols() {
+		if symbol.Scope == compiler.GlobalScope {
+			vars = append(vars, vm.Variable{Name: symbol.Name, Value: machine.Global(symbol.Index)})
+		}
+	}
+	return vars
+}
+
+// evaluate 只支持变量名，依次查找栈帧的局部变量、自由变量和全局变量，编辑器用它显示鼠标悬停处的变量
+func (s *Server) evaluate(req *Request) (interface{}, error) {
+	var args EvaluateArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+	machine, err := s.pausedMachine()
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(args.Expression)
+	frame := args.FrameID - 1
+	if frame < 0 {
+		frame = 0
+	}
+	locals, _ := machine.Locals(frame)
+	free, _ := machine.FreeVariables(frame)
+	candidates := append(append(locals, free...), s.globals(machine)...)
+	for _, v := range candidates {
+		if v.Name == name {
+			result := toVariable(v)
+			return map[string]interface{}{"result": result.Value, "type": result.Type, "variablesReference": 0}, nil
+		}
+	}
+	return nil, fmt.Errorf("no variable named %s", name)
+}
+
+// toVariable 还没有赋值的变量在栈上或者全局变量表里是nil
+func toVariable(v vm.Variable) Variable {
+	if v.Value == nil {
+		return Variable{Name: v.Name, Value: "<unset>"}
+	}
+	return Variable{Name: v.Name, Value: v.Value.Inspect(), Type: string(v.Value.Type())}
+}
+
+func (s *Server) respond(req *Request, body interface{}) error {
+	return s.send(&Response{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (s *Server) event(name string, body interface{}) error {
+	return s.send(&Event{Type: "event", Event: name, Body: body})
+}
+
+// send 给消息分配序号并写出
+func (s *Server) send(msg interface{}) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.seq++
+	switch m := msg.(type) {
+	case *Response:
+		m.Seq = s.seq
+	case *Event:
+		m.Seq = s.seq
+	}
+	return wire.WriteMessage(s.out, msg)
+}
@@ -0,0 +1,329 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"glue/internal/wire"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const program = `let g = 10;
+let add = fn(a, b) {
+let s = a + b;
+return s;
+};
+let mk = fn(x) {
+fn(y) {
+add(x, y)
+}
+};
+let h = mk(1);
+let v = h(g);
+v;`
+
+// message 客户端收到的响应或者事件，只解析测试用到的字段
+type message struct {
+	Seq int `json:"seq"`
+	Type string `json:"type"`
+	Command string `json:"command"`
+	Event string `json:"event"`
+	RequestSeq int `json:"request_seq"`
+	Success bool `json:"success"`
+	Message string `json:"message"`
+	Body json.RawMessage `json:"body"`
+}
+
+// testClient 按脚本发送请求的DAP客户端
+type testClient struct {
+	t *testing.T
+	w io.Writer
+	r *bufio.Reader
+	seq int
+	messages chan message
+	done chan error
+}
+
+func startServer(t *testing.T) *testClient {
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	c := &testClient{t: t, w: reqW, r: bufio.NewReader(respR), messages: make(chan message, 100), done: make(chan error, 1)}
+
+	server := NewServer(reqR, respW)
+	go func() {
+		c.done <- server.Serve()
+		respW.Close()
+	}()
+	go func() {
+		defer close(c.messages)
+		for {
+			data, err := wire.ReadMessage(c.r)
+			if err != nil {
+				return
+			}
+			var msg message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Errorf("invalid message %s: %s", data, err)
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+	return c
+}
+
+func (c *testClient) send(command string, args interface{}) int {
+	c.seq++
+	req := map[string]interface{}{"seq": c.seq, "type": "request", "command": command}
+	if args != nil {
+		req["arguments"] = args
+	}
+	if err := wire.WriteMessage(c.w, req); err != nil {
+		c.t.Fatalf("send %s: %s", command, err)
+	}
+	return c.seq
+}
+
+// next 读取下一条不是output事件的消息
+func (c *testClient) next() message {
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				c.t.Fatalf("server closed the connection")
+			}
+			if msg.Type == "event" && msg.Event == "output" {
+				continue
+			}
+			return msg
+		case <-time.After(5 * time.Second):
+			c.t.Fatalf("timed out waiting for a message")
+		}
+	}
+}
+
+// request 发送请求，检查紧接着收到的是成功的响应，把响应体解析到body
+func (c *testClient) request(command string, args interface{}, body interface{}) {
+	seq := c.send(command, args)
+	msg := c.next()
+	if msg.Type != "response" || msg.RequestSeq != seq || msg.Command != command {
+		c.t.Fatalf("expected response to %s, got=%+v", command, msg)
+	}
+	if !msg.Success {
+		c.t.Fatalf("%s failed: %s", command, msg.Message)
+	}
+	if body != nil {
+		if err := json.Unmarshal(msg.Body, body); err != nil {
+			c.t.Fatalf("invalid %s response body %s: %s", command, msg.Body, err)
+		}
+	}
+}
+
+func (c *testClient) expectEvent(event string) message {
+	msg := c.next()
+	if msg.Type != "event" || msg.Event != event {
+		c.t.Fatalf("expected %s event, got=%+v", event, msg)
+	}
+	return msg
+}
+
+func (c *testClient) expectStopped(reason string) {
+	msg := c.expectEvent("stopped")
+	var body StoppedEventBody
+	json.Unmarshal(msg.Body, &body)
+	if body.Reason != reason {
+		c.t.Fatalf("wrong stop reason. want=%s, got=%s", reason, body.Reason)
+	}
+}
+
+func (c *testClient) stackTrace() []StackFrame {
+	var body struct {
+		StackFrames []StackFrame `json:"stackFrames"`
+	}
+	c.request("stackTrace", map[string]interface{}{"threadId": threadID}, &body)
+	return body.StackFrames
+}
+
+func (c *testClient) variables(ref int) map[string]string {
+	var body struct {
+		Variables []Variable `json:"variables"`
+	}
+	c.request("variables", map[string]interface{}{"variablesReference": ref}, &body)
+	vars := map[string]string{}
+	for _, v := range body.Variables {
+		vars[v.Name] = v.Value
+	}
+	return vars
+}
+
+// launch 完成初始化、启动和设置断点，直到configurationDone
+func (c *testClient) launch(stopOnEntry bool, breakpoints ...int) string {
+	path := filepath.Join(c.t.TempDir(), "main.gl")
+	if err := os.WriteFile(path, []byte(program), 0644); err != nil {
+		c.t.Fatal(err)
+	}
+
+	var capabilities Capabilities
+	c.request("initialize", map[string]interface{}{"adapterID": "glue"}, &capabilities)
+	if !capabilities.SupportsConfigurationDoneRequest {
+		c.t.Errorf("configurationDone not supported")
+	}
+	c.expectEvent("initialized")
+	c.request("launch", map[string]interface{}{"program": path, "stopOnEntry": stopOnEntry}, nil)
+
+	bps := make([]SourceBreakpoint, len(breakpoints))
+	for i, line := range breakpoints {
+		bps[i] = SourceBreakpoint{Line: line}
+	}
+	var body struct {
+		Breakpoints []Breakpoint `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", SetBreakpointsArguments{Source: Source{Path: path}, Breakpoints: bps}, &body)
+	for i, bp := range body.Breakpoints {
+		if !bp.Verified || bp.Line != breakpoints[i] {
+			c.t.Errorf("breakpoint %d not verified: %+v", breakpoints[i], bp)
+		}
+	}
+	c.request("configurationDone", nil, nil)
+	return path
+}
+
+// disconnect 中止程序并断开连接，中止时服务端可能先发出terminated事件
+func (c *testClient) disconnect() {
+	seq := c.send("disconnect", nil)
+	msg := c.next()
+	for msg.Type == "event" && msg.Event == "terminated" {
+		msg = c.next()
+	}
+	if msg.Type != "response" || msg.RequestSeq != seq || !msg.Success {
+		c.t.Fatalf("expected response to disconnect, got=%+v", msg)
+	}
+	if err := <-c.done; err != nil {
+		c.t.Fatalf("serve error: %s", err)
+	}
+}
+
+func TestBreakpointsStackAndVariables(t *testing.T) {
+	c := startServer(t)
+	path := c.launch(false, 4)
+	c.expectStopped("breakpoint")
+
+	var threads struct {
+		Threads []Thread `json:"threads"`
+	}
+	c.request("threads", nil, &threads)
+	if len(threads.Threads) != 1 || threads.Threads[0].ID != threadID {
+		t.Errorf("wrong threads: %+v", threads.Threads)
+	}
+
+	frames := c.stackTrace()
+	expected := []StackFrame{
+		{ID: 1, Name: "add", Line: 4, Column: 8},
+		{ID: 2, Name: "<anonymous>", Line: 8, Column: 4},
+		{ID: 3, Name: "<main>", Line: 12, Column: 10},
+	}
+	if len(frames) != len(expected) {
+		t.Fatalf("wrong stack trace: %+v", frames)
+	}
+	for i, frame := range frames {
+		if frame.Source == nil || frame.Source.Path != path {
+			t.Errorf("wrong source of frame %d: %+v", i, frame.Source)
+		}
+		frame.Source = nil
+		if frame != expected[i] {
+			t.Errorf("wrong frame %d. want=%+v, got=%+v", i, expected[i], frame)
+		}
+	}
+
+	var scopes struct {
+		Scopes []Scope `json:"scopes"`
+	}
+	c.request("scopes", ScopesArguments{FrameID: 2}, &scopes)
+	if len(scopes.Scopes) != 3 {
+		t.Fatalf("wrong scopes: %+v", scopes.Scopes)
+	}
+	refs := map[string]int{}
+	for _, scope := range scopes.Scopes {
+		refs[scope.Name] = scope.VariablesReference
+	}
+
+	if vars := c.variables(refs["Closure"]); vars["x"] != "1" {
+		t.Errorf("wrong closure variables: %v", vars)
+	}
+	if vars := c.variables(refs["Locals"]); vars["y"] != "10" {
+		t.Errorf("wrong locals of frame 2: %v", vars)
+	}
+	if vars := c.variables(refs["Globals"]); vars["g"] != "10" || vars["v"] != "<unset>" {
+		t.Errorf("wrong globals: %v", vars)
+	}
+
+	c.request("scopes", ScopesArguments{FrameID: 1}, &scopes)
+	if vars := c.variables(scopes.Scopes[0].VariablesReference); vars["a"] != "1" || vars["b"] != "10" || vars["s"] != "11" {
+		t.Errorf("wrong locals of frame 1: %v", vars)
+	}
+
+	var result struct {
+		Result string `json:"result"`
+	}
+	c.request("evaluate", EvaluateArguments{Expression: "s", FrameID: 1}, &result)
+	if result.Result != "11" {
+		t.Errorf("wrong evaluate result. want=11, got=%s", result.Result)
+	}
+
+	c.request("continue", map[string]interface{}{"threadId": threadID}, nil)
+	c.expectEvent("exited")
+	c.expectEvent("terminated")
+	c.disconnect()
+}
+
+func TestStepping(t *testing.T) {
+	c := startServer(t)
+	c.launch(true)
+	c.expectStopped("entry")
+
+	steps := []struct {
+		command string
+		function string
+		line int
+	}{
+		{"next", "<main>", 2},
+		{"next", "<main>", 6},
+		{"next", "<main>", 11},
+		{"next", "<main>", 12},
+		{"stepIn", "<anonymous>", 8},
+		{"stepIn", "add", 3},
+		{"stepOut", "<anonymous>", 8},
+		{"stepOut", "<main>", 12},
+		{"next", "<main>", 13},
+	}
+	for _, step := range steps {
+		c.request(step.command, map[string]interface{}{"threadId": threadID}, nil)
+		c.expectStopped("step")
+		frames := c.stackTrace()
+		if frames[0].Name != step.function || frames[0].Line != step.line {
+			t.Fatalf("wrong position after %s. want=%s:%d, got=%s:%d",
+				step.command, step.function, step.line, frames[0].Name, frames[0].Line)
+		}
+	}
+
+	c.disconnect()
+}
+
+func TestRequestsWhileNotPaused(t *testing.T) {
+	c := startServer(t)
+	seq := c.send("stackTrace", map[string]interface{}{"threadId": threadID})
+	msg := c.next()
+	if msg.RequestSeq != seq || msg.Success {
+		t.Errorf("expected failed response, got=%+v", msg)
+	}
+
+	seq = c.send("launch", map[string]interface{}{"program": filepath.Join(t.TempDir(), "missing.gl")})
+	msg = c.next()
+	if msg.RequestSeq != seq || msg.Success {
+		t.Errorf("expected failed launch, got=%+v", msg)
+	}
+	c.disconnect()
+}
@@ -0,0 +1,257 @@
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"glue/compiler"
+	"glue/object"
+	"glue/vm"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const PROMPT = "(glue) "
+
+// ErrQuit 用户在调试器里输入quit，或者输入结束，VM的执行以这个错误中止，可以用errors.Is判断
+var ErrQuit = errors.New("debugger: quit")
+
+// Debugger /**
+/*
+基于VM调试钩子的命令行单步调试器。在Stepper决定暂停的地方从输入读取命令，直到遇到继续执行的命令。
+开始执行时停在第一行
+ */
+type Debugger struct {
+	symbols *compiler.SymbolTable // 全局符号表，按名字查找全局变量
+	source []string // 源码的每一行，用于显示当前位置，可以为nil
+
+	scanner *bufio.Scanner
+	out io.Writer
+
+	stepper *Stepper
+	lastCommand string
+}
+
+func New(symbols *compiler.SymbolTable, source []string, in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		symbols: symbols,
+		source: source,
+		scanner: bufio.NewScanner(in),
+		out: out,
+		stepper: NewStepper(StepInto),
+	}
+}
+
+// Attach 在machine上安装调试钩子，之后调用machine.Run就会进入调试
+func (d *Debugger) Attach(machine *vm.VM) {
+	machine.SetDebugHook(d.hook)
+}
+
+func (d *Debugger) SetBreakpoint(line int) {
+	d.stepper.SetBreakpoint(line)
+}
+
+func (d *Debugger) ClearBreakpoint(line int) {
+	d.stepper.ClearBreakpoint(line)
+}
+
+func (d *Debugger) hook(machine *vm.VM) error {
+	if d.stepper.Check(machine) == "" {
+		return nil
+	}
+	return d.prompt(machine)
+}
+
+// prompt 显示当前位置，读取并执行命令，直到遇到让VM继续执行的命令
+func (d *Debugger) prompt(machine *vm.VM) error {
+	d.printLocation(machine)
+
+	for {
+		fmt.Fprint(d.out, PROMPT)
+		if !d.scanner.Scan() {
+			fmt.Fprintln(d.out)
+			return ErrQuit
+		}
+		line := strings.TrimSpace(d.scanner.Text())
+		if line == "" {
+			line = d.lastCommand // 空行重复上一条命令
+		}
+		d.lastCommand = line
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		cmd, args := fields[0], fields[1:]
+
+		switch cmd {
+		case "s", "step":
+			d.stepper.Resume(StepInto)
+			return nil
+		case "n", "next":
+			d.stepper.Resume(StepOver)
+			return nil
+		case "o", "out", "finish":
+			d.stepper.Resume(StepOut)
+			return nil
+		case "c", "continue":
+			d.stepper.Resume(Continue)
+			return nil
+		case "q", "quit":
+			return ErrQuit
+		case "b", "break":
+			d.breakCommand(args)
+		case "d", "delete":
+			d.deleteCommand(args)
+		case "l", "locals":
+			d.variablesCommand(machine, args, machine.Locals)
+		case "f", "free":
+			d.variablesCommand(machine, args, machine.FreeVariables)
+		case "g", "globals":
+			d.globalsCommand(machine)
+		case "p", "print":
+			d.printCommand(machine, args)
+		case "bt", "where":
+			d.whereCommand(machine)
+		case "list":
+			d.printLocation(machine)
+		case "h", "help":
+			d.helpCommand()
+		default:
+			fmt.Fprintf(d.out, "unknown command %q, type help for a list of commands\n", cmd)
+		}
+	}
+}
+
+func (d *Debugger) printLocation(machine *vm.VM) {
+	pos, _ := machine.Position()
+	fn := machine.Frames()[0].Function
+	fmt.Fprintf(d.out, "%s at line %d:%d\n", fn, pos.Line, pos.Column)
+	if pos.Line >= 1 && pos.Line <= len(d.source) {
+		fmt.Fprintf(d.out, "%4d\t%s\n", pos.Line, d.source[pos.Line-1])
+	}
+}
+
+func (d *Debugger) breakCommand(args []string) {
+	if len(args) == 0 {
+		for _, line := range d.stepper.Breakpoints() {
+			fmt.Fprintf(d.out, "breakpoint at line %d\n", line)
+		}
+		return
+	}
+	for _, arg := range args {
+		line, err := strconv.Atoi(arg)
+		if err != nil || line < 1 {
+			fmt.Fprintf(d.out, "invalid line number %q\n", arg)
+			continue
+		}
+		d.SetBreakpoint(line)
+	}
+}
+
+func (d *Debugger) deleteCommand(args []string) {
+	if len(args) == 0 {
+		d.stepper.ClearBreakpoints()
+		return
+	}
+	for _, arg := range args {
+		line, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Fprintf(d.out, "invalid line number %q\n", arg)
+			continue
+		}
+		d.ClearBreakpoint(line)
+	}
+}
+
+// variablesCommand 显示某个栈帧的局部变量或者自由变量，参数是栈帧编号，默认是最内层的0
+func (d *Debugger) variablesCommand(machine *vm.VM, args []string, variables func(frame int) ([]vm.Variable, error)) {
+	frame := 0
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Fprintf(d.out, "invalid frame number %q\n", args[0])
+			return
+		}
+		frame = n
+	}
+
+	vars, err := variables(frame)
+	if err != nil {
+		fmt.Fprintln(d.out, err)
+		return
+	}
+	if len(vars) == 0 {
+		fmt.Fprintln(d.out, "(none)")
+	}
+	for _, v := range vars {
+		fmt.Fprintf(d.out, "%s = %s\n", v.Name, inspect(v.Value))
+	}
+}
+
+func (d *Debugger) globalsCommand(machine *vm.VM) {
+	for _, symbol := range d.symbols.Symbols() {
+		if symbol.Scope == compiler.GlobalScope {
+			fmt.Fprintf(d.out, "%s = %s\n", symbol.Name, inspect(machine.Global(symbol.Index)))
+		}
+	}
+}
+
+// printCommand 按名字查找变量，依次查找当前栈帧的局部变量、自由变量和全局变量
+func (d *Debugger) printCommand(machine *vm.VM, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "usage: print <name>")
+		return
+	}
+	name := args[0]
+
+	for _, variables := range []func(int) ([]vm.Variable, error){machine.Locals, machine.FreeVariables} {
+		vars, _ := variables(0)
+		for _, v := range vars {
+			if v.Name == name {
+				fmt.Fprintln(d.out, inspect(v.Value))
+				return
+			}
+		}
+	}
+
+	if symbol, ok := d.symbols.Resolve(name); ok && symbol.Scope == compiler.GlobalScope {
+		fmt.Fprintln(d.out, inspect(machine.Global(symbol.Index)))
+		return
+	}
+	fmt.Fprintf(d.out, "no variable named %s\n", name)
+}
+
+func (d *Debugger) whereCommand(machine *vm.VM) {
+	for i, entry := range machine.Frames() {
+		fmt.Fprintf(d.out, "#%d %s at line %d:%d\n", i, entry.Function, entry.Line, entry.Column)
+	}
+}
+
+func (d *Debugger) helpCommand() {
+	fmt.Fprint(d.out, `commands:
+  s, step             step to the next line, entering function calls
+  n, next             step to the next line, stepping over function calls
+  o, out, finish      run until the current function returns
+  c, continue         run until the next breakpoint
+  b, break [line...]  set breakpoints, or list them without arguments
+  d, delete [line...] delete breakpoints, or all of them without arguments
+  l, locals [frame]   show local variables, frame 0 is the innermost
+  f, free [frame]     show free variables of the frame's closure
+  g, globals          show global variables
+  p, print <name>     show a local, free or global variable
+  bt, where           show the call stack
+  list                show the current line
+  q, quit             stop the program
+an empty line repeats the previous command
+`)
+}
+
+// inspect 还没有赋值的变量在栈上或者全局变量表里是nil
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "<unset>"
+	}
+	return obj.Inspect()
+}
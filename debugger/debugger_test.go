@@ -0,0 +1,169 @@
+package debugger
+
+import (
+	"bytes"
+	"errors"
+	"glue/compiler"
+	"glue/lexer"
+	"glue/parser"
+	"glue/vm"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const input = `let g = 10;
+let add = fn(a, b) {
+let s = a + b;
+return s;
+};
+let mk = fn(x) {
+fn(y) {
+add(x, y)
+}
+};
+let h = mk(1);
+let v = h(g);
+v;`
+
+// runSession 用给定的命令调试input，返回调试器的输出和VM的执行结果
+func runSession(t *testing.T, commands string, breakpoints ...int) (string, error) {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if p.HasError() {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out bytes.Buffer
+	d := New(comp.SymbolTable(), strings.Split(input, "\n"), strings.NewReader(commands), &out)
+	for _, line := range breakpoints {
+		d.SetBreakpoint(line)
+	}
+	machine := vm.New(comp.Bytecode())
+	d.Attach(machine)
+	err := machine.Run()
+	return out.String(), err
+}
+
+var locationPattern = regexp.MustCompile(`^(\S+ at line \d+):\d+$`)
+
+// locations 输出中每次暂停的位置，形如"add at line 4"
+func locations(output string) []string {
+	var locs []string
+	for _, line := range strings.Split(output, "\n") {
+		for strings.HasPrefix(line, PROMPT) {
+			line = strings.TrimPrefix(line, PROMPT)
+		}
+		if m := locationPattern.FindStringSubmatch(line); m != nil {
+			locs = append(locs, m[1])
+		}
+	}
+	return locs
+}
+
+func TestStepping(t *testing.T) {
+	tests := []struct {
+		commands string
+		expected []string
+	}{
+		{
+			"n\nn\nn\nn\nn\nn\nn\n",
+			[]string{"<main> at line 1", "<main> at line 2", "<main> at line 6", "<main> at line 11",
+				"<main> at line 12", "<main> at line 13"},
+		},
+		{
+			// 进入h，再进入add，跳出add回到h的调用处，空行重复上一条命令
+			"n\nn\nn\nn\ns\ns\no\n\nc\n",
+			[]string{"<main> at line 1", "<main> at line 2", "<main> at line 6", "<main> at line 11",
+				"<main> at line 12", "<anonymous> at line 8", "add at line 3", "<anonymous> at line 8",
+				"<main> at line 12"},
+		},
+		{
+			// 没有断点时continue直接执行完
+			"c\n",
+			[]string{"<main> at line 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		output, err := runSession(t, tt.commands)
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		locs := locations(output)
+		if strings.Join(locs, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("wrong pauses for %q.\nwant=%q\ngot=%q", tt.commands, tt.expected, locs)
+		}
+	}
+}
+
+func TestBreakpointsAndInspection(t *testing.T) {
+	output, err := runSession(t, "c\nl\nf 1\np x\np g\np nothing\nbt\nd 4\nb 8\nb\nc\nc\n", 4)
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	expected := []string{
+		"add at line 4:8",
+		"a = 1\nb = 10\ns = 11\n",
+		"x = 1\n",
+		"no variable named x\n",
+		PROMPT + "10\n",
+		"no variable named nothing\n",
+		"#0 add at line 4:8\n#1 <anonymous> at line 8:4\n#2 <main> at line 12:10\n",
+		"breakpoint at line 8\n",
+	}
+	for _, s := range expected {
+		if !strings.Contains(output, s) {
+			t.Errorf("output does not contain %q. got=\n%s", s, output)
+		}
+	}
+	// 断点4删除后不再停下，8在add返回之后不算到达新的一行
+	if locs := locations(output); len(locs) != 2 {
+		t.Errorf("wrong number of pauses. want=2, got=%q", locs)
+	}
+}
+
+// TestBreakpointInSingleLineLoop 循环写在一行里时，每次往回跳转都算重新到达这一行
+func TestBreakpointInSingleLineLoop(t *testing.T) {
+	p := parser.New(lexer.New("let i = 0;\nwhile (i < 3) { i = i + 1 }\ni;"))
+	program := p.ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	stepper := NewStepper(Continue)
+	stepper.SetBreakpoint(2)
+	machine := vm.New(comp.Bytecode())
+	hits := 0
+	machine.SetDebugHook(func(machine *vm.VM) error {
+		if stepper.Check(machine) == StopBreakpoint {
+			hits++
+		}
+		return nil
+	})
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	// 第一次进入循环，再加上三次往回跳转到条件判断
+	if hits != 4 {
+		t.Errorf("wrong number of breakpoint hits. want=4, got=%d", hits)
+	}
+}
+
+func TestQuit(t *testing.T) {
+	for _, commands := range []string{"q\n", "n\n"} {
+		output, err := runSession(t, commands)
+		if !errors.Is(err, ErrQuit) {
+			t.Errorf("expected ErrQuit for %q, got=%v", commands, err)
+		}
+		if strings.Contains(output, "line 13") {
+			t.Errorf("program did not stop for %q. got=\n%s", commands, output)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package debugger
+
+import (
+	"glue/vm"
+	"sort"
+)
+
+type StepMode int
+
+const (
+	StepInto StepMode = iota // 停在下一个新的源码行，包括进入被调用的函数
+	StepOver // 停在当前函数（或者调用者）的下一个新的源码行，不进入被调用的函数
+	StepOut // 当前函数返回到调用者之后停下
+	Continue // 只在断点处停下
+	Pause // 在下一条有源码位置的指令处停下，用于中断正在运行的程序
+)
+
+// StopReason 暂停的原因，空字符串表示不暂停
+type StopReason string
+
+const (
+	StopStep StopReason = "step"
+	StopBreakpoint StopReason = "breakpoint"
+	StopPause StopReason = "pause"
+)
+
+// Stepper /**
+/*
+根据断点和单步命令决定VM在哪条指令之前暂停，命令行调试器和DAP服务共用。
+以源码行为单位暂停：同一个栈帧里指令对应的行号发生变化，或者往回跳转（循环进入下一轮）时算作到达新的一行，
+这样写在一行里的循环每一轮都会停在断点上。到达断点所在的行，或者满足单步命令的条件时暂停。Stepper本身不是并发安全的
+ */
+type Stepper struct {
+	breakpoints map[int]bool
+	mode StepMode
+	depth int // 上一次暂停时的调用深度，单步命令以它为准
+	lines []int // 每层栈帧最近执行到的行号，下标是调用深度，0表示刚进入还没有执行到任何一行
+	ips []int // 每层栈帧最近执行到的指令位置，ip变小说明往回跳转了
+}
+
+func NewStepper(mode StepMode) *Stepper {
+	return &Stepper{breakpoints: map[int]bool{}, mode: mode}
+}
+
+func (s *Stepper) SetBreakpoint(line int) {
+	s.breakpoints[line] = true
+}
+
+func (s *Stepper) ClearBreakpoint(line int) {
+	delete(s.breakpoints, line)
+}
+
+func (s *Stepper) ClearBreakpoints() {
+	s.breakpoints = map[int]bool{}
+}
+
+// Breakpoints 所有断点所在的行，升序排列
+func (s *Stepper) Breakpoints() []int {
+	lines := make([]int, 0, len(s.breakpoints))
+	for line := range s.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// Resume 设置恢复执行后的单步方式，单步命令相对的是最近一次暂停时的位置
+func (s *Stepper) Resume(mode StepMode) {
+	s.mode = mode
+}
+
+// Check 在调试钩子里对每条指令调用，返回是否应该在这条指令之前暂停
+func (s *Stepper) Check(machine *vm.VM) StopReason {
+	pos, ok := machine.Position()
+	if !ok {
+		return ""
+	}
+
+	// 调用深度每条指令最多变化1，函数返回后先回到调用者那一层，更深层记录的行号在这里就被丢掉了，
+	// 所以新压入的栈帧总是从0开始
+	depth := machine.CallDepth()
+	for len(s.lines) <= depth {
+		s.lines = append(s.lines, 0)
+		s.ips = append(s.ips, 0)
+	}
+	s.lines = s.lines[:depth+1]
+	s.ips = s.ips[:depth+1]
+	ip := machine.IP()
+	newLine := s.lines[depth] != pos.Line || ip < s.ips[depth]
+	s.lines[depth] = pos.Line
+	s.ips[depth] = ip
+
+	reason := s.reason(depth, pos.Line, newLine)
+	if reason != "" {
+		s.depth = depth
+	}
+	return reason
+}
+
+func (s *Stepper) reason(depth, line int, newLine bool) StopReason {
+	if newLine && s.breakpoints[line] {
+		return StopBreakpoint
+	}
+
+	var stop bool
+	switch s.mode {
+	case StepInto:
+		stop = newLine || depth < s.depth
+	case StepOver:
+		stop = newLine && depth <= s.depth || depth < s.depth
+	case StepOut:
+		stop = depth < s.depth
+	case Pause:
+		return StopPause
+	}
+	if stop {
+		return StopStep
+	}
+	return ""
+}
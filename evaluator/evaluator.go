@@ -1,19 +1,46 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
 	"glue/ast"
 	"glue/object"
+	"math"
 	"reflect"
+	"strings"
 )
 
 var (
 	NULL = &object.Null{}
 	TRUE = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+	BREAK = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
+// EvalContext /**
+/*
+带资源限制的Eval：ctx结束或者超出limits时中止求值，返回*object.LimitError。
+限制挂在env最外层的环境上，求值结束后恢复原来的设置
+ */
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits object.Limits) (object.Object, error) {
+	budget := object.NewBudget(ctx, limits)
+	previous := env.Budget()
+	env.SetBudget(budget)
+	defer env.SetBudget(previous)
+
+	result := Eval(node, env)
+	if err := budget.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	if err := env.Budget().Step(); err != nil {
+		return newError("%s", err)
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node, env)
@@ -21,6 +48,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		//return &object.Boolean{Value: node.Value}
 		//对上面代码的优化，不必每次使用布尔值都创建新对象来表示，所有布尔值引用同一个true/false Object
@@ -33,6 +62,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -58,6 +90,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		env.Set(node.Name.Value, val)
 	case *ast.AssignStatement:
+		// 复合赋值先取出变量原来的值，变量没有定义时报错
+		var current object.Object
+		if node.Operator != "=" {
+			current = evalIdentifier(node.Lhs, env)
+			if isError(current) {
+				return current
+			}
+		}
 		val := Eval(node.Rhs, env)
 		if isError(val) {
 			return val
@@ -65,8 +105,47 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if val==nil || reflect.ValueOf(val).IsNil() {
 			val = &object.Null{}
 		}
+		if current != nil {
+			val = evalCompoundOperator(node.Operator, current, val)
+			if isError(val) {
+				return val
+			}
+		}
 
-		env.Set(node.Lhs.Value, val)
+		env.Assign(node.Lhs.Value, val)
+	case *ast.IndexAssignStatement:
+		left := Eval(node.Target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(node.Target.Index, env)
+		if isError(index) {
+			return index
+		}
+		// 复合赋值用已经求出的对象和索引取原来的值，它们只求一次
+		var current object.Object
+		if node.Operator != "=" {
+			current = evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+		}
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		if val==nil || reflect.ValueOf(val).IsNil() {
+			val = NULL
+		}
+		if current != nil {
+			val = evalCompoundOperator(node.Operator, current, val)
+			if isError(val) {
+				return val
+			}
+		}
+		if err := evalIndexAssignment(left, index, val); err != nil {
+			return err
+		}
 	case *ast.FunctionDefinitionStatement:
 		evalFuncDefStatement(node, env)
 	case *ast.Identifier:
@@ -112,6 +191,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalHashLiteral(node, env)
 	case *ast.WhileStatement:
 		return evalWhileStatement(node,env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.ForInStatement:
+		return evalForInStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	}
 	return nil
 	//return newError("No valid ast node to evaluate")
@@ -127,7 +214,6 @@ func evalFuncDefStatement(node *ast.FunctionDefinitionStatement, env *object.Env
 }
 
 func evalWhileStatement(node *ast.WhileStatement, env *object.Environment) object.Object {
-	var MAX = 1024
 	for {
 		condition := Eval(node.Condition, env)
 		if isError(condition) {
@@ -138,15 +224,78 @@ func evalWhileStatement(node *ast.WhileStatement, env *object.Environment) objec
 			if isFromReturnStatement(result) {
 				return result
 			}
+			if result == BREAK {
+				break
+			}
+			// continue不需要特殊处理，循环体已经在continue处停止执行了，直接进入下一轮条件判断即可
 		}else {
 			break
 		}
-		// 临时保护机制，避免因为语言本身的解析bug导致用户程序的死循环，语言稳定后要去掉
-		if MAX>0 {
-			MAX--
+	}
+	return NULL
+}
+
+// evalForStatement 跟while一样，continue之后照常执行post
+func evalForStatement(node *ast.ForStatement, env *object.Environment) object.Object {
+	if node.Init != nil {
+		if result := Eval(node.Init, env); isError(result) {
+			return result
+		}
+	}
+	for {
+		if node.Condition != nil {
+			condition := Eval(node.Condition, env)
+			if isError(condition) {
+				return condition
+			}
+			if !isTruthy(condition) {
+				break
+			}
+		}
+		result := Eval(node.Body, env)
+		if isFromReturnStatement(result) {
+			return result
+		}
+		if result == BREAK {
+			break
+		}
+		if node.Post != nil {
+			if result := Eval(node.Post, env); isError(result) {
+				return result
+			}
+		}
+	}
+	return NULL
+}
+
+// evalForInStatement 遍历的顺序和循环变量的值跟VM完全一样，都由object.Iterator决定
+func evalForInStatement(node *ast.ForInStatement, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	iterator, err := object.NewIterator(iterable)
+	if err != nil {
+		return newError("%s", err)
+	}
+
+	for {
+		key, value, ok := iterator.Next()
+		if !ok {
+			break
+		}
+		if node.Key != nil {
+			env.Set(node.Key.Value, key)
+		}else {
+			value = iterator.Item(key, value)
+		}
+		env.Set(node.Value.Value, value)
+
+		result := Eval(node.Body, env)
+		if isFromReturnStatement(result) {
+			return result
 		}
-		if MAX == 0 {
-			fmt.Println("循环达到安全上限1024，被解释器强制退出，有死循环bug？")
+		if result == BREAK {
 			break
 		}
 	}
@@ -227,10 +376,44 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
+// evalCompoundOperator 复合赋值a += b按a + b计算，运算规则跟二元运算完全一样
+func evalCompoundOperator(operator string, current, value object.Object) object.Object {
+	return evalInfixExpression(strings.TrimSuffix(operator, "="), current, value)
+}
+
+// evalIndexAssignment 给数组的元素或者哈希的键赋值，直接修改原来的对象，数组的索引不能越界
+func evalIndexAssignment(left, index, value object.Object) *object.Error {
+	switch left := left.(type) {
+	case *object.Array:
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		if idx.Value < 0 || idx.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %d with length %d", idx.Value, len(left.Elements))
+		}
+		left.Elements[idx.Value] = value
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+	return nil
+}
+
 func applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
 		extendedEnv := extendFunctionEnv(fn, args)
+		budget := extendedEnv.Budget()
+		if err := budget.Enter(); err != nil {
+			return newError("%s", err)
+		}
+		defer budget.Leave()
 		evaluated :=Eval(fn.Body, extendedEnv)
 
 		return unwrapReturnValue(evaluated)
@@ -317,7 +500,7 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 
 		if result != nil {
 			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ{
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ || rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
 				return result
 			}
 		}
@@ -343,6 +526,8 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 
 	if isTruthy(condition) {
 		return Eval(ie.Consequence, env)
+	}else if ie.ElseIf != nil {
+		return evalIfExpression(ie.ElseIf, env)
 	}else if ie.Alternative != nil {
 		return Eval(ie.Alternative, env)
 	}else {
@@ -355,6 +540,9 @@ func isTruthy(obj object.Object) bool {
 	if obj.Type() == object.INTEGER_OBJ && obj.(*object.Integer).Value == 0 {
 		return false
 	}
+	if obj.Type() == object.FLOAT_OBJ && obj.(*object.Float).Value == 0 {
+		return false
+	}
 	switch obj {
 	case NULL:
 		return false
@@ -386,24 +574,15 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	}
 }
 
+// evalBangOperatorExpression 取反跟条件判断用同一个真假规则，!0是true
 func evalBangOperatorExpression(right object.Object) object.Object {
-	switch right {
-	case TRUE:
-		//fmt.Println(1)
-		return FALSE
-	case FALSE:
-		//fmt.Println(2)
-		return TRUE
-	case NULL:
-		//fmt.Println(3)
-		return TRUE
-	default:
-		//fmt.Println(4)
-		return FALSE
-	}
+	return nativeBoolToBooleanObject(!isTruthy(right))
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
+	if right, ok := right.(*object.Float); ok {
+		return &object.Float{Value: -right.Value}
+	}
 	if right.Type() != object.INTEGER_OBJ {
 		return newError("unknown operator: -%s", right.Type())
 	}
@@ -416,6 +595,8 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case object.IsNumber(left) && object.IsNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case operator == "==":
 		// 这里的相等和不等是直接比较两个Object 类型left和right，不必提取native value(原生值）：Object.Value来比较，
 		//因为我们所有的布尔类型都只共用两个TRUE和FALSE的实例，都是这两个实例的引用，所以直接比较对象就可以。
@@ -453,11 +634,79 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalLogicalExpression /**
+/*
+&&和||短路求值，左边已经能确定结果时右边不再求值，结果总是布尔值
+ */
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
+// evalFloatInfixExpression 至少有一边是浮点数，整数先提升为浮点数
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := object.FloatValue(left)
+	rightVal := object.FloatValue(right)
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
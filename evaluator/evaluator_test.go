@@ -1,11 +1,14 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"glue/lexer"
 	"glue/object"
 	"glue/parser"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -61,6 +64,50 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct{
+		input string
+		expected interface{}
+	}{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 2.25", 3.75},
+		{"1 + 0.5", 1.5},
+		{"7 / 2.0", 3.5},
+		{"1e2 - 1", 99.0},
+		{"1.5 > 1", true},
+		{"2 < 2.5", true},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"if (0.0) { 1 } else { 2 }", int64(2)},
+		{"if (0.5) { 1 } else { 2 }", int64(1)},
+		{"!0.0", true},
+		{"!-0.0", true},
+		// 值相等的整数和浮点数是同一个键
+		{"{1: 5}[1.0]", int64(5)},
+		{"let h = {2.0: 7}; h[2] = h[2] + 1; h[2.0]", int64(8)},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case float64:
+			result, ok := evaluated.(*object.Float)
+			if !ok {
+				t.Errorf("object is not Float. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if result.Value != expected {
+				t.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+			}
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case int64:
+			testIntegerObject(t, evaluated, expected)
+		}
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input string
@@ -96,6 +143,46 @@ func TestEvalBooleanExpression(t *testing.T) {
 	}
 }
 
+func TestComparisonAndLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{"1 <= 2", true},
+		{"3 <= 2", false},
+		{"2 >= 2", true},
+		{"2.5 >= 2", true},
+		{"7 % 3", int64(1)},
+		{"true && false", false},
+		{"false || true", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		// 短路求值：右边有错误也不会被求值
+		{"false && (1 + true)", false},
+		{"true || (1 + true)", true},
+		{"true && (1 + true)", "type mismatch: INTEGER + BOOLEAN"},
+		{"1 % 0", "division by zero"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case int64:
+			testIntegerObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -121,6 +208,8 @@ func TestBangOperator(t *testing.T) {
 		{"!!true", true},
 		{"!!false", false},
 		{"!!5", true},
+		{"!0", true},
+		{"!!0", false},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -140,6 +229,10 @@ func TestIfElseExpressions(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (1 > 2) { 10 } else if (1 < 2) { 20 } else { 30 }", 20},
+		{"if (1 > 2) { 10 } else if (false) { 20 } else { 30 }", 30},
+		{"if (false) { 10 } else if (false) { 20 }", nil},
+		{"let x = 5; if (x == 1) { 1 } else if (x == 2) { 2 } else if (x == 5) { 5 } else { 0 }", 5},
 	}
 
 	for _, tt := range tests {
@@ -289,6 +382,55 @@ func TestAssignStatements(t *testing.T) {
 	}
 }
 
+func TestWhileStatements(t *testing.T) {
+	tests := []struct{
+		input string
+		expected int64
+	}{
+		{"let i = 0; while (i < 5) { i = i + 1; } i", 5},
+		{"let i = 0; while (true) { i = i + 1; if (i == 3) { break; } } i", 3},
+		// 整数0为假，两个引擎一致
+		{"let i = 3; let n = 0; while (i) { i = i - 1; n = n + 1; if (n > 10) { break; } } n", 3},
+		{`
+let i = 0;
+let sum = 0;
+while (i < 10) {
+	i = i + 1;
+	if (i / 2 * 2 == i) { continue; }
+	sum = sum + i;
+}
+sum`, 25},
+		{`
+let i = 0;
+let count = 0;
+while (i < 3) {
+	let j = 0;
+	while (true) {
+		if (j == 2) { break; }
+		j = j + 1;
+		count = count + 1;
+	}
+	i = i + 1;
+}
+count`, 6},
+		{`
+let f = fn() {
+	let i = 0;
+	while (true) {
+		let g = fn(x) { x * 2 };
+		i = g(i + 1);
+		if (i > 10) { return i; }
+	}
+};
+f()`, 14},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2; };"
 	evaluated := testEval(input)
@@ -361,6 +503,121 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestUnicodeIdentifiers(t *testing.T) {
+	input := `let 问候 = fn(名字) { "你好，" + 名字 + "！" };
+问候("世界")`
+	evaluated := testEval(input)
+	str, ok := evaluated.(*object.String)
+	if !ok {
+		t.Fatalf("object is not String. got=%T (%+v)", evaluated, evaluated)
+	}
+	if str.Value != "你好，世界！" {
+		t.Errorf("String has wrong value. got=%q", str.Value)
+	}
+}
+
+func TestIndexAndCompoundAssignment(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{"let a = [1, 2, 3]; a[1] = 20; a[0] + a[1] + a[2]", 24},
+		{"let a = [[1, 2]]; a[0][1] = 5; a[0][1]", 5},
+		{`let h = {}; h["a"] = 1; h["a"] = h["a"] + 1; h["a"]`, 2},
+		{"let a = [0]; let set = fn(arr) { arr[0] = 9; }; set(a); a[0]", 9},
+		{"let t = {}; let i = 0; while (i < 5) { t[i] = i * i; i = i + 1; } t[4]", 16},
+		{"let a = [1]; a[1] = 2;", "index out of range: 1 with length 1"},
+		{`let a = [1]; a["0"] = 2;`, "array index must be INTEGER, got STRING"},
+		{"let h = {}; h[[1]] = 2;", "unusable as hash key: ARRAY"},
+		{"let x = 1; x[0] = 2;", "index assignment not supported: INTEGER"},
+		// 复合赋值
+		{"let i = 10; i -= 4; i *= 3; i /= 2; i %= 5; i", 4},
+		{"let i = 0; let n = 0; while (i < 5) { i += 1; n += i; } n", 15},
+		{`let h = {"n": 2}; h["n"] *= 5; h["n"]`, 10},
+		{"let calls = [0]; let next = fn() { calls[0] += 1; 0 }; let a = [10]; a[next()] += 5; a[0] + calls[0]", 16},
+		{"y += 1;", "identifier not found: y"},
+		{"let counter = fn() { let c = 0; fn() { c += 1; c } }; let next = counter(); next(); next(); next()", 3},
+		{"let mk = fn() { let n = 10; let g = fn() { n += 1; n }; g() }; mk()", 11},
+		{`let h = {}; h["a"] += 1;`, "type mismatch: NULL + INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("input %q: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []struct {
+		input string
+		expected interface{}
+	}{
+		{"let sum = 0; for (let i = 0; i < 5; i += 1) { sum += i; } sum", 10},
+		{"let i = 0; for (;;) { i += 1; if (i == 4) { break; } } i", 4},
+		{"let sum = 0; for (let i = 0; i < 10; i += 1) { if (i % 2 == 0) { continue; } sum += i; } sum", 25},
+		{"let sum = 0; for (x in [1, 2, 3]) { sum += x; } sum", 6},
+		{"let s = 0; for (i, x in [10, 20, 30]) { s += i * x; } s", 80},
+		{"let last = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } last = x; } last", 2},
+		{"let sum = 0; for (x in [1, 2, 3, 4]) { if (x % 2 == 0) { continue; } sum += x; } sum", 4},
+		{"let find = fn(arr, t) { for (i, x in arr) { if (x == t) { return i; } } -1 }; find([5, 6, 7], 7) + find([5, 6, 7], 8)", 1},
+		{"let a = [1, 2, 3]; for (i, x in a) { a[i] = x * 2; } a[0] + a[1] + a[2]", 12},
+		{"for (x in 1) { }", "cannot iterate over INTEGER"},
+		{"for (let i = 0; i < 3; i += 1) { j; }", "identifier not found: j"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("input %q: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestForInOrder(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+	}{
+		{`let out = ""; for (k in {"b": 1, "a": 2, "c": 3}) { out += k; } out`, "abc"},
+		{`let out = ""; for (k, v in {2: "b", 1: "a", true: "t"}) { out += v; } out`, "tab"},
+		{`let out = ""; for (i, c in "héllo") { out = c + out; } out`, "olléh"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("input %q: object is not String. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if str.Value != tt.expected {
+			t.Errorf("input %q: wrong value. expected=%q, got=%q", tt.input, tt.expected, str.Value)
+		}
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input string
@@ -554,4 +811,41 @@ func TestFunctionDefineStatement(t *testing.T) {
 		t.Errorf("no result got")
 	}
 	testIntegerObject(t, evaluated, 3)
-}
\ No newline at end of file
+}
+func TestEvalContextLimits(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	tests := []struct {
+		input string
+		ctx context.Context
+		limits object.Limits
+		kind object.LimitKind
+	}{
+		{`while (true) {}`, context.Background(), object.Limits{MaxInstructions: 1000}, object.LimitInstructions},
+		{`let f = fn(n) { f(n + 1) }; f(0);`, context.Background(), object.Limits{MaxCallDepth: 10}, object.LimitCallDepth},
+		{`while (true) {}`, expired, object.Limits{}, object.LimitDeadline},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		_, err := EvalContext(tt.ctx, program, object.NewEnvironment(), tt.limits)
+
+		var limitErr *object.LimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%q: expected *object.LimitError, got=%T (%v)", tt.input, err, err)
+			continue
+		}
+		if limitErr.Kind != tt.kind {
+			t.Errorf("%q: wrong limit. want=%s, got=%s", tt.input, tt.kind, limitErr.Kind)
+		}
+	}
+
+	// 以前while循环最多执行1024次，现在只受限制约束
+	program := parser.New(lexer.New(`let i = 0; while (i < 5000) { i = i + 1; } i`)).ParseProgram()
+	result, err := EvalContext(context.Background(), program, object.NewEnvironment(), object.Limits{MaxCallDepth: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testIntegerObject(t, result, 5000)
+}
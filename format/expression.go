@@ -0,0 +1,242 @@
+package format
+
+import (
+	"fmt"
+	"glue/ast"
+	"glue/parser"
+	"glue/token"
+	"strings"
+	"unicode/utf8"
+)
+
+// 二元运算符的优先级，跟parser保持一致
+var precedences = map[string]int{
+	"||": parser.LOGICALOR,
+	"&&": parser.LOGICALAND,
+	"==": parser.EQUALS,
+	"!=": parser.EQUALS,
+	"<": parser.LESSGREATER,
+	">": parser.LESSGREATER,
+	"<=": parser.LESSGREATER,
+	">=": parser.LESSGREATER,
+	"+": parser.SUM,
+	"-": parser.SUM,
+	"*": parser.PRODUCT,
+	"/": parser.PRODUCT,
+	"%": parser.PRODUCT,
+}
+
+// precedence 表达式作为运算对象时的优先级。if表达式和函数字面量按最低的算，作为运算对象时总是加上括号
+func precedence(exp ast.Expression) int {
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		return precedences[exp.Operator]
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression, *ast.IndexExpression:
+		return parser.CALL
+	case *ast.IfExpression, *ast.FunctionLiteral:
+		return parser.LOWEST
+	default:
+		return parser.INDEX + 1
+	}
+}
+
+// operand 输出运算对象，优先级比所在的运算低的要加括号；二元运算都是左结合的，右边优先级相同的也要加括号
+func (p *printer) operand(exp ast.Expression, prec int, right bool) error {
+	operandPrec := precedence(exp)
+	if operandPrec > prec || operandPrec == prec && !right {
+		return p.expression(exp)
+	}
+	p.out.WriteByte('(')
+	if err := p.expression(exp); err != nil {
+		return err
+	}
+	p.out.WriteByte(')')
+	return nil
+}
+
+func (p *printer) expression(exp ast.Expression) error {
+	switch e := exp.(type) {
+	case *ast.Identifier:
+		p.out.WriteString(e.Value)
+	case *ast.IntegerLiteral:
+		p.out.WriteString(e.Token.Literal)
+	case *ast.FloatLiteral:
+		p.out.WriteString(e.Token.Literal)
+	case *ast.Boolean:
+		p.out.WriteString(e.Token.Literal)
+	case *ast.StringLiteral:
+		p.out.WriteString(quote(e.Token))
+	case *ast.PrefixExpression:
+		p.out.WriteString(e.Operator)
+		return p.operand(e.Right, parser.PREFIX, false)
+	case *ast.InfixExpression:
+		prec := precedences[e.Operator]
+		if err := p.operand(e.Left, prec, false); err != nil {
+			return err
+		}
+		p.out.WriteString(" " + e.Operator + " ")
+		return p.operand(e.Right, prec, true)
+	case *ast.IfExpression:
+		p.out.WriteString("if (")
+		if err := p.expression(e.Condition); err != nil {
+			return err
+		}
+		p.out.WriteString(") ")
+		if err := p.block(e.Consequence); err != nil {
+			return err
+		}
+		if e.ElseIf != nil {
+			p.out.WriteString(" else ")
+			return p.expression(e.ElseIf)
+		}
+		if e.Alternative != nil {
+			p.out.WriteString(" else ")
+			return p.block(e.Alternative)
+		}
+	case *ast.FunctionLiteral:
+		p.out.WriteString("fn")
+		return p.function(e)
+	case *ast.CallExpression:
+		if err := p.operand(e.Function, parser.CALL, false); err != nil {
+			return err
+		}
+		p.out.WriteByte('(')
+		for i, arg := range e.Arguments {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			if err := p.expression(arg); err != nil {
+				return err
+			}
+		}
+		p.out.WriteByte(')')
+	case *ast.IndexExpression:
+		if err := p.operand(e.Left, parser.CALL, false); err != nil {
+			return err
+		}
+		p.out.WriteByte('[')
+		if err := p.expression(e.Index); err != nil {
+			return err
+		}
+		p.out.WriteByte(']')
+	case *ast.ArrayLiteral:
+		return p.array(e)
+	case *ast.HashLiteral:
+		return p.hash(e)
+	default:
+		return fmt.Errorf("unsupported expression %T", exp)
+	}
+	return nil
+}
+
+// items /**
+/*
+输出数组或者哈希的元素。源码中最后一个元素跟开头的括号不在同一行时每个元素单独占一行，否则全部写在一行
+ */
+func (p *printer) items(open token.Token, starts []token.Position, item func(i int) error) error {
+	multiline := len(starts) > 0 && starts[len(starts)-1].Line > open.LineNum
+	if multiline {
+		p.indent++
+	}
+	for i := range starts {
+		if multiline {
+			p.newline()
+		}
+		if err := item(i); err != nil {
+			return err
+		}
+		if i < len(starts)-1 {
+			p.out.WriteByte(',')
+			if !multiline {
+				p.out.WriteByte(' ')
+			}
+		}
+	}
+	if multiline {
+		p.indent--
+		p.newline()
+	}
+	return nil
+}
+
+func (p *printer) array(array *ast.ArrayLiteral) error {
+	starts := make([]token.Position, len(array.Elements))
+	for i, elem := range array.Elements {
+		starts[i] = elem.Span().Start
+	}
+
+	p.out.WriteByte('[')
+	err := p.items(array.Token, starts, func(i int) error {
+		return p.expression(array.Elements[i])
+	})
+	if err != nil {
+		return err
+	}
+	p.out.WriteByte(']')
+	return nil
+}
+
+// hash 键值对按键在源码中的位置排列，保持原来的顺序
+func (p *printer) hash(hash *ast.HashLiteral) error {
+	keys := hash.Keys()
+	starts := make([]token.Position, len(keys))
+	for i, key := range keys {
+		starts[i] = key.Span().Start
+	}
+
+	p.out.WriteByte('{')
+	err := p.items(hash.Token, starts, func(i int) error {
+		if err := p.expression(keys[i]); err != nil {
+			return err
+		}
+		p.out.WriteString(": ")
+		return p.expression(hash.Pairs[keys[i]])
+	})
+	if err != nil {
+		return err
+	}
+	p.out.WriteByte('}')
+	return nil
+}
+
+// quote /**
+/*
+输出字符串字面量。原始字符串照原样放回反引号里；双引号字符串的值是解码过的，重新转义，
+不可见的控制字符用\u转义，其他字符原样输出
+ */
+func quote(tok token.Token) string {
+	if tok.StartChar == '`' {
+		return "`" + tok.Literal + "`"
+	}
+
+	var out strings.Builder
+	out.WriteByte('"')
+	s := tok.Literal
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		switch {
+		case r == '"':
+			out.WriteString(`\"`)
+		case r == '\\':
+			out.WriteString(`\\`)
+		case r == '\n':
+			out.WriteString(`\n`)
+		case r == '\t':
+			out.WriteString(`\t`)
+		case r == '\r':
+			out.WriteString(`\r`)
+		case r == 0:
+			out.WriteString(`\0`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&out, `\u%04x`, r)
+		default:
+			// 不是合法UTF-8的字节也原样输出
+			out.WriteString(s[:size])
+		}
+		s = s[size:]
+	}
+	out.WriteByte('"')
+	return out.String()
+}
@@ -0,0 +1,382 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"glue/ast"
+	"glue/lexer"
+	"glue/parser"
+	"glue/token"
+	"strings"
+)
+
+const indentString = "    "
+
+// Error 源码有语法错误时不做格式化，Errors是parser报告的全部错误
+type Error struct {
+	Errors []string
+}
+
+func (e *Error) Error() string {
+	return strings.Join(e.Errors, "\n")
+}
+
+// Source /**
+/*
+把glue源码格式化成统一的风格：四个空格缩进，运算符两边各一个空格，语句以分号结束，
+if/while/函数的左花括号跟在同一行。格式化的结果由AST重新生成，注释通过带注释的词法分析找回来，
+放回它原来所在的语句前后；表达式中间的注释没法精确放回，会挪到所在语句的前面。
+源码中语句之间的空行最多保留一行。同一段源码格式化多次结果不变
+ */
+func Source(src []byte) ([]byte, error) {
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if p.HasError() {
+		return nil, &Error{Errors: p.Errors()}
+	}
+
+	pr := newPrinter(string(src))
+	if err := pr.program(program); err != nil {
+		return nil, err
+	}
+	return pr.out.Bytes(), nil
+}
+
+// printer 按AST输出源码，tokens是包括注释在内的完整token序列，用来确定语句的范围和注释的位置
+type printer struct {
+	out *bytes.Buffer
+	indent int
+
+	tokens []token.Token
+	positions map[int]int // token的偏移到它在tokens中的下标
+	braces map[int]int // 左花括号的下标到与之匹配的右花括号的下标
+	used []bool // 已经输出过的注释
+
+	lastLine int // 上一个输出的语句或者注释在源码中结束的行，用来判断空行和行尾注释
+	first bool // 当前语句列表里还没有输出过任何东西
+}
+
+func newPrinter(src string) *printer {
+	p := &printer{
+		out: &bytes.Buffer{},
+		positions: map[int]int{},
+		braces: map[int]int{},
+	}
+
+	l := lexer.New(src)
+	l.KeepComments = true
+	var stack []int
+	for {
+		tok := l.NextToken()
+		i := len(p.tokens)
+		p.tokens = append(p.tokens, tok)
+		p.positions[tok.Offset] = i
+
+		switch tok.Type {
+		case token.LBRACE:
+			stack = append(stack, i)
+		case token.RBRACE:
+			if len(stack) > 0 {
+				p.braces[stack[len(stack)-1]] = i
+				stack = stack[:len(stack)-1]
+			}
+		}
+		if tok.Type == token.EOF {
+			break
+		}
+	}
+	p.used = make([]bool, len(p.tokens))
+	return p
+}
+
+func (p *printer) program(program *ast.Program) error {
+	p.first = true
+	if err := p.statements(program.Statements, -1, len(p.tokens)-1, true); err != nil {
+		return err
+	}
+	if p.out.Len() > 0 {
+		p.out.WriteByte('\n')
+	}
+	return nil
+}
+
+// index 找到AST中的token在完整token序列中的下标
+func (p *printer) index(pos token.Position) (int, error) {
+	i, ok := p.positions[pos.Offset]
+	if !ok {
+		return 0, fmt.Errorf("no token at %s", pos)
+	}
+	return i, nil
+}
+
+func (p *printer) isComment(i int) bool {
+	return p.tokens[i].Type == token.COMMENT
+}
+
+// endLine token在源码中结束的行，块注释和原始字符串可以跨行
+func (p *printer) endLine(i int) int {
+	tok := p.tokens[i]
+	if tok.Type == token.COMMENT || tok.Type == token.STRING && tok.StartChar == '`' {
+		return tok.LineNum + strings.Count(tok.Literal, "\n")
+	}
+	return tok.LineNum
+}
+
+func (p *printer) newline() {
+	p.out.WriteByte('\n')
+	p.out.WriteString(strings.Repeat(indentString, p.indent))
+}
+
+// separate 开始输出源码中line行上的一项：换行，源码中跟上一项之间有空行的保留一个空行。
+// 程序的第一项前面不换行，块的第一项前面不留空行
+func (p *printer) separate(line int, top bool) {
+	if p.first {
+		p.first = false
+		if !top {
+			p.newline()
+		}
+		return
+	}
+	if line > p.lastLine+1 {
+		p.out.WriteByte('\n')
+	}
+	p.newline()
+}
+
+// comments 输出下标在(from, to)之间还没有输出过的注释，跟上一项（或者块的左花括号）在同一行的接在行尾
+func (p *printer) comments(from, to int, top bool) {
+	for i := from + 1; i < to; i++ {
+		if !p.isComment(i) || p.used[i] {
+			continue
+		}
+		p.used[i] = true
+		tok := p.tokens[i]
+		if tok.LineNum == p.lastLine {
+			p.out.WriteByte(' ')
+		}else {
+			p.separate(tok.LineNum, top)
+		}
+		p.out.WriteString(strings.TrimRight(tok.Literal, " \t"))
+		p.lastLine = p.endLine(i)
+	}
+}
+
+// statements /**
+/*
+输出一个语句列表，open和close是包围它的花括号在token序列中的下标（程序的顶层是-1和EOF）。
+一条语句的范围从它的第一个token到下一条语句之前最后一个不是注释的token，
+两条语句之间的注释属于后一条语句，跟前一条语句结束在同一行的除外。
+先输出所有语句，嵌套的块会输出它们自己范围内的注释，剩下的语句内部的注释放到语句前面
+ */
+func (p *printer) statements(list []ast.Statement, open, close int, top bool) error {
+	starts := make([]int, len(list)+1)
+	for i, stmt := range list {
+		start, err := p.index(stmt.Span().Start)
+		if err != nil {
+			return err
+		}
+		starts[i] = start
+	}
+	starts[len(list)] = close
+
+	texts := make([]string, len(list))
+	out := p.out
+	for i, stmt := range list {
+		p.out = &bytes.Buffer{}
+		if err := p.statement(stmt); err != nil {
+			p.out = out
+			return err
+		}
+		texts[i] = p.out.String()
+	}
+	p.out = out
+
+	prevEnd := open
+	for i, stmt := range list {
+		start := starts[i]
+		end := starts[i+1] - 1
+		for end > start && p.isComment(end) {
+			end--
+		}
+
+		p.comments(prevEnd, start, top)
+		line := p.tokens[start].LineNum
+		for j := start + 1; j < end; j++ {
+			if p.isComment(j) && !p.used[j] {
+				p.used[j] = true
+				p.separate(line, top)
+				p.out.WriteString(strings.TrimRight(p.tokens[j].Literal, " \t"))
+				p.lastLine = line
+			}
+		}
+		p.separate(line, top)
+		p.out.WriteString(texts[i])
+
+		// 作为语句的if表达式后面紧跟着以(、[、-开头的语句时会被解析成调用、索引或者减法，这时不能省略分号
+		if exp, ok := stmt.(*ast.ExpressionStatement); ok {
+			if _, ok := exp.Expression.(*ast.IfExpression); ok && i+1 < len(list) && strings.ContainsAny(texts[i+1][:1], "([-") {
+				p.out.WriteByte(';')
+			}
+		}
+		p.lastLine = p.endLine(end)
+		prevEnd = end
+	}
+	p.comments(prevEnd, close, top)
+	return nil
+}
+
+func (p *printer) statement(stmt ast.Statement) error {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		p.out.WriteString("let " + s.Name.Value)
+		if s.Value != nil {
+			p.out.WriteString(" = ")
+			if err := p.expression(s.Value); err != nil {
+				return err
+			}
+		}
+		p.out.WriteByte(';')
+	case *ast.ReturnStatement:
+		p.out.WriteString("return ")
+		if err := p.expression(s.ReturnValue); err != nil {
+			return err
+		}
+		p.out.WriteByte(';')
+	case *ast.AssignStatement:
+		p.out.WriteString(s.Lhs.Value + " " + s.Operator + " ")
+		if err := p.expression(s.Rhs); err != nil {
+			return err
+		}
+		p.out.WriteByte(';')
+	case *ast.IndexAssignStatement:
+		if err := p.expression(s.Target); err != nil {
+			return err
+		}
+		p.out.WriteString(" " + s.Operator + " ")
+		if err := p.expression(s.Value); err != nil {
+			return err
+		}
+		p.out.WriteByte(';')
+	case *ast.ExpressionStatement:
+		if err := p.expression(s.Expression); err != nil {
+			return err
+		}
+		// 以块结束的if表达式单独作为语句时不加分号
+		if _, ok := s.Expression.(*ast.IfExpression); !ok {
+			p.out.WriteByte(';')
+		}
+	case *ast.WhileStatement:
+		p.out.WriteString("while (")
+		if err := p.expression(s.Condition); err != nil {
+			return err
+		}
+		p.out.WriteString(") ")
+		return p.block(s.Body)
+	case *ast.ForStatement:
+		p.out.WriteString("for (")
+		if err := p.forClause(s.Init); err != nil {
+			return err
+		}
+		p.out.WriteByte(';')
+		if s.Condition != nil {
+			p.out.WriteByte(' ')
+			if err := p.expression(s.Condition); err != nil {
+				return err
+			}
+		}
+		p.out.WriteByte(';')
+		if s.Post != nil {
+			p.out.WriteByte(' ')
+			if err := p.forClause(s.Post); err != nil {
+				return err
+			}
+		}
+		p.out.WriteString(") ")
+		return p.block(s.Body)
+	case *ast.ForInStatement:
+		p.out.WriteString("for (")
+		if s.Key != nil {
+			p.out.WriteString(s.Key.Value + ", ")
+		}
+		p.out.WriteString(s.Value.Value + " in ")
+		if err := p.expression(s.Iterable); err != nil {
+			return err
+		}
+		p.out.WriteString(") ")
+		return p.block(s.Body)
+	case *ast.BreakStatement:
+		p.out.WriteString("break;")
+	case *ast.ContinueStatement:
+		p.out.WriteString("continue;")
+	case *ast.FunctionDefinitionStatement:
+		p.out.WriteString("fn " + s.FnLiteral.Name.Value)
+		return p.function(s.FnLiteral)
+	default:
+		return fmt.Errorf("unsupported statement %T", stmt)
+	}
+	return nil
+}
+
+// forClause 输出for循环括号里的初始化或者更新语句，不带结尾的分号
+func (p *printer) forClause(stmt ast.Statement) error {
+	if stmt == nil {
+		return nil
+	}
+	out := p.out
+	p.out = &bytes.Buffer{}
+	err := p.statement(stmt)
+	text := strings.TrimSuffix(p.out.String(), ";")
+	p.out = out
+	if err != nil {
+		return err
+	}
+	p.out.WriteString(text)
+	return nil
+}
+
+// block 输出花括号包围的语句块，没有语句也没有注释的输出{}
+func (p *printer) block(block *ast.BlockStatement) error {
+	open, err := p.index(block.Token.Pos())
+	if err != nil {
+		return err
+	}
+	close, ok := p.braces[open]
+	if !ok || p.tokens[open].Type != token.LBRACE {
+		return fmt.Errorf("block at %d:%d is not enclosed in braces", block.Token.LineNum, block.Token.ColumnNum)
+	}
+
+	hasComments := false
+	for i := open + 1; i < close; i++ {
+		if p.isComment(i) && !p.used[i] {
+			hasComments = true
+		}
+	}
+	if len(block.Statements) == 0 && !hasComments {
+		p.out.WriteString("{}")
+		return nil
+	}
+
+	lastLine, first := p.lastLine, p.first
+	p.out.WriteByte('{')
+	p.indent++
+	p.lastLine, p.first = p.tokens[open].LineNum, true
+	err = p.statements(block.Statements, open, close, false)
+	p.indent--
+	p.lastLine, p.first = lastLine, first
+	if err != nil {
+		return err
+	}
+	p.newline()
+	p.out.WriteByte('}')
+	return nil
+}
+
+// function 输出函数的参数列表和函数体，fn关键字和函数名由调用方输出
+func (p *printer) function(fn *ast.FunctionLiteral) error {
+	params := make([]string, len(fn.Parameters))
+	for i, param := range fn.Parameters {
+		params[i] = param.Value
+	}
+	p.out.WriteString("(" + strings.Join(params, ", ") + ") ")
+	return p.block(fn.Body)
+}
@@ -0,0 +1,195 @@
+package format
+
+import (
+	"glue/lexer"
+	"glue/parser"
+	"glue/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+	}{
+		{"let x=1", "let x = 1;\n"},
+		{"let a;", "let a;\n"},
+		{"x = x+1\nreturn x", "x = x + 1;\nreturn x;\n"},
+		{"let f=fn(a,b){a*b}", "let f = fn(a, b) {\n    a * b;\n};\n"},
+		{"fn add(a,b){return a+b}", "fn add(a, b) {\n    return a + b;\n}\n"},
+		{"let f = fn(){}", "let f = fn() {};\n"},
+		{"if(x>1){1}else{2}", "if (x > 1) {\n    1;\n} else {\n    2;\n}\n"},
+		{"if(x>1){1}else if(x<0){2}else{3}", "if (x > 1) {\n    1;\n} else if (x < 0) {\n    2;\n} else {\n    3;\n}\n"},
+		{"let a=if(x){1}else if(y){}", "let a = if (x) {\n    1;\n} else if (y) {};\n"},
+		{"while(true){if(x){break}else{continue}}",
+			"while (true) {\n    if (x) {\n        break;\n    } else {\n        continue;\n    }\n}\n"},
+		{"let a=[1,2,3][0]", "let a = [1, 2, 3][0];\n"},
+		{"let h={\"b\":1,\"a\":2}", "let h = {\"b\": 1, \"a\": 2};\n"},
+		{"let h={\n\"b\":1,\"a\":[1,\n2]}", "let h = {\n    \"b\": 1,\n    \"a\": [\n        1,\n        2\n    ]\n};\n"},
+		{"let h={}", "let h = {};\n"},
+		{"let s=\"a\\\"b\\n\\t\\u0001é\"", "let s = \"a\\\"b\\n\\t\\u0001é\";\n"},
+		{"let s=`raw\\n\nline`", "let s = `raw\\n\nline`;\n"},
+		{"let f=1.5e3", "let f = 1.5e3;\n"},
+		{"print(f(1)(2)[0], -x, !(a==b))", "print(f(1)(2)[0], -x, !(a == b));\n"},
+		{"a[i+1]=h[\"k\"][0];(-x)[0]=1", "a[i + 1] = h[\"k\"][0];\n(-x)[0] = 1;\n"},
+		{"i+=1;s-=2*x\na[0]%=n;h[k]/=2;x*=3", "i += 1;\ns -= 2 * x;\na[0] %= n;\nh[k] /= 2;\nx *= 3;\n"},
+		{"for(let i=0;i<n;i+=1){f(i)}", "for (let i = 0; i < n; i += 1) {\n    f(i);\n}\n"},
+		{"for(;;){break}", "for (;;) {\n    break;\n}\n"},
+		{"for(;x;){}", "for (; x;) {}\n"},
+		{"for(k,v in h){}\nfor(c in \"ab\"){c}", "for (k, v in h) {}\nfor (c in \"ab\") {\n    c;\n}\n"},
+
+		// 只保留必要的括号
+		{"let a=((1+2))*3-(4-5)", "let a = (1 + 2) * 3 - (4 - 5);\n"},
+		{"let a=(a-b)-c", "let a = a - b - c;\n"},
+		{"let a=a||(b&&c)", "let a = a || b && c;\n"},
+		{"let a=(a||b)&&c", "let a = (a || b) && c;\n"},
+		{"let a=(-x)[0]+(f)(1)", "let a = (-x)[0] + f(1);\n"},
+		{"let a=fn(x){x}(1)", "let a = (fn(x) {\n    x;\n})(1);\n"},
+
+		// 作为语句的if后面跟着(开头的语句时要保留分号
+		{"if(a){1};(b+1)*2", "if (a) {\n    1;\n};\n(b + 1) * 2;\n"},
+		{"if(a){1}\nprint(a)", "if (a) {\n    1;\n}\nprint(a);\n"},
+
+		// 空行最多保留一行，块开头的空行去掉
+		{"let a=1;\n\n\n\nlet b=2;", "let a = 1;\n\nlet b = 2;\n"},
+		{"fn f(){\n\n  let a=1;\n\n  a\n}", "fn f() {\n    let a = 1;\n\n    a;\n}\n"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		out, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Errorf("input %q: %s", tt.input, err)
+			continue
+		}
+		if string(out) != tt.expected {
+			t.Errorf("input %q: wrong output.\nwant=%q\ngot= %q", tt.input, tt.expected, out)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// header
+/* block
+   comment */
+
+let x = 1 // trailing
+fn add(a,b){ // on brace
+  // leading
+  let s = a+b;   /* after s */
+
+  return s
+  // before close
+}
+let h = {"a": 1, // inside a hash
+  "b": 2};
+while (x) {
+  // only a comment
+}
+// last`
+	expected := `// header
+/* block
+   comment */
+
+let x = 1; // trailing
+fn add(a, b) { // on brace
+    // leading
+    let s = a + b; /* after s */
+
+    return s;
+    // before close
+}
+// inside a hash
+let h = {
+    "a": 1,
+    "b": 2
+};
+while (x) {
+    // only a comment
+}
+// last
+`
+
+	out, err := Source([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != expected {
+		t.Errorf("wrong output.\nwant=%s\ngot=%s", expected, out)
+	}
+}
+
+// TestIdempotent 格式化过的源码再格式化一次结果不变，语义不变，注释一个都不少
+func TestIdempotent(t *testing.T) {
+	files, _ := filepath.Glob("../examples/*.gl")
+	inputs := []string{
+		"let a = -(a + b) + !c == d || e && (f || g) /* mixed */;",
+		"let f = fn(x) { if (x) { x } else { fn(y) { y } } }(1)[2];",
+		"let k = {\n  \"one\": [1, /* one */ fn(x) { x }] // two\n};",
+		"if (a) { 1 } /* c */; [1][0];\nwhile (a < 10) { a = a + 1; } // loop",
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, string(data))
+	}
+
+	for _, input := range inputs {
+		out, err := Source([]byte(input))
+		if err != nil {
+			// examples里有故意写错的源文件
+			continue
+		}
+		again, err := Source(out)
+		if err != nil {
+			t.Errorf("formatted source does not parse: %s\n%s", err, out)
+			continue
+		}
+		if string(again) != string(out) {
+			t.Errorf("formatting is not idempotent.\nfirst=%s\nsecond=%s", out, again)
+		}
+		if program(t, input) != program(t, string(out)) {
+			t.Errorf("formatting changed the program.\ninput=%s\noutput=%s", input, out)
+		}
+		for _, comment := range comments(input) {
+			if !strings.Contains(string(out), comment) {
+				t.Errorf("comment %q is lost.\noutput=%s", comment, out)
+			}
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, err := Source([]byte("let x = ;\nlet = 2;"))
+	e, ok := err.(*Error)
+	if !ok || len(e.Errors) == 0 {
+		t.Fatalf("expected parse errors, got=%v", err)
+	}
+}
+
+// program AST的String虽然会丢掉函数体，但是能够体现出表达式的结构
+func program(t *testing.T, input string) string {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if p.HasError() {
+		t.Fatalf("parse %q: %v", input, p.Errors())
+	}
+	return program.String()
+}
+
+func comments(input string) []string {
+	l := lexer.New(input)
+	l.KeepComments = true
+	var comments []string
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Type == token.COMMENT {
+			comments = append(comments, tok.Literal)
+		}
+	}
+	return comments
+}
@@ -2,132 +2,217 @@ package lexer
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"glue/token"
 	"glue/tools/log"
 	"io"
 	"os"
-	"path/filepath"
-	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
-	input string
-	position int
-	readPosition int
-	ch byte
+	reader *bufio.Reader
+	closer io.Closer // NewFromFile打开的文件，读到结尾时关闭
+	eof bool // reader已经读完或者出错，不再读取
+
+	position int // 当前字符在源码中的字节偏移
+	ch rune
+	size int // 当前字符在源码中占的字节数，\r\n合并成一个'\n'，占两个字节；读到结尾以后是0
+	raw byte // 当前字符不是合法的UTF-8时，它的原始字节
 	char string // for debug, the string format of ch
 
-	CurrLineNum int // always point to next usable line
-	CurrColNum int
+	peeked []char // 往前看时已经从reader解码出来、还没有读到的字符
 
-	lines []string
-}
+	CurrLineNum int // 当前字符所在的行，从1开始
+	CurrColNum int // 当前字符所在的列，从1开始，按字符计数，一个汉字算一列
 
+	file string // 源文件名，记在每个token上
 
-func NewForREPL(input string) *Lexer {
-	l := &Lexer{input: input}
-	l.lines = append(l.lines, input)
+	// 为true时注释作为token.COMMENT返回给调用方，格式化、文档之类的工具需要；默认直接跳过，parser看不到注释
+	KeepComments bool
 
-	l.readChar() // init the cursor
+	errors []*Error // 词法错误，比如非法的转义序列，出错的token照常返回，由parser一起报告
+}
 
-	return l
+// Error 一个词法错误，位置是出错的字符序列开头的行号和列号（按字符计数）
+type Error struct {
+	LineNum int
+	ColNum int
+	Msg string
 }
 
-func New(input string) *Lexer {
-	l := &Lexer{input: input}
+func (e *Error) String() string {
+	return fmt.Sprintf("%s[%d:%d]", e.Msg, e.LineNum, e.ColNum)
+}
+
+// char 从reader解码出的一个字符
+type char struct {
+	ch rune
+	size int
+	raw byte
+}
+
+// NewReader /**
+/*
+从r中流式读取源码，按UTF-8解码成字符，标志符和字符串可以包含任意的unicode字符。
+\r\n和\n一样当作一个换行处理，开头的UTF-8 BOM跳过。New、NewForREPL和NewFromFile都基于它，行为完全一致
+ */
+func NewReader(r io.Reader) *Lexer {
+	l := &Lexer{reader: bufio.NewReader(r)}
+	l.CurrLineNum = 1
+	l.CurrColNum = 1
 
+	if c := l.decode(); c.ch == '\uFEFF' {
+		l.position = c.size
+	}else {
+		l.peeked = append(l.peeked, c)
+	}
 	l.readChar()
 
 	return l
 }
 
+// NewForREPL REPL输入的一段源码，跟New一样处理
+func NewForREPL(input string) *Lexer {
+	return New(input)
+}
+
+func New(input string) *Lexer {
+	return NewReader(strings.NewReader(input))
+}
+
+// NewFromFile /**
+/*
+边读文件边分析，token上记下文件名，行号、列号和偏移跟文件完全一致
+ */
 func NewFromFile(filename string) *Lexer {
-	l := &Lexer{}
-	err := l.loadSrc(filename)
-	//fmt.Println(l.lines)
+	f, err := os.Open(filename)
 	if err != nil {
 		log.ErrorF("load src file %s failed, error:%s", filename, err)
 		panic(err)
 	}
-	l.CurrLineNum = 0
-	l.CurrColNum = 0
 
-	l.readChar() // init read cursor
+	l := NewReader(f)
+	l.closer = f
+	l.file = filename
 
 	return l
 }
 
 /**
 该函数的正确性非常重要，是后面一切处理的基石。
+越过当前字符，读入下一个字符，同时维护行号、列号和偏移。读到结尾以后ch一直是0，位置停在源码末尾
  */
 func (l *Lexer) readChar() {
-	lineLen := len(l.input)
-	if l.readPosition >= len(l.input){
-		err := l.readLine()
-		if err != nil {
-			// 这种情况依然向后移动光标位置主要是因为现在readIdentifier()这个函数处理标志符时候依赖这个位置，不继续向前的话position跟l.position
-			// 会变成相等，input[position:l.position]就会取到空字符串，而不是最后的字符，最后一个字符会丢掉了。
-			// 暂且这样
-			l.ch =0
-		}else {
-			// 因为加载了新内容，所以重置读取光标位置
-			l.position = 0
-			l.readPosition = 0
-
-			l.ch = l.input[l.readPosition]
-			l.char = string(l.ch) // for debug
+	if l.ch == '\n' {
+		l.CurrLineNum++
+		l.CurrColNum = 1
+	}else if l.size > 0 {
+		l.CurrColNum++
+	}
+	l.position += l.size
 
-		}
+	var c char
+	if len(l.peeked) > 0 {
+		c = l.peeked[0]
+		l.peeked = l.peeked[1:]
 	}else {
-		l.ch = l.input[l.readPosition]
-		l.char = string(l.ch) // for debug
+		c = l.decode()
 	}
+	l.ch, l.size, l.raw = c.ch, c.size, c.raw
+	l.char = l.literal() // for debug
+}
 
-	l.position = l.readPosition
-	l.readPosition +=1
+// decode 从reader解码下一个字符，\r\n合并成'\n'。读到结尾或者出错时返回0，读取出错记一个词法错误
+func (l *Lexer) decode() char {
+	if l.eof {
+		return char{}
+	}
 
-	if l.position >= lineLen {
-		l.CurrColNum = lineLen
+	r, size, err := l.reader.ReadRune()
+	if err != nil {
+		if err != io.EOF {
+			l.addError(l.CurrLineNum, l.CurrColNum, "read source failed: %s", err)
+		}
+		l.eof = true
+		if l.closer != nil {
+			l.closer.Close()
+		}
+		return char{}
+	}
 
-	}else {
-		l.CurrColNum = l.position
+	switch {
+	case r == utf8.RuneError && size == 1:
+		// 不是合法的UTF-8，退回去重新按字节读，保留原始字节
+		l.reader.UnreadRune()
+		b, _ := l.reader.ReadByte()
+		return char{ch: r, size: 1, raw: b}
+	case r == '\r':
+		next, _, err := l.reader.ReadRune()
+		if err == nil && next == '\n' {
+			return char{ch: '\n', size: 2}
+		}
+		if err == nil {
+			l.reader.UnreadRune()
+		}
 	}
+	return char{ch: r, size: size}
+}
 
+// literal 当前字符的字面量，不是合法UTF-8的字节原样返回
+func (l *Lexer) literal() string {
+	if l.ch == utf8.RuneError && l.size == 1 {
+		return string([]byte{l.raw})
+	}
+	if l.ch == 0 {
+		return ""
+	}
+	return string(l.ch)
 }
 
-func (l *Lexer) peakChar() byte {
-	if l.readPosition >= len(l.input) {
-		err := l.readLine()
-		if err != nil {
-			return 0
-		}else {
-			// 新加载内容的开头，因为希望只让readChar修改读取位置，所以这里直接取索引0，而不是让l.readPosition = 0, 然后再l.input[l.readPosition]
-			// 如果不止一个地方会修改对字符流的读取位置，很容出错，读取合适的下一个字符是后面一切分析的基石，所以保证读取位置的正确非常重要！
-			return l.input[0]
-		}
+// writeChar 把当前字符写到out中，\r\n写成\n
+func (l *Lexer) writeChar(out *strings.Builder) {
+	out.WriteString(l.literal())
+}
 
-	}else {
-		return l.input[l.readPosition]
-	}
+// offset 当前字符在源码中的字节偏移，读到结尾以后停在源码的长度上
+func (l *Lexer) offset() int {
+	return l.position
 }
-/**
-只负责加载，不改变对字符流的读取位置，改变位置由readChar函数负责
-*/
-func (l *Lexer)readLine() error {
-	if len(l.lines) == 0 {
-		return fmt.Errorf("没有可加载内容") // 抛出一个报错信号，通知readChar整个多行文件的数据读取完毕，类似EOF的作用
-	}
-	if l.CurrLineNum >= len(l.lines) {
-		return fmt.Errorf("没有可加载内容")
-	}else {
-		l.input = l.lines[l.CurrLineNum]
+
+func (l *Lexer) Errors() []string {
+	errors := make([]string, 0, len(l.errors))
+	for _, err := range l.errors {
+		errors = append(errors, err.String())
 	}
+	return errors
+}
 
-	l.CurrLineNum++
+// LexErrors 带位置的词法错误，编辑器之类需要单独拿到位置的调用方使用
+func (l *Lexer) LexErrors() []*Error {
+	return l.errors
+}
 
-	return nil
+func (l *Lexer) addError(lineNum, colNum int, format string, args ...interface{}) {
+	l.errors = append(l.errors, &Error{LineNum: lineNum, ColNum: colNum, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (l *Lexer) peakChar() rune {
+	return l.peakCharAt(0)
+}
+
+// peakCharAt 查看当前字符之后第n+1个字符，不改变读取位置
+func (l *Lexer) peakCharAt(n int) rune {
+	for len(l.peeked) <= n {
+		c := l.decode()
+		if c.size == 0 {
+			return 0
+		}
+		l.peeked = append(l.peeked, c)
+	}
+	return l.peeked[n].ch
 }
 
 // NextToken /**
@@ -135,9 +220,40 @@ func (l *Lexer)readLine() error {
 该函数驱动词法解析器向前读取字符流，所以skipWhitespace会过滤掉所有出现的'空白',因为这里是整个字符流处理的最开始位置
  */
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+	for {
+		l.skipWhitespace()
+
+		// 记下token第一个字符的位置，所有token都用这个位置，不管它是怎么读出来的
+		lineNum, colNum, offset := l.CurrLineNum, l.CurrColNum, l.offset()
+
+		isComment := false
+		var tok token.Token
+		if l.ch == '/' && l.peakChar() == '/' {
+			tok = l.readLineComment()
+			isComment = true
+		}else if l.ch == '/' && l.peakChar() == '*' {
+			tok = l.readBlockComment()
+			// 没有闭合的块注释是ILLEGAL，不能跳过，要交给parser报错
+			isComment = tok.Type == token.COMMENT
+		}else {
+			tok = l.nextToken()
+		}
+		tok.LineNum = lineNum
+		tok.ColumnNum = colNum
+		tok.File = l.file
+		tok.Offset = offset
+		// 读完一个token后当前字符就是它后面的第一个字符
+		tok.End = token.Position{File: l.file, Line: l.CurrLineNum, Column: l.CurrColNum, Offset: l.offset()}
+
+		if isComment && !l.KeepComments {
+			continue
+		}
+		return tok
+	}
+}
 
-	l.skipWhitespace()
+func (l *Lexer) nextToken() token.Token {
+	var tok token.Token
 
 	switch l.ch {
 	case ';':
@@ -149,13 +265,16 @@ func (l *Lexer) NextToken() token.Token {
 	case ',':
 		tok = l.newToken(token.COMMA, l.ch)
 	case '+':
-		tok = l.newToken(token.PLUS, l.ch)
+		tok = l.newOperator(token.PLUS, token.PLUS_ASSIGN)
 	case '-':
-		tok = l.newToken(token.MINUS, l.ch)
+		tok = l.newOperator(token.MINUS, token.MINUS_ASSIGN)
 	case '/':
-		tok = l.newToken(token.SLASH, l.ch)
+		// 注释已经在NextToken里处理了，这里的'/'后面不会是'/'或者'*'
+		tok = l.newOperator(token.SLASH, token.SLASH_ASSIGN)
 	case '*':
-		tok = l.newToken(token.ASTERISK, l.ch)
+		tok = l.newOperator(token.ASTERISK, token.ASTERISK_ASSIGN)
+	case '%':
+		tok = l.newOperator(token.PERCENT, token.PERCENT_ASSIGN)
 	case '=':
 		if l.peakChar() == '=' {
 			ch := l.ch
@@ -173,9 +292,38 @@ func (l *Lexer) NextToken() token.Token {
 			tok = l.newToken(token.BANG, l.ch)
 		}
 	case '<':
-		tok = l.newToken(token.LT, l.ch)
+		if l.peakChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.LT_EQ, Literal: string(ch)+string(l.ch)}
+		}else {
+			tok = l.newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = l.newToken(token.GT, l.ch)
+		if l.peakChar() == '=' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.GT_EQ, Literal: string(ch)+string(l.ch)}
+		}else {
+			tok = l.newToken(token.GT, l.ch)
+		}
+	case '&':
+		// 只有&&，单独的&是非法字符
+		if l.peakChar() == '&' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.AND, Literal: string(ch)+string(l.ch)}
+		}else {
+			tok = l.newToken(token.ILLEGAL, l.ch)
+		}
+	case '|':
+		if l.peakChar() == '|' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.OR, Literal: string(ch)+string(l.ch)}
+		}else {
+			tok = l.newToken(token.ILLEGAL, l.ch)
+		}
 	case '{':
 		tok = l.newToken(token.LBRACE, l.ch)
 	case '}':
@@ -185,6 +333,10 @@ func (l *Lexer) NextToken() token.Token {
 		tok.StartChar = '"'
 		tok.Type = token.STRING
 		tok.Literal = l.readString2(&tok)
+	case '`':
+		tok.StartChar = '`'
+		tok.Type = token.STRING
+		tok.Literal = l.readRawString(&tok)
 	case '[':
 		tok = l.newToken(token.LBRACKET, l.ch)
 	case ']':
@@ -202,11 +354,11 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.LookupIdent(tok.Literal)
 			return tok
 		}else if isDigit(l.ch) {
-			tok.Literal = l.readNumber()
-			tok.Type = token.INT
+			tok.Literal, tok.Type = l.readNumber()
 			return tok
 		}else {
-			tok = l.newToken(token.ILLEGAL, l.ch)
+			// 不认识的字符，不是合法UTF-8的字节原样放在字面量里
+			tok = token.Token{Type: token.ILLEGAL, Literal: l.literal()}
 		}
 	}
 
@@ -214,59 +366,220 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
+// readLineComment /**
+/*
+读取"//"开始的行注释，到行尾为止，不包括换行符，换行符留给skipWhitespace处理
+ */
+func (l *Lexer) readLineComment() token.Token {
+	var out strings.Builder
+	for l.ch != '\n' && l.ch != 0 {
+		l.writeChar(&out)
+		l.readChar()
+	}
+	return token.Token{Type: token.COMMENT, Literal: strings.TrimRight(out.String(), "\r")}
+}
+
+// readBlockComment /**
+/*
+读取"/*"开始的块注释，直到"*\/"为止，字面量包括首尾的定界符。
+块注释可以跨行，逐个字符判断结尾，只记住前一个字符。读到输入结束还没有闭合的返回ILLEGAL
+ */
+func (l *Lexer) readBlockComment() token.Token {
+	var out strings.Builder
+	l.writeChar(&out) // '/'
+	l.readChar()
+	l.writeChar(&out) // '*'
+
+	var prev rune // "/*/"不算闭合，所以开头的'*'不参与判断
+	for {
+		l.readChar()
+		if l.ch == 0 {
+			return token.Token{Type: token.ILLEGAL, Literal: out.String()}
+		}
+		l.writeChar(&out)
+		if prev == '*' && l.ch == '/' {
+			l.readChar()
+			return token.Token{Type: token.COMMENT, Literal: out.String()}
+		}
+		prev = l.ch
+	}
+}
+
+// readString2 /**
+/*
+读取双引号字符串，同时解码转义序列，结束时l.ch停在闭合的'"'上，由nextToken统一吃掉。
+字符串里的非ASCII字符原样保留，不是合法UTF-8的字节也原样保留。没有闭合的字符串是ILLEGAL
+ */
 func (l *Lexer) readString2(tok *token.Token) string {
-	var out bytes.Buffer
+	var out strings.Builder
 	for {
-		ch := l.peakChar()
-		if ch == '"'{
-			break
-		}else if ch == 0 {
+		l.readChar()
+		switch l.ch {
+		case '"':
+			return out.String()
+		case 0:
 			tok.Type = token.ILLEGAL
-			break
+			return out.String()
+		case '\\':
+			l.readEscape(&out)
+		default:
+			l.writeChar(&out)
 		}
-		l.readChar()
+	}
+}
 
-		out.Write([]byte{l.ch})
+// readEscape /**
+/*
+当前字符是'\\'，解码它后面的转义序列写到out中。支持\n \t \r \\ \" \0 和 \uXXXX、\UXXXXXXXX两种unicode转义，
+不认识的转义序列记一个词法错误，原样保留
+ */
+func (l *Lexer) readEscape(out *strings.Builder) {
+	lineNum, colNum := l.CurrLineNum, l.CurrColNum
+	l.readChar()
 
+	switch l.ch {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '\\':
+		out.WriteByte('\\')
+	case '"':
+		out.WriteByte('"')
+	case '0':
+		out.WriteByte(0)
+	case 'u', 'U':
+		size := 4
+		if l.ch == 'U' {
+			size = 8
+		}
+		seq := `\` + l.literal()
+		var r rune
+		for i := 0; i < size; i++ {
+			// 不满位数时，遇到的字符不是十六进制数字就不再往前读，留给外层处理（比如闭合的'"'）
+			if !isHexDigit(l.peakChar()) {
+				l.addError(lineNum, colNum, "invalid unicode escape sequence %q", seq)
+				out.WriteString(seq)
+				return
+			}
+			l.readChar()
+			seq += l.literal()
+			r = r<<4 | rune(hexValue(l.ch))
+		}
+		if !utf8.ValidRune(r) {
+			l.addError(lineNum, colNum, "invalid unicode escape sequence %q", seq)
+			out.WriteString(seq)
+			return
+		}
+		out.WriteRune(r)
+	case 0:
+		// 输入结束，交给readString2按没有闭合的字符串处理
+		out.WriteByte('\\')
+		return
+	default:
+		l.addError(lineNum, colNum, "invalid escape sequence %q", `\`+l.literal())
+		out.WriteByte('\\')
+		l.writeChar(out)
 	}
-	out.Write([]byte{})
-	l.readChar() //吃掉第二个'"'
-	return out.String()
 }
 
-func (l *Lexer) readString() string {
-	position := l.position + 1
+// readRawString /**
+/*
+读取反引号包围的原始字符串，不处理转义，可以跨行，结束时l.ch停在闭合的'`'上。
+字符串内容包括中间的换行符，\r\n读成\n
+ */
+func (l *Lexer) readRawString(tok *token.Token) string {
+	var out strings.Builder
 	for {
 		l.readChar()
-		if l.ch == '"' {
-			break
+		switch l.ch {
+		case '`':
+			return out.String()
+		case 0:
+			tok.Type = token.ILLEGAL
+			return out.String()
+		case '\r':
+			// 统一成\n，不让源文件的换行风格影响字符串的值
+		default:
+			l.writeChar(&out)
 		}
 	}
-	return l.input[position:l.position]
 }
 
+// readIdentifier 标志符由字母、数字和下划线组成，字母和数字可以是任意的unicode字符，比如汉字
 func (l *Lexer) readIdentifier() string {
-	position := l.position
-	for isLetter(l.ch) || isDigit(l.ch) {
+	var out strings.Builder
+	for isLetter(l.ch) || unicode.IsDigit(l.ch) {
+		l.writeChar(&out)
 		l.readChar()
 	}
-	return l.input[position:l.position]
+	return out.String()
 }
 
-func isLetter(ch byte) bool {
-	return 'a'<=ch && ch <='z'||'A'<=ch && ch<='Z'||ch =='_'
+func isLetter(ch rune) bool {
+	return 'a'<=ch && ch <='z'||'A'<=ch && ch<='Z'||ch =='_' || ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-func isDigit(ch byte) bool {
+// isDigit 数字字面量只由ASCII数字组成
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func (l *Lexer) readNumber() string {
-	position := l.position
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+func hexValue(ch rune) int {
+	switch {
+	case isDigit(ch):
+		return int(ch - '0')
+	case 'a' <= ch && ch <= 'f':
+		return int(ch - 'a' + 10)
+	default:
+		return int(ch - 'A' + 10)
+	}
+}
+
+// readNumber /**
+/*
+读取整数或者浮点数。浮点数有小数和指数两种写法，可以组合：1.5、1e3、2.5E-3，小数点前后都必须有数字，
+"1."和".5"都不算浮点数
+ */
+func (l *Lexer) readNumber() (string, token.TokenType) {
+	var out strings.Builder
+	tokenType := token.TokenType(token.INT)
+
+	l.readDigits(&out)
+	if l.ch == '.' && isDigit(l.peakChar()) {
+		tokenType = token.FLOAT
+		l.writeChar(&out) // '.'
+		l.readChar()
+		l.readDigits(&out)
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peakChar()
+		if isDigit(next) || (next == '+' || next == '-') && isDigit(l.peakCharAt(1)) {
+			tokenType = token.FLOAT
+			l.writeChar(&out) // 'e'
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.writeChar(&out)
+				l.readChar()
+			}
+			l.readDigits(&out)
+		}
+	}
+
+	return out.String(), tokenType
+}
+
+func (l *Lexer) readDigits(out *strings.Builder) {
 	for isDigit(l.ch){
+		l.writeChar(out)
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
 func (l *Lexer) skipWhitespace() {
@@ -275,7 +588,7 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-func (l *Lexer) newToken(tokenType token.TokenType, char byte) token.Token {
+func (l *Lexer) newToken(tokenType token.TokenType, char rune) token.Token {
 	return token.Token{
 		Type: tokenType,
 		Literal: string(char),
@@ -285,60 +598,19 @@ func (l *Lexer) newToken(tokenType token.TokenType, char byte) token.Token {
 	}
 }
 
-type TokenError struct {
-	startCh byte
-
-}
-
-
-func (l *Lexer) loadSrc(file string) error {
-	fmt.Println("file:", file)
-	var err error
-	file, err = filepath.Abs(file)
-	if err != nil {
-		panic(err)
-	}
-	f, err := os.Open(file)
-	if err != nil {
-		return err
+// newOperator 算术运算符后面紧跟着'='的是复合赋值运算符，比如+=
+func (l *Lexer) newOperator(tokenType, assignType token.TokenType) token.Token {
+	if l.peakChar() == '=' {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type: assignType, Literal: string(ch)+string(l.ch)}
 	}
-	defer f.Close()
-	r := bufio.NewReader(f)
-	for {
-		line, err := r.ReadString('\n')
-
-		if err != nil {
-			if err == io.EOF {
-				if line != "" { // 跳过空行
-					l.lines = append(l.lines, line) // 最后一行
-				}
-
-				return nil
-			}else {
-				fmt.Printf("error reading file %s", err)
-				return err
-			}
+	return l.newToken(tokenType, l.ch)
+}
 
-		}
-		//fmt.Println("line:",line)
-		if !isEmptyLine(line) { // 跳过空行
-			l.lines = append(l.lines, line)
-		}
+type TokenError struct {
+	startCh byte
 
-	}
-	//fmt.Println(l.lines)
-	return nil
 }
 
-func isEmptyLine(line string) bool {
-	if line == "" || line == "\n" {
-		return true
-	}
-	reg, err := regexp.Compile(`[\s\t]*\r\n`)
-	if err != nil {
-		panic(err)
-	}
-	matched := reg.MatchString(line)
 
-	return matched
-}
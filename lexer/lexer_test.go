@@ -1,8 +1,13 @@
 package lexer
 
 import (
+	"errors"
 	"glue/token"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestNextToken(t *testing.T) {
@@ -14,7 +19,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -140,3 +145,476 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestComments(t *testing.T) {
+	input := `let a = 1; // line comment
+/* block
+   comment */ let b = a / 2;
+/*/ still a comment */ b //
+`
+
+	tests := []struct{
+		expectedType token.TokenType
+		expectedLiteral string
+		expectedLine int
+		expectedColumn int
+	}{
+		{token.LET, "let", 1, 1},
+		{token.IDENT, "a", 1, 5},
+		{token.ASSIGN, "=", 1, 7},
+		{token.INT, "1", 1, 9},
+		{token.SEMICOLON, ";", 1, 10},
+		{token.COMMENT, "// line comment", 1, 12},
+		{token.COMMENT, "/* block\n   comment */", 2, 1},
+		{token.LET, "let", 3, 15},
+		{token.IDENT, "b", 3, 19},
+		{token.ASSIGN, "=", 3, 21},
+		{token.IDENT, "a", 3, 23},
+		{token.SLASH, "/", 3, 25},
+		{token.INT, "2", 3, 27},
+		{token.SEMICOLON, ";", 3, 28},
+		{token.COMMENT, "/*/ still a comment */", 4, 1},
+		{token.IDENT, "b", 4, 24},
+		{token.COMMENT, "//", 4, 26},
+		{token.EOF, "", 0, 0}, // EOF的位置不检查
+	}
+
+	l := New(input)
+	l.KeepComments = true
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tt.expectedLine > 0 && (tok.LineNum != tt.expectedLine || tok.ColumnNum != tt.expectedColumn) {
+			t.Errorf("test[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.LineNum, tok.ColumnNum)
+		}
+	}
+
+	// 默认跳过注释
+	l = New(input)
+	for i, tt := range tests {
+		if tt.expectedType == token.COMMENT {
+			continue
+		}
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+
+	l = New("1 /* not closed")
+	l.NextToken()
+	if tok := l.NextToken(); tok.Type != token.ILLEGAL {
+		t.Errorf("unterminated block comment should be ILLEGAL, got=%q %q", tok.Type, tok.Literal)
+	}
+}
+
+func TestCommentsFromFile(t *testing.T) {
+	// 从文件读入的块注释跨越多行，中间有空行
+	src := "let a = 1; /* first\n\n second // not a line comment\n*/ let b = 2; // tail\nb"
+	file := filepath.Join(t.TempDir(), "comments.gl")
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct{
+		expectedType token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "a"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.LET, "let"},
+		{token.IDENT, "b"},
+		{token.ASSIGN, "="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "b"},
+		{token.EOF, ""},
+	}
+
+	l := NewFromFile(file)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `5 3.14 0.5 1e3 2.5E-3 6e+2 7.x 8e 9e+`
+
+	tests := []struct{
+		expectedType token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "0.5"},
+		{token.FLOAT, "1e3"},
+		{token.FLOAT, "2.5E-3"},
+		{token.FLOAT, "6e+2"},
+		{token.INT, "7"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "x"},
+		{token.INT, "8"},
+		{token.IDENT, "e"},
+		{token.INT, "9"},
+		{token.IDENT, "e"},
+		{token.PLUS, "+"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestComparisonAndLogicalOperators(t *testing.T) {
+	input := `a <= b >= c < d > e && f || g % h & |`
+
+	tests := []struct{
+		expectedType token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.LT, "<"},
+		{token.IDENT, "d"},
+		{token.GT, ">"},
+		{token.IDENT, "e"},
+		{token.AND, "&&"},
+		{token.IDENT, "f"},
+		{token.OR, "||"},
+		{token.IDENT, "g"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "h"},
+		{token.ILLEGAL, "&"},
+		{token.ILLEGAL, "|"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestCompoundAssignOperators(t *testing.T) {
+	input := `a += 1; b -= c *= d /= e %= f; g + = h /=/* c */i // j`
+
+	tests := []struct{
+		expectedType token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "b"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.IDENT, "c"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.IDENT, "d"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.IDENT, "e"},
+		{token.PERCENT_ASSIGN, "%="},
+		{token.IDENT, "f"},
+		{token.SEMICOLON, ";"},
+		// 中间有空白的不是复合赋值
+		{token.IDENT, "g"},
+		{token.PLUS, "+"},
+		{token.ASSIGN, "="},
+		{token.IDENT, "h"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.IDENT, "i"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct{
+		input string
+		expectedType token.TokenType
+		expectedLiteral string
+		expectedErrors int
+	}{
+		{`"a\nb"`, token.STRING, "a\nb", 0},
+		{`"tab\there"`, token.STRING, "tab\there", 0},
+		{`"say \"hi\""`, token.STRING, `say "hi"`, 0},
+		{`"back\\slash"`, token.STRING, `back\slash`, 0},
+		{`"\r\0"`, token.STRING, "\r\x00", 0},
+		{`"\u4f60\u597D"`, token.STRING, "你好", 0},
+		{`"\U0001F600"`, token.STRING, "\U0001F600", 0},
+		{`"bad \q"`, token.STRING, `bad \q`, 1},
+		{`"short \u12"`, token.STRING, `short \u12`, 1},
+		{`"surrogate \uD800"`, token.STRING, `surrogate \uD800`, 1},
+		{`"unterminated \"`, token.ILLEGAL, `unterminated "`, 0},
+		{"`raw \\n \"string\"`", token.STRING, `raw \n "string"`, 0},
+		{"`multi\r\nline`", token.STRING, "multi\nline", 0},
+		{"`unterminated", token.ILLEGAL, "unterminated", 0},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Errorf("test[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if len(l.Errors()) != tt.expectedErrors {
+			t.Errorf("test[%d] - wrong number of errors. expected=%d, got=%d %q",
+				i, tt.expectedErrors, len(l.Errors()), l.Errors())
+		}
+		if tok.Type == token.STRING {
+			if next := l.NextToken(); next.Type != token.EOF {
+				t.Errorf("test[%d] - expected EOF after string, got=%q %q", i, next.Type, next.Literal)
+			}
+		}
+	}
+}
+
+func TestRawStringFromFile(t *testing.T) {
+	src := "let s = `first\n  second \\n`;\nlet t = \"x\";\n"
+	file := filepath.Join(t.TempDir(), "raw.gl")
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct{
+		expectedType token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "s"},
+		{token.ASSIGN, "="},
+		{token.STRING, "first\n  second \\n"},
+		{token.SEMICOLON, ";"},
+		{token.LET, "let"},
+		{token.IDENT, "t"},
+		{token.ASSIGN, "="},
+		{token.STRING, "x"},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+
+	l := NewFromFile(file)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let s = \"a\\tb\" == x != `r\nr`;\n\n  if (abc >= 10.5) {}"
+
+	tests := []struct{
+		expectedLiteral string
+		expectedStart token.Position
+		expectedEnd token.Position
+	}{
+		{"let", token.Position{Line: 1, Column: 1, Offset: 0}, token.Position{Line: 1, Column: 4, Offset: 3}},
+		{"s", token.Position{Line: 1, Column: 5, Offset: 4}, token.Position{Line: 1, Column: 6, Offset: 5}},
+		{"=", token.Position{Line: 1, Column: 7, Offset: 6}, token.Position{Line: 1, Column: 8, Offset: 7}},
+		// 字面量是解码过的，结束位置按源码算
+		{"a\tb", token.Position{Line: 1, Column: 9, Offset: 8}, token.Position{Line: 1, Column: 15, Offset: 14}},
+		{"==", token.Position{Line: 1, Column: 16, Offset: 15}, token.Position{Line: 1, Column: 18, Offset: 17}},
+		{"x", token.Position{Line: 1, Column: 19, Offset: 18}, token.Position{Line: 1, Column: 20, Offset: 19}},
+		{"!=", token.Position{Line: 1, Column: 21, Offset: 20}, token.Position{Line: 1, Column: 23, Offset: 22}},
+		{"r\nr", token.Position{Line: 1, Column: 24, Offset: 23}, token.Position{Line: 2, Column: 3, Offset: 28}},
+		{";", token.Position{Line: 2, Column: 3, Offset: 28}, token.Position{Line: 2, Column: 4, Offset: 29}},
+		{"if", token.Position{Line: 4, Column: 3, Offset: 33}, token.Position{Line: 4, Column: 5, Offset: 35}},
+		{"(", token.Position{Line: 4, Column: 6, Offset: 36}, token.Position{Line: 4, Column: 7, Offset: 37}},
+		{"abc", token.Position{Line: 4, Column: 7, Offset: 37}, token.Position{Line: 4, Column: 10, Offset: 40}},
+		{">=", token.Position{Line: 4, Column: 11, Offset: 41}, token.Position{Line: 4, Column: 13, Offset: 43}},
+		{"10.5", token.Position{Line: 4, Column: 14, Offset: 44}, token.Position{Line: 4, Column: 18, Offset: 48}},
+		{")", token.Position{Line: 4, Column: 18, Offset: 48}, token.Position{Line: 4, Column: 19, Offset: 49}},
+		{"{", token.Position{Line: 4, Column: 20, Offset: 50}, token.Position{Line: 4, Column: 21, Offset: 51}},
+		{"}", token.Position{Line: 4, Column: 21, Offset: 51}, token.Position{Line: 4, Column: 22, Offset: 52}},
+		{"", token.Position{Line: 4, Column: 22, Offset: 52}, token.Position{Line: 4, Column: 22, Offset: 52}},
+	}
+
+	file := filepath.Join(t.TempDir(), "positions.gl")
+	if err := os.WriteFile(file, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fromFile := range []bool{false, true} {
+		l := New(input)
+		if fromFile {
+			l = NewFromFile(file)
+		}
+		for i, tt := range tests {
+			tok := l.NextToken()
+			start, end := tt.expectedStart, tt.expectedEnd
+			if fromFile {
+				start.File, end.File = file, file
+			}
+			if tok.Literal != tt.expectedLiteral {
+				t.Fatalf("test[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+			}
+			if tok.Pos() != start || tok.End != end {
+				t.Errorf("test[%d] %q - position wrong. expected=%+v-%+v, got=%+v-%+v",
+					i, tok.Literal, start, end, tok.Pos(), tok.End)
+			}
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let 名字 = \"你好，世界\"; 名字2+_变量\n打印(`多\r\n行`) \u00a7 \xff"
+
+	tests := []struct{
+		expectedType token.TokenType
+		expectedLiteral string
+		expectedLine int
+		expectedColumn int
+	}{
+		{token.LET, "let", 1, 1},
+		{token.IDENT, "名字", 1, 5},
+		{token.ASSIGN, "=", 1, 8},
+		{token.STRING, "你好，世界", 1, 10},
+		{token.SEMICOLON, ";", 1, 17},
+		{token.IDENT, "名字2", 1, 19},
+		{token.PLUS, "+", 1, 22},
+		{token.IDENT, "_变量", 1, 23},
+		{token.IDENT, "打印", 2, 1},
+		{token.LPAREN, "(", 2, 3},
+		{token.STRING, "多\n行", 2, 4},
+		{token.RPAREN, ")", 3, 3},
+		// 不是字母的unicode字符和非法的UTF-8字节都是ILLEGAL，字面量是源码中的原样
+		{token.ILLEGAL, "\u00a7", 3, 5},
+		{token.ILLEGAL, "\xff", 3, 7},
+		{token.EOF, "", 3, 8},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.LineNum != tt.expectedLine || tok.ColumnNum != tt.expectedColumn {
+			t.Errorf("test[%d] %q - position wrong. expected=%d:%d, got=%d:%d",
+				i, tok.Literal, tt.expectedLine, tt.expectedColumn, tok.LineNum, tok.ColumnNum)
+		}
+	}
+	if tok := l.NextToken(); tok.Offset != len(input) {
+		t.Errorf("wrong offset at the end. expected=%d, got=%d", len(input), tok.Offset)
+	}
+}
+
+// TestLineEndings \r\n、\n和开头的BOM不影响token和行号，New、NewForREPL、NewFromFile和NewReader的结果完全一样
+func TestLineEndings(t *testing.T) {
+	lf := "let 甲 = 1; // 注释\n/* 块\n注释 */\nlet s = \"a\nb\" + `c\nd`;\n"
+	crlf := "\uFEFF" + strings.ReplaceAll(lf, "\n", "\r\n")
+
+	file := filepath.Join(t.TempDir(), "crlf.gl")
+	if err := os.WriteFile(file, []byte(crlf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lexers := map[string]*Lexer{
+		"New": New(crlf),
+		"NewForREPL": NewForREPL(crlf),
+		"NewFromFile": NewFromFile(file),
+		// 每次只读一个字节，字符会被拆开
+		"NewReader": NewReader(iotest.OneByteReader(strings.NewReader(crlf))),
+	}
+
+	for name, l := range lexers {
+		l.KeepComments = true
+		expected := New(lf)
+		expected.KeepComments = true
+		for {
+			want, got := expected.NextToken(), l.NextToken()
+			if got.Type != want.Type || got.Literal != want.Literal || got.LineNum != want.LineNum || got.ColumnNum != want.ColumnNum {
+				t.Fatalf("%s: token wrong. expected=%q %q at %d:%d, got=%q %q at %d:%d", name,
+					want.Type, want.Literal, want.LineNum, want.ColumnNum, got.Type, got.Literal, got.LineNum, got.ColumnNum)
+			}
+			if want.Type == token.EOF {
+				break
+			}
+		}
+	}
+}
+
+func TestReaderError(t *testing.T) {
+	l := NewReader(iotest.TimeoutReader(strings.NewReader("let a = 1;")))
+	var tok token.Token
+	for tok = l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+	}
+	if len(l.Errors()) != 1 || !strings.Contains(l.Errors()[0], iotest.ErrTimeout.Error()) {
+		t.Errorf("expected a read error, got=%q", l.Errors())
+	}
+
+	l = NewReader(iotest.ErrReader(errors.New("broken")))
+	if tok = l.NextToken(); tok.Type != token.EOF || len(l.Errors()) != 1 {
+		t.Errorf("expected EOF and one error, got=%q %q", tok.Type, l.Errors())
+	}
+}
+
+func TestForKeywords(t *testing.T) {
+	input := `for (k, v in h) {} format index`
+
+	tests := []struct{
+		expectedType token.TokenType
+		expectedLiteral string
+	}{
+		{token.FOR, "for"},
+		{token.LPAREN, "("},
+		{token.IDENT, "k"},
+		{token.COMMA, ","},
+		{token.IDENT, "v"},
+		{token.IN, "in"},
+		{token.IDENT, "h"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.RBRACE, "}"},
+		// 只是以关键字开头的名字
+		{token.IDENT, "format"},
+		{token.IDENT, "index"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - token wrong. expected=%q %q, got=%q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
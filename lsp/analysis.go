@@ -0,0 +1,421 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"glue/ast"
+	"glue/compiler"
+	"glue/lexer"
+	"glue/object"
+	"glue/parser"
+	"glue/token"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// definition 一个名字的定义处：let语句、函数定义语句、形参
+type definition struct {
+	name string
+	token token.Token // 定义处的标识符
+	parameter bool
+	function string // 绑定的值是函数字面量时是它的签名，比如fn(a, b)
+}
+
+// reference 源码中出现的一个标识符，包括定义处的标识符本身
+type reference struct {
+	token token.Token
+	symbol compiler.Symbol
+	def *definition // 内置函数和未定义的名字为nil
+}
+
+// scope 整个文件或者一个函数体对应的作用域，范围用LSP的坐标表示
+type scope struct {
+	rng Range
+	defs []*definition // 按出现的先后顺序
+	outer *scope
+}
+
+// analysis 一个文档的分析结果，每次文档内容变化时重新生成
+type analysis struct {
+	refs []*reference
+	scopes []*scope // scopes[0]是全局作用域
+	symbols []DocumentSymbol
+	diagnostics []Diagnostic
+	builtins *object.BuiltinRegistry
+}
+
+// analyzer /**
+/*
+按编译器的方式遍历AST：全局符号表先定义内置函数，进入函数字面量时新建嵌套的符号表，依次定义函数自身的名字和形参，
+let语句先定义名字再处理右边的表达式。每个标识符都用compiler.SymbolTable解析，得到的作用域（全局、局部、自由、内置）
+跟编译器生成指令时看到的一样；符号表不记录定义的位置，所以另外按符号表记下每个名字的定义处
+ */
+type analyzer struct {
+	*analysis
+	table *compiler.SymbolTable
+	scope *scope
+	defs map[*compiler.SymbolTable]map[string]*definition
+	end Position // 文档末尾
+}
+
+func analyze(text string) *analysis {
+	a := &analyzer{
+		analysis: &analysis{builtins: object.DefaultBuiltins()},
+		defs: map[*compiler.SymbolTable]map[string]*definition{},
+	}
+	a.end = documentEnd(text)
+
+	p := parser.New(lexer.New(text))
+	program := p.ParseProgram()
+	for _, err := range p.ParseErrors() {
+		a.diagnostics = append(a.diagnostics, parseErrorDiagnostic(err))
+	}
+
+	a.table = compiler.NewSymbolTable()
+	a.table.DefineBuiltins(a.builtins)
+	a.scope = &scope{rng: Range{End: a.end}}
+	a.scopes = append(a.scopes, a.scope)
+	for _, stmt := range program.Statements {
+		a.symbols = append(a.symbols, a.statement(stmt)...)
+	}
+
+	// 有语法错误时AST不完整，编译出来的错误没有意义，只报告语法错误
+	if len(p.Errors()) == 0 {
+		c := compiler.NewWithBuiltins(a.builtins)
+		var diagnostics compiler.Diagnostics
+		if err := c.Compile(program); err != nil && !errors.As(err, &diagnostics) {
+			a.diagnostics = append(a.diagnostics, Diagnostic{Severity: SeverityError, Source: "glue", Message: err.Error()})
+		}
+		for _, d := range c.Diagnostics() {
+			a.diagnostics = append(a.diagnostics, compilerDiagnostic(d))
+		}
+	}
+	return a.analysis
+}
+
+// documentEnd 文档末尾的位置，列号跟lexer一样按字符计数
+func documentEnd(text string) Position {
+	line := strings.Count(text, "\n")
+	return Position{Line: line, Character: utf8.RuneCountInString(text[strings.LastIndex(text, "\n")+1:])}
+}
+
+// isNil 解析出错时AST里可能有值为nil的指针
+func isNil(node ast.Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// statement 处理一条语句，返回其中的文档符号（let语句和函数定义语句）
+func (a *analyzer) statement(stmt ast.Statement) []DocumentSymbol {
+	if isNil(stmt) {
+		return nil
+	}
+
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		if stmt.Name == nil {
+			return nil
+		}
+		def := a.define(stmt.Name.Token, false)
+		symbol := DocumentSymbol{
+			Name: stmt.Name.Value,
+			Kind: SymbolKindVariable,
+			Range: Range{Start: tokenPosition(stmt.Token), End: tokenRange(stmt.Name.Token).End},
+			SelectionRange: tokenRange(stmt.Name.Token),
+		}
+		if fn, ok := stmt.Value.(*ast.FunctionLiteral); ok && !isNil(fn) {
+			def.function = signature(fn)
+			symbol.Kind = SymbolKindFunction
+			symbol.Detail = def.function
+			symbol.Children = a.function(fn)
+			symbol.Range.End = a.bodyEnd(fn, symbol.Range.End)
+			return []DocumentSymbol{symbol}
+		}
+		// 右边表达式里的函数字面量中定义的名字跟这个变量并列
+		return append([]DocumentSymbol{symbol}, a.expression(stmt.Value)...)
+	case *ast.FunctionDefinitionStatement:
+		fn := stmt.FnLiteral
+		if isNil(fn) || fn.Name == nil {
+			return nil
+		}
+		def := a.define(fn.Name.Token, false)
+		def.function = signature(fn)
+		return []DocumentSymbol{{
+			Name: fn.Name.Value,
+			Detail: def.function,
+			Kind: SymbolKindFunction,
+			Range: Range{Start: tokenPosition(fn.Token), End: a.bodyEnd(fn, tokenRange(fn.Name.Token).End)},
+			SelectionRange: tokenRange(fn.Name.Token),
+			Children: a.function(fn),
+		}}
+	case *ast.AssignStatement:
+		if stmt.Lhs != nil {
+			a.resolve(stmt.Lhs.Token)
+		}
+		return a.expression(stmt.Rhs)
+	case *ast.IndexAssignStatement:
+		symbols := a.expression(stmt.Target)
+		return append(symbols, a.expression(stmt.Value)...)
+	case *ast.ReturnStatement:
+		return a.expression(stmt.ReturnValue)
+	case *ast.ExpressionStatement:
+		return a.expression(stmt.Expression)
+	case *ast.BlockStatement:
+		var symbols []DocumentSymbol
+		for _, s := range stmt.Statements {
+			symbols = append(symbols, a.statement(s)...)
+		}
+		return symbols
+	case *ast.WhileStatement:
+		symbols := a.expression(stmt.Condition)
+		return append(symbols, a.block(stmt.Body)...)
+	case *ast.ForStatement:
+		symbols := a.statement(stmt.Init)
+		symbols = append(symbols, a.expression(stmt.Condition)...)
+		symbols = append(symbols, a.statement(stmt.Post)...)
+		return append(symbols, a.block(stmt.Body)...)
+	case *ast.ForInStatement:
+		// 先处理被遍历的表达式，它里面不能用到循环变量
+		symbols := a.expression(stmt.Iterable)
+		if stmt.Key != nil {
+			a.define(stmt.Key.Token, false)
+		}
+		if stmt.Value != nil {
+			a.define(stmt.Value.Token, false)
+		}
+		return append(symbols, a.block(stmt.Body)...)
+	}
+	return nil
+}
+
+func (a *analyzer) block(block *ast.BlockStatement) []DocumentSymbol {
+	if block == nil {
+		return nil
+	}
+	return a.statement(block)
+}
+
+// expression 处理表达式，函数字面量里面的let语句和函数定义语句也作为文档符号返回
+func (a *analyzer) expression(expr ast.Expression) []DocumentSymbol {
+	if isNil(expr) {
+		return nil
+	}
+
+	var symbols []DocumentSymbol
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		a.resolve(expr.Token)
+	case *ast.PrefixExpression:
+		symbols = a.expression(expr.Right)
+	case *ast.InfixExpression:
+		symbols = append(a.expression(expr.Left), a.expression(expr.Right)...)
+	case *ast.IfExpression:
+		symbols = a.expression(expr.Condition)
+		symbols = append(symbols, a.block(expr.Consequence)...)
+		symbols = append(symbols, a.expression(expr.ElseIf)...)
+		symbols = append(symbols, a.block(expr.Alternative)...)
+	case *ast.FunctionLiteral:
+		symbols = a.function(expr)
+	case *ast.CallExpression:
+		symbols = a.expression(expr.Function)
+		for _, arg := range expr.Arguments {
+			symbols = append(symbols, a.expression(arg)...)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range expr.Elements {
+			symbols = append(symbols, a.expression(el)...)
+		}
+	case *ast.HashLiteral:
+		for key, value := range expr.Pairs {
+			symbols = append(symbols, a.expression(key)...)
+			symbols = append(symbols, a.expression(value)...)
+		}
+	case *ast.IndexExpression:
+		symbols = append(a.expression(expr.Left), a.expression(expr.Index)...)
+	}
+	return symbols
+}
+
+// function 进入函数字面量的作用域，跟编译器一样先定义函数自身的名字，再定义形参
+func (a *analyzer) function(fn *ast.FunctionLiteral) []DocumentSymbol {
+	outerTable, outerScope := a.table, a.scope
+	a.table = compiler.NewEnclosedSymbolTable(outerTable)
+	a.scope = &scope{
+		rng: Range{Start: tokenPosition(fn.Token), End: a.bodyEnd(fn, tokenRange(fn.Token).End)},
+		outer: outerScope,
+	}
+	a.scopes = append(a.scopes, a.scope)
+	defer func() {
+		a.table, a.scope = outerTable, outerScope
+	}()
+
+	if fn.Name != nil {
+		a.table.DefineFunctionName(fn.Name.Value)
+		// 函数自身的名字指向外层的定义（let或者函数定义语句），匿名函数没有名字
+		if def := a.lookup(outerTable, fn.Name.Value); def != nil {
+			a.defsOf(a.table)[fn.Name.Value] = def
+		}
+	}
+	for _, param := range fn.Parameters {
+		if param != nil {
+			a.define(param.Token, true)
+		}
+	}
+	return a.block(fn.Body)
+}
+
+func (a *analyzer) defsOf(table *compiler.SymbolTable) map[string]*definition {
+	defs, ok := a.defs[table]
+	if !ok {
+		defs = map[string]*definition{}
+		a.defs[table] = defs
+	}
+	return defs
+}
+
+// define 在当前符号表中定义一个名字，同时作为一个引用记录下来，这样在定义处也能跳转和悬停
+func (a *analyzer) define(tok token.Token, parameter bool) *definition {
+	symbol := a.table.Define(tok.Literal)
+	def := &definition{name: tok.Literal, token: tok, parameter: parameter}
+	a.defsOf(a.table)[tok.Literal] = def
+	a.scope.defs = append(a.scope.defs, def)
+	a.refs = append(a.refs, &reference{token: tok, symbol: symbol, def: def})
+	return def
+}
+
+// lookup 从table开始由内向外查找名字的定义，跟SymbolTable.Resolve的查找顺序一致
+func (a *analyzer) lookup(table *compiler.SymbolTable, name string) *definition {
+	for t := table; t != nil; t = t.Outer {
+		if def, ok := a.defs[t][name]; ok {
+			return def
+		}
+	}
+	return nil
+}
+
+func (a *analyzer) resolve(tok token.Token) {
+	symbol, ok := a.table.Resolve(tok.Literal)
+	if !ok {
+		return
+	}
+	ref := &reference{token: tok, symbol: symbol}
+	if symbol.Scope != compiler.BuiltinScope {
+		ref.def = a.lookup(a.table, tok.Literal)
+	}
+	a.refs = append(a.refs, ref)
+}
+
+// bodyEnd 函数体右花括号之后的位置，语法错误导致函数体不完整时返回def
+func (a *analyzer) bodyEnd(fn *ast.FunctionLiteral, def Position) Position {
+	if fn == nil || fn.Body == nil || fn.Body.End.Line == 0 {
+		return def
+	}
+	return positionOf(fn.Body.End)
+}
+
+func signature(fn *ast.FunctionLiteral) string {
+	params := make([]string, 0, len(fn.Parameters))
+	for _, p := range fn.Parameters {
+		if p != nil {
+			params = append(params, p.Value)
+		}
+	}
+	return fmt.Sprintf("fn(%s)", strings.Join(params, ", "))
+}
+
+// referenceAt 查找位置pos上的标识符
+func (an *analysis) referenceAt(pos Position) *reference {
+	for _, ref := range an.refs {
+		if contains(tokenRange(ref.token), pos) {
+			return ref
+		}
+	}
+	return nil
+}
+
+// visibleAt /**
+/*
+位置pos处可以使用的名字：所在作用域及其外层作用域中在pos之前定义的名字，形参和函数名在整个函数体内都可用。
+内层的定义遮盖外层的同名定义
+ */
+func (an *analysis) visibleAt(pos Position) []*definition {
+	// 作用域按进入的先后顺序排列，外层在前，最后一个包含pos的就是最内层的
+	inner := an.scopes[0]
+	for _, s := range an.scopes[1:] {
+		if contains(s.rng, pos) {
+			inner = s
+		}
+	}
+
+	seen := map[string]bool{}
+	var defs []*definition
+	for s := inner; s != nil; s = s.outer {
+		for i := len(s.defs) - 1; i >= 0; i-- {
+			def := s.defs[i]
+			if seen[def.name] || !def.parameter && !before(tokenPosition(def.token), pos) {
+				continue
+			}
+			seen[def.name] = true
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+func tokenPosition(tok token.Token) Position {
+	return Position{Line: tok.LineNum - 1, Character: tok.ColumnNum - 1}
+}
+
+// positionOf LSP的行号和列号从0开始
+func positionOf(pos token.Position) Position {
+	return Position{Line: pos.Line - 1, Character: pos.Column - 1}
+}
+
+func tokenRange(tok token.Token) Range {
+	start := tokenPosition(tok)
+	if tok.End.Line > 0 && tok.End.Offset > tok.Offset {
+		return Range{Start: start, End: positionOf(tok.End)}
+	}
+	// EOF之类没有长度的token也给出一个字符的范围
+	return Range{Start: start, End: Position{Line: start.Line, Character: start.Character + 1}}
+}
+
+// before 判断p是否在q之前
+func before(p, q Position) bool {
+	return p.Line < q.Line || p.Line == q.Line && p.Character < q.Character
+}
+
+// contains 判断pos是否在r中，包括末尾，这样光标在标识符最后一个字符之后也算
+func contains(r Range, pos Position) bool {
+	return !before(pos, r.Start) && !before(r.End, pos)
+}
+
+func compilerDiagnostic(d *compiler.Diagnostic) Diagnostic {
+	diagnostic := Diagnostic{Severity: SeverityError, Source: "glue", Message: d.Message}
+	if d.Severity == compiler.SeverityWarning {
+		diagnostic.Severity = SeverityWarning
+	}
+	if d.Line() > 0 {
+		diagnostic.Range = tokenRange(d.Token)
+	}
+	return diagnostic
+}
+
+// parseErrorDiagnostic 语法错误标出出错的token，词法错误没有token，标出出错位置的一个字符
+func parseErrorDiagnostic(err *parser.ParseError) Diagnostic {
+	diagnostic := Diagnostic{Severity: SeverityError, Source: "glue", Message: err.Message()}
+	if err.Token != nil && err.Token.LineNum > 0 {
+		diagnostic.Range = tokenRange(*err.Token)
+	}else if err.LineNum > 0 {
+		start := Position{Line: err.LineNum - 1}
+		if err.ColNum > 0 {
+			start.Character = err.ColNum - 1
+		}
+		diagnostic.Range = Range{Start: start, End: Position{Line: start.Line, Character: start.Character + 1}}
+	}
+	return diagnostic
+}
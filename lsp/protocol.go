@@ -0,0 +1,185 @@
+package lsp
+
+import "encoding/json"
+
+// LSP（Language Server Protocol）基于JSON-RPC 2.0，收发用wire包，这里只定义了用到的字段，
+// 参考 https://microsoft.github.io/language-server-protocol/specification
+
+// Message 收到的请求或者通知，没有ID的是通知
+type Message struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID *json.RawMessage `json:"id,omitempty"`
+	Method string `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Response 成功的响应，Result为nil时编码成null
+type Response struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID *json.RawMessage `json:"id"`
+	Result interface{} `json:"result"`
+}
+
+type ErrorResponse struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID *json.RawMessage `json:"id"`
+	Error *ResponseError `json:"error"`
+}
+
+// Notification 服务端发出的通知
+type Notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method string `json:"method"`
+	Params interface{} `json:"params"`
+}
+
+type ResponseError struct {
+	Code int `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ResponseError) Error() string {
+	return e.Message
+}
+
+// JSON-RPC和LSP定义的错误码
+const (
+	codeParseError = -32700
+	codeInvalidParams = -32602
+	codeMethodNotFound = -32601
+	codeServerNotInitialized = -32002
+	codeInvalidRequest = -32600
+)
+
+// Position 行号和列号都从0开始。协议里的列号是UTF-16编码单元，分析结果里按字符计数，由Server在两者之间转换
+type Position struct {
+	Line int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End Position `json:"end"`
+}
+
+type Location struct {
+	URI string `json:"uri"`
+	Range Range `json:"range"`
+}
+
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type TextDocumentItem struct {
+	URI string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version int `json:"version"`
+	Text string `json:"text"`
+}
+
+type DidOpenTextDocumentParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+type TextDocumentContentChangeEvent struct {
+	Text string `json:"text"`
+}
+
+type DidChangeTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type TextDocumentPositionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position Position `json:"position"`
+}
+
+type DocumentSymbolParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type ServerCapabilities struct {
+	TextDocumentSync int `json:"textDocumentSync"`
+	DefinitionProvider bool `json:"definitionProvider"`
+	HoverProvider bool `json:"hoverProvider"`
+	DocumentSymbolProvider bool `json:"documentSymbolProvider"`
+	CompletionProvider *CompletionOptions `json:"completionProvider,omitempty"`
+}
+
+type CompletionOptions struct {
+	TriggerCharacters []string `json:"triggerCharacters,omitempty"`
+}
+
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+	ServerInfo struct {
+		Name string `json:"name"`
+	} `json:"serverInfo"`
+}
+
+// TextDocumentSyncKind
+const syncFull = 1
+
+type DiagnosticSeverity int
+
+const (
+	SeverityError DiagnosticSeverity = 1
+	SeverityWarning DiagnosticSeverity = 2
+)
+
+type Diagnostic struct {
+	Range Range `json:"range"`
+	Severity DiagnosticSeverity `json:"severity"`
+	Source string `json:"source"`
+	Message string `json:"message"`
+}
+
+type PublishDiagnosticsParams struct {
+	URI string `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+type MarkupContent struct {
+	Kind string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type Hover struct {
+	Contents MarkupContent `json:"contents"`
+	Range *Range `json:"range,omitempty"`
+}
+
+type SymbolKind int
+
+const (
+	SymbolKindFunction SymbolKind = 12
+	SymbolKindVariable SymbolKind = 13
+)
+
+type DocumentSymbol struct {
+	Name string `json:"name"`
+	Detail string `json:"detail,omitempty"`
+	Kind SymbolKind `json:"kind"`
+	Range Range `json:"range"`
+	SelectionRange Range `json:"selectionRange"`
+	Children []DocumentSymbol `json:"children,omitempty"`
+}
+
+type CompletionItemKind int
+
+const (
+	CompletionItemKindFunction CompletionItemKind = 3
+	CompletionItemKindVariable CompletionItemKind = 6
+)
+
+type CompletionItem struct {
+	Label string `json:"label"`
+	Kind CompletionItemKind `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
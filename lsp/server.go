@@ -0,0 +1,366 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"glue/compiler"
+	"glue/internal/wire"
+	"io"
+	"sort"
+	"strings"
+)
+
+// document /**
+/*
+一个打开的文档。分析结果里的列号跟lexer一样按字符计数，协议里的列号是UTF-16编码单元，
+收到请求和发出结果时用lines按行转换
+ */
+type document struct {
+	analysis *analysis
+	lines []string
+}
+
+// toUTF16 把按字符计数的列号转成UTF-16编码单元，超出行尾的部分原样加上
+func (d *document) toUTF16(pos Position) Position {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return pos
+	}
+	units, chars := 0, 0
+	for _, r := range d.lines[pos.Line] {
+		if chars == pos.Character {
+			break
+		}
+		units += utf16Len(r)
+		chars++
+	}
+	return Position{Line: pos.Line, Character: units + pos.Character - chars}
+}
+
+// fromUTF16 把UTF-16编码单元的列号转成按字符计数，落在代理对中间时算作这个字符
+func (d *document) fromUTF16(pos Position) Position {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return pos
+	}
+	units, chars := 0, 0
+	for _, r := range d.lines[pos.Line] {
+		if units >= pos.Character {
+			break
+		}
+		units += utf16Len(r)
+		chars++
+	}
+	if units < pos.Character {
+		chars += pos.Character - units
+	}
+	return Position{Line: pos.Line, Character: chars}
+}
+
+func (d *document) rangeToUTF16(r Range) Range {
+	return Range{Start: d.toUTF16(r.Start), End: d.toUTF16(r.End)}
+}
+
+func (d *document) symbolsToUTF16(symbols []DocumentSymbol) []DocumentSymbol {
+	converted := make([]DocumentSymbol, 0, len(symbols))
+	for _, symbol := range symbols {
+		symbol.Range = d.rangeToUTF16(symbol.Range)
+		symbol.SelectionRange = d.rangeToUTF16(symbol.SelectionRange)
+		if symbol.Children != nil {
+			symbol.Children = d.symbolsToUTF16(symbol.Children)
+		}
+		converted = append(converted, symbol)
+	}
+	return converted
+}
+
+// utf16Len 字符编码成UTF-16的单元个数，基本平面以外的字符用代理对表示
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// Server /**
+/*
+通过LSP协议为编辑器提供glue源文件的诊断信息、跳转到定义、悬停提示、文档符号和补全。
+文档内容由编辑器通过didOpen/didChange通知发来（整个文档同步），每次变化都重新解析、编译并分析一遍。
+请求按顺序在同一个goroutine里处理
+ */
+type Server struct {
+	in *bufio.Reader
+	out io.Writer
+
+	documents map[string]*document
+	initialized bool
+	shutdown bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in: bufio.NewReader(in),
+		out: out,
+		documents: map[string]*document{},
+	}
+}
+
+// Serve 处理消息直到收到exit通知或者输入结束
+func (s *Server) Serve() error {
+	for {
+		data, err := wire.ReadMessage(s.in)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			if err := s.replyError(nil, &ResponseError{Code: codeParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+
+		if msg.ID == nil {
+			err = s.notification(&msg)
+		}else {
+			err = s.request(&msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) request(msg *Message) error {
+	var result interface{}
+	var err error
+
+	switch {
+	case msg.Method == "initialize":
+		s.initialized = true
+		result = s.initialize()
+	case !s.initialized:
+		err = &ResponseError{Code: codeServerNotInitialized, Message: "server not initialized"}
+	case s.shutdown:
+		err = &ResponseError{Code: codeInvalidRequest, Message: "server is shutting down"}
+	case msg.Method == "shutdown":
+		s.shutdown = true
+	case msg.Method == "textDocument/definition":
+		result, err = s.definition(msg.Params)
+	case msg.Method == "textDocument/hover":
+		result, err = s.hover(msg.Params)
+	case msg.Method == "textDocument/documentSymbol":
+		result, err = s.documentSymbol(msg.Params)
+	case msg.Method == "textDocument/completion":
+		result, err = s.completion(msg.Params)
+	default:
+		err = &ResponseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %s not supported", msg.Method)}
+	}
+
+	if err != nil {
+		respErr, ok := err.(*ResponseError)
+		if !ok {
+			respErr = &ResponseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return s.replyError(msg.ID, respErr)
+	}
+	return wire.WriteMessage(s.out, &Response{JSONRPC: "2.0", ID: msg.ID, Result: result})
+}
+
+func (s *Server) replyError(id *json.RawMessage, respErr *ResponseError) error {
+	return wire.WriteMessage(s.out, &ErrorResponse{JSONRPC: "2.0", ID: id, Error: respErr})
+}
+
+// notification 通知不需要响应，参数有问题时直接忽略
+func (s *Server) notification(msg *Message) error {
+	switch msg.Method {
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) != nil {
+			return nil
+		}
+		return s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		// 整个文档同步，最后一次变化就是完整的新内容
+		return s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) != nil {
+			return nil
+		}
+		delete(s.documents, params.TextDocument.URI)
+		return s.publishDiagnostics(params.TextDocument.URI, []Diagnostic{})
+	}
+	return nil
+}
+
+func (s *Server) initialize() *InitializeResult {
+	result := &InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync: syncFull,
+			DefinitionProvider: true,
+			HoverProvider: true,
+			DocumentSymbolProvider: true,
+			CompletionProvider: &CompletionOptions{},
+		},
+	}
+	result.ServerInfo.Name = "glue"
+	return result
+}
+
+// update 重新分析文档并发布诊断信息
+func (s *Server) update(uri, text string) error {
+	doc := &document{analysis: analyze(text), lines: strings.Split(text, "\n")}
+	s.documents[uri] = doc
+
+	diagnostics := make([]Diagnostic, 0, len(doc.analysis.diagnostics))
+	for _, d := range doc.analysis.diagnostics {
+		d.Range = doc.rangeToUTF16(d.Range)
+		diagnostics = append(diagnostics, d)
+	}
+	return s.publishDiagnostics(uri, diagnostics)
+}
+
+func (s *Server) publishDiagnostics(uri string, diagnostics []Diagnostic) error {
+	return wire.WriteMessage(s.out, &Notification{
+		JSONRPC: "2.0",
+		Method: "textDocument/publishDiagnostics",
+		Params: PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	doc, ok := s.documents[uri]
+	if !ok {
+		return nil, fmt.Errorf("document %s is not open", uri)
+	}
+	return doc, nil
+}
+
+// positionParams 解析带位置的请求参数，找到对应的文档，位置已经转成按字符计数的列号
+func (s *Server) positionParams(raw json.RawMessage) (*TextDocumentPositionParams, *document, error) {
+	var params TextDocumentPositionParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, nil, err
+	}
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, nil, err
+	}
+	params.Position = doc.fromUTF16(params.Position)
+	return &params, doc, nil
+}
+
+func (s *Server) definition(raw json.RawMessage) (interface{}, error) {
+	params, doc, err := s.positionParams(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := doc.analysis.referenceAt(params.Position)
+	if ref == nil || ref.def == nil {
+		return nil, nil
+	}
+	return &Location{URI: params.TextDocument.URI, Range: doc.rangeToUTF16(tokenRange(ref.def.token))}, nil
+}
+
+func (s *Server) hover(raw json.RawMessage) (interface{}, error) {
+	params, doc, err := s.positionParams(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := doc.analysis.referenceAt(params.Position)
+	if ref == nil {
+		return nil, nil
+	}
+	rng := doc.rangeToUTF16(tokenRange(ref.token))
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: describe(ref)}, Range: &rng}, nil
+}
+
+// describe 悬停提示的内容：名字和它的作用域，有定义处的再加上定义所在的行
+func describe(ref *reference) string {
+	name := ref.token.Literal
+	var kind string
+	switch ref.symbol.Scope {
+	case compiler.GlobalScope:
+		kind = "global"
+	case compiler.LocalScope:
+		kind = "local"
+		if ref.def != nil && ref.def.parameter {
+			kind = "parameter"
+		}
+	case compiler.FreeScope:
+		kind = "free variable"
+	case compiler.FunctionScope:
+		kind = "current function"
+	case compiler.BuiltinScope:
+		return fmt.Sprintf("```glue\n(builtin) %s\n```", name)
+	}
+
+	signature := name
+	if ref.def != nil && ref.def.function != "" {
+		signature = name + ": " + ref.def.function
+	}
+	value := fmt.Sprintf("```glue\n(%s) %s\n```", kind, signature)
+	if ref.def != nil {
+		value += fmt.Sprintf("\ndefined on line %d", ref.def.token.LineNum)
+	}
+	if ref.symbol.Scope == compiler.FreeScope {
+		value += ", captured from an enclosing function"
+	}
+	return value
+}
+
+func (s *Server) documentSymbol(raw json.RawMessage) (interface{}, error) {
+	var params DocumentSymbolParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	return doc.symbolsToUTF16(doc.analysis.symbols), nil
+}
+
+// completion 补全光标处可以使用的名字和所有内置函数，由编辑器按已经输入的前缀过滤
+func (s *Server) completion(raw json.RawMessage) (interface{}, error) {
+	params, doc, err := s.positionParams(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []CompletionItem{}
+	seen := map[string]bool{}
+	for _, def := range doc.analysis.visibleAt(params.Position) {
+		seen[def.name] = true
+		item := CompletionItem{Label: def.name, Kind: CompletionItemKindVariable}
+		if def.function != "" {
+			item.Kind = CompletionItemKindFunction
+			item.Detail = def.function
+		}
+		items = append(items, item)
+	}
+
+	// 同名的变量会遮盖内置函数
+	builtins := doc.analysis.builtins.Names()
+	sort.Strings(builtins)
+	for _, name := range builtins {
+		if !seen[name] {
+			items = append(items, CompletionItem{Label: name, Kind: CompletionItemKindFunction, Detail: "builtin"})
+		}
+	}
+	return items, nil
+}
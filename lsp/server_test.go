@@ -0,0 +1,377 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"glue/internal/wire"
+	"io"
+	"strings"
+	"testing"
+)
+
+const uri = "file:///tmp/main.gl"
+
+const source = `let g = 10;
+fn add(a, b) {
+let s = a + b + g;
+return s;
+}
+let mk = fn(x) {
+fn(y) { add(x, y) }
+};
+let h = mk(1);
+print(len("abc"), h(2));`
+
+// received 客户端收到的响应或者通知
+type received struct {
+	ID *int `json:"id"`
+	Method string `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error *ResponseError `json:"error"`
+}
+
+// testClient 在同一个进程里通过管道跟Server通信的JSON-RPC客户端
+type testClient struct {
+	t *testing.T
+	w io.WriteCloser
+	r *bufio.Reader
+	id int
+	done chan error
+}
+
+func startServer(t *testing.T) *testClient {
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	c := &testClient{t: t, w: reqW, r: bufio.NewReader(respR), done: make(chan error, 1)}
+
+	go func() {
+		err := NewServer(reqR, respW).Serve()
+		respW.Close()
+		c.done <- err
+	}()
+
+	var result InitializeResult
+	c.call("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}, &result)
+	if !result.Capabilities.DefinitionProvider || !result.Capabilities.HoverProvider ||
+		!result.Capabilities.DocumentSymbolProvider || result.Capabilities.CompletionProvider == nil {
+		t.Fatalf("missing capabilities: %+v", result.Capabilities)
+	}
+	c.notify("initialized", map[string]interface{}{})
+	return c
+}
+
+func (c *testClient) write(msg map[string]interface{}) {
+	msg["jsonrpc"] = "2.0"
+	if err := wire.WriteMessage(c.w, msg); err != nil {
+		c.t.Fatalf("write %v: %s", msg["method"], err)
+	}
+}
+
+func (c *testClient) read() received {
+	data, err := wire.ReadMessage(c.r)
+	if err != nil {
+		c.t.Fatalf("read: %s", err)
+	}
+	var msg received
+	if err := json.Unmarshal(data, &msg); err != nil {
+		c.t.Fatalf("invalid message %s: %s", data, err)
+	}
+	return msg
+}
+
+func (c *testClient) notify(method string, params interface{}) {
+	c.write(map[string]interface{}{"method": method, "params": params})
+}
+
+// call 发送请求并等待响应，把结果解析到result，返回响应中的错误
+func (c *testClient) call(method string, params interface{}, result interface{}) *ResponseError {
+	c.id++
+	c.write(map[string]interface{}{"id": c.id, "method": method, "params": params})
+
+	msg := c.read()
+	if msg.ID == nil || *msg.ID != c.id {
+		c.t.Fatalf("expected response to %s, got=%+v", method, msg)
+	}
+	if msg.Error != nil {
+		return msg.Error
+	}
+	if result != nil {
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			c.t.Fatalf("invalid %s result %s: %s", method, msg.Result, err)
+		}
+	}
+	return nil
+}
+
+// open 打开文档，返回随后发布的诊断信息
+func (c *testClient) open(text string) []Diagnostic {
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, LanguageID: "glue", Version: 1, Text: text},
+	})
+	return c.diagnostics()
+}
+
+func (c *testClient) diagnostics() []Diagnostic {
+	msg := c.read()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expected diagnostics, got=%+v", msg)
+	}
+	var params PublishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil || params.URI != uri {
+		c.t.Fatalf("invalid diagnostics %s", msg.Params)
+	}
+	return params.Diagnostics
+}
+
+func (c *testClient) close() {
+	if err := c.call("shutdown", nil, nil); err != nil {
+		c.t.Fatalf("shutdown failed: %s", err.Message)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		c.t.Fatalf("serve error: %s", err)
+	}
+}
+
+func at(line, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: Position{line, character}}
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := startServer(t)
+	defer c.close()
+
+	if diagnostics := c.open(source); len(diagnostics) != 0 {
+		t.Errorf("expected no diagnostics, got=%+v", diagnostics)
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "let x = 1;\nlet y = z + x;"}},
+	})
+	diagnostics := c.diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("wrong number of diagnostics: %+v", diagnostics)
+	}
+	d := diagnostics[0]
+	expected := Range{Start: Position{1, 8}, End: Position{1, 9}}
+	if d.Severity != SeverityError || d.Message != "undefined variable z" || d.Range != expected {
+		t.Errorf("wrong diagnostic: %+v", d)
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "let x = 1;\nlet = 2;"}},
+	})
+	diagnostics = c.diagnostics()
+	if len(diagnostics) == 0 {
+		t.Fatalf("expected parse errors")
+	}
+	for _, d := range diagnostics {
+		if d.Severity != SeverityError || d.Range.Start.Line != 1 || strings.HasSuffix(d.Message, "]") {
+			t.Errorf("wrong parse error diagnostic: %+v", d)
+		}
+	}
+
+	c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+	if diagnostics := c.diagnostics(); len(diagnostics) != 0 {
+		t.Errorf("expected diagnostics to be cleared, got=%+v", diagnostics)
+	}
+}
+
+// TestUTF16Positions 协议里的列号是UTF-16编码单元，基本平面以外的字符占两个单元
+func TestUTF16Positions(t *testing.T) {
+	c := startServer(t)
+	defer c.close()
+
+	diagnostics := c.open("let s = \"😀é\"; let y = z;\nlet e = \"😀\\q\";")
+	if len(diagnostics) != 1 {
+		t.Fatalf("wrong number of diagnostics: %+v", diagnostics)
+	}
+	expected := Range{Start: Position{1, 11}, End: Position{1, 12}}
+	if d := diagnostics[0]; d.Message != `invalid escape sequence "\\q"` || d.Range != expected {
+		t.Errorf("wrong lexer error diagnostic: %+v", d)
+	}
+
+	diagnostics = c.open("let s = \"😀é\"; let y = z;")
+	expected = Range{Start: Position{0, 23}, End: Position{0, 24}}
+	if len(diagnostics) != 1 || diagnostics[0].Range != expected {
+		t.Fatalf("wrong diagnostics: %+v", diagnostics)
+	}
+
+	var hover *Hover
+	if err := c.call("textDocument/hover", at(0, 19), &hover); err != nil {
+		t.Fatalf("hover failed: %s", err.Message)
+	}
+	expected = Range{Start: Position{0, 19}, End: Position{0, 20}}
+	if hover == nil || !strings.Contains(hover.Contents.Value, "(global) y") || *hover.Range != expected {
+		t.Errorf("wrong hover: %+v", hover)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	c := startServer(t)
+	defer c.close()
+	c.open(source)
+
+	tests := []struct {
+		pos TextDocumentPositionParams
+		expected *Position // nil表示没有定义处
+	}{
+		{at(3, 7), &Position{2, 4}}, // return s
+		{at(2, 16), &Position{0, 4}}, // g，全局变量
+		{at(2, 12), &Position{1, 10}}, // b，形参
+		{at(6, 13), &Position{5, 12}}, // x，自由变量
+		{at(6, 9), &Position{1, 3}}, // add
+		{at(9, 19), &Position{8, 4}}, // h
+		{at(8, 4), &Position{8, 4}}, // let h本身
+		{at(9, 7), nil}, // len，内置函数
+		{at(9, 12), nil}, // 字符串
+	}
+
+	for _, tt := range tests {
+		var location *Location
+		if err := c.call("textDocument/definition", tt.pos, &location); err != nil {
+			t.Fatalf("definition failed: %s", err.Message)
+		}
+		if tt.expected == nil {
+			if location != nil {
+				t.Errorf("expected no definition at %+v, got=%+v", tt.pos.Position, location)
+			}
+			continue
+		}
+		if location == nil || location.URI != uri || location.Range.Start != *tt.expected {
+			t.Errorf("wrong definition at %+v. want=%+v, got=%+v", tt.pos.Position, *tt.expected, location)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	c := startServer(t)
+	defer c.close()
+	c.open(source)
+
+	tests := []struct {
+		pos TextDocumentPositionParams
+		expected string
+	}{
+		{at(2, 16), "(global) g\n```\ndefined on line 1"},
+		{at(3, 7), "(local) s\n"},
+		{at(2, 8), "(parameter) a\n"},
+		{at(6, 13), "(free variable) x\n```\ndefined on line 6, captured from an enclosing function"},
+		{at(6, 9), "(global) add: fn(a, b)\n"},
+		{at(9, 7), "(builtin) len\n"},
+	}
+
+	for _, tt := range tests {
+		var hover *Hover
+		if err := c.call("textDocument/hover", tt.pos, &hover); err != nil {
+			t.Fatalf("hover failed: %s", err.Message)
+		}
+		if hover == nil || !strings.Contains(hover.Contents.Value, tt.expected) {
+			t.Errorf("wrong hover at %+v. want=%q, got=%+v", tt.pos.Position, tt.expected, hover)
+		}
+	}
+
+	var hover *Hover
+	c.call("textDocument/hover", at(4, 0), &hover)
+	if hover != nil {
+		t.Errorf("expected no hover, got=%+v", hover)
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := startServer(t)
+	defer c.close()
+	c.open(source)
+
+	var symbols []DocumentSymbol
+	if err := c.call("textDocument/documentSymbol", DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: uri}}, &symbols); err != nil {
+		t.Fatalf("documentSymbol failed: %s", err.Message)
+	}
+
+	expected := []struct {
+		name string
+		kind SymbolKind
+		rng Range
+		children []string
+	}{
+		{"g", SymbolKindVariable, Range{Position{0, 0}, Position{0, 5}}, nil},
+		{"add", SymbolKindFunction, Range{Position{1, 0}, Position{4, 1}}, []string{"s"}},
+		{"mk", SymbolKindFunction, Range{Position{5, 0}, Position{7, 1}}, nil},
+		{"h", SymbolKindVariable, Range{Position{8, 0}, Position{8, 5}}, nil},
+	}
+	if len(symbols) != len(expected) {
+		t.Fatalf("wrong symbols: %+v", symbols)
+	}
+	for i, e := range expected {
+		symbol := symbols[i]
+		if symbol.Name != e.name || symbol.Kind != e.kind || symbol.Range != e.rng {
+			t.Errorf("wrong symbol %d. want=%+v, got=%+v", i, e, symbol)
+		}
+		if len(symbol.Children) != len(e.children) {
+			t.Errorf("wrong children of %s: %+v", e.name, symbol.Children)
+			continue
+		}
+		for j, child := range e.children {
+			if symbol.Children[j].Name != child {
+				t.Errorf("wrong child %d of %s. want=%s, got=%s", j, e.name, child, symbol.Children[j].Name)
+			}
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	c := startServer(t)
+	defer c.close()
+	c.open(source)
+
+	tests := []struct {
+		pos TextDocumentPositionParams
+		included []string
+		excluded []string
+	}{
+		// add函数体里，mk和h还没有定义
+		{at(3, 7), []string{"s", "a", "b", "g", "add", "len", "print"}, []string{"mk", "h", "x", "y"}},
+		// 内层匿名函数里，外层的形参也可以用
+		{at(6, 9), []string{"x", "y", "add", "g", "mk"}, []string{"s", "a", "h"}},
+		{at(9, 0), []string{"g", "add", "mk", "h", "first"}, []string{"x", "s"}},
+	}
+
+	for _, tt := range tests {
+		var items []CompletionItem
+		if err := c.call("textDocument/completion", tt.pos, &items); err != nil {
+			t.Fatalf("completion failed: %s", err.Message)
+		}
+		labels := map[string]CompletionItem{}
+		for _, item := range items {
+			labels[item.Label] = item
+		}
+		for _, name := range tt.included {
+			if _, ok := labels[name]; !ok {
+				t.Errorf("completion at %+v does not include %s", tt.pos.Position, name)
+			}
+		}
+		for _, name := range tt.excluded {
+			if _, ok := labels[name]; ok {
+				t.Errorf("completion at %+v should not include %s", tt.pos.Position, name)
+			}
+		}
+		if item := labels["add"]; item.Kind != CompletionItemKindFunction || item.Detail != "fn(a, b)" {
+			t.Errorf("wrong completion item for add: %+v", item)
+		}
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	c := startServer(t)
+	defer c.close()
+
+	if err := c.call("textDocument/hover", at(0, 0), nil); err == nil || err.Code != codeInvalidParams {
+		t.Errorf("expected error for unopened document, got=%+v", err)
+	}
+	if err := c.call("workspace/symbol", map[string]interface{}{}, nil); err == nil || err.Code != codeMethodNotFound {
+		t.Errorf("expected method not found, got=%+v", err)
+	}
+}
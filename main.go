@@ -22,6 +22,12 @@ import (
 )
 
 func main() {
+	// 子命令不输出欢迎信息，输出只包含命令本身的结果，方便在脚本里使用
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+	}
 
 	currUser, err := user.Current()
 	if err != nil {
@@ -78,9 +84,10 @@ func main() {
 	if *engine == "vm" {
 		c := compiler.New()
 		err = c.Compile(program)
+		// 警告也一并输出，有错误时不再往下执行
+		printDiagnostics(iptFile, c.Diagnostics())
 		if err != nil {
-			log.ErrorF("error %s", err)
-			panic(err)
+			os.Exit(30)
 		}
 		//c.Info()
 		//fmt.Println("instructions:", c.Bytecode().Instructions.String())
@@ -89,6 +96,10 @@ func main() {
 		err = machine.Run()
 		if err != nil {
 			log.ErrorF("error %s", err)
+			if re, ok := err.(*vm.RuntimeError); ok {
+				fmt.Fprint(os.Stderr, re.StackTrace())
+				os.Exit(20)
+			}
 			panic(err)
 		}
 		//result := machine.LastPoppedStackElem()
@@ -0,0 +1,87 @@
+package object
+
+import "fmt"
+
+// MaxBuiltins OpGetBuiltin的操作数只有1个字节，一个注册表最多能容纳的内置函数个数
+const MaxBuiltins = 256
+
+// BuiltinRegistry /**
+/*
+一组内置函数，编译器按注册顺序给它们分配索引，OpGetBuiltin的操作数就是这个索引，VM执行时再按索引取回函数。
+编译和执行必须使用同一个注册表（Bytecode里记录了编译时用的注册表），不同的宿主可以各自创建注册表，
+比如一个只暴露部分函数的沙箱注册表和一个完整的注册表，互不影响。
+
+注册表只能追加不能删除，已经分配的索引不会变，编译好的字节码在注册表扩充之后仍然有效
+ */
+type BuiltinRegistry struct {
+	builtins []*Builtin
+	index map[string]int
+}
+
+func NewBuiltinRegistry() *BuiltinRegistry {
+	return &BuiltinRegistry{index: make(map[string]int)}
+}
+
+// DefaultBuiltins /**
+/*
+返回一个包含所有标准内置函数（Builtins）的新注册表，每次调用都是独立的一份，往里面注册函数不会影响别的注册表
+ */
+func DefaultBuiltins() *BuiltinRegistry {
+	r := NewBuiltinRegistry()
+	for _, def := range Builtins {
+		r.Add(def.Builtin)
+	}
+	return r
+}
+
+// Register /**
+/*
+注册一个内置函数。同名的函数已经存在时替换它，索引保持不变；注册表满了返回错误
+ */
+func (r *BuiltinRegistry) Register(name string, fn BuiltinFunction) error {
+	return r.Add(&Builtin{Name: name, Fn: fn})
+}
+
+// Add 同Register，直接使用已有的Builtin，b.Name作为名字
+func (r *BuiltinRegistry) Add(b *Builtin) error {
+	if i, ok := r.index[b.Name]; ok {
+		r.builtins[i] = b
+		return nil
+	}
+	if len(r.builtins) >= MaxBuiltins {
+		return fmt.Errorf("too many builtins, max %d", MaxBuiltins)
+	}
+	r.index[b.Name] = len(r.builtins)
+	r.builtins = append(r.builtins, b)
+	return nil
+}
+
+// Lookup 按名字查找内置函数，同时返回它的索引
+func (r *BuiltinRegistry) Lookup(name string) (*Builtin, int, bool) {
+	i, ok := r.index[name]
+	if !ok {
+		return nil, -1, false
+	}
+	return r.builtins[i], i, true
+}
+
+// At 按索引取内置函数，索引越界返回nil
+func (r *BuiltinRegistry) At(index int) *Builtin {
+	if index < 0 || index >= len(r.builtins) {
+		return nil
+	}
+	return r.builtins[index]
+}
+
+func (r *BuiltinRegistry) Len() int {
+	return len(r.builtins)
+}
+
+// Names 按索引顺序返回所有内置函数的名字
+func (r *BuiltinRegistry) Names() []string {
+	names := make([]string, len(r.builtins))
+	for i, b := range r.builtins {
+		names[i] = b.Name
+	}
+	return names
+}
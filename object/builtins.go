@@ -55,6 +55,8 @@ var Builtins = []struct{
 				}
 				return nil
 			},
+			// 返回的是数组里已有的元素，不分配新对象
+			Alloc: func(args ...Object) int64 { return 0 },
 		},
 	},
 	{
@@ -77,6 +79,8 @@ var Builtins = []struct{
 				}
 				return nil
 			},
+			// 返回的是数组里已有的元素，不分配新对象
+			Alloc: func(args ...Object) int64 { return 0 },
 		},
 	},
 	{
@@ -101,6 +105,14 @@ var Builtins = []struct{
 				}
 				return nil
 			},
+			Alloc: func(args ...Object) int64 {
+				if len(args) == 1 {
+					if arr, ok := args[0].(*Array); ok && len(arr.Elements) > 0 {
+						return ArraySize(len(arr.Elements) - 1)
+					}
+				}
+				return 0
+			},
 		},
 	},
 	{
@@ -123,6 +135,14 @@ var Builtins = []struct{
 				newElements[length] = args[1]
 				return &Array{Elements: newElements}
 			},
+			Alloc: func(args ...Object) int64 {
+				if len(args) == 2 {
+					if arr, ok := args[0].(*Array); ok {
+						return ArraySize(len(arr.Elements) + 1)
+					}
+				}
+				return 0
+			},
 		},
 	},
 
@@ -3,6 +3,8 @@ package object
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+
+	budget *Budget // 只有最外层的环境有，解释器执行时用来检查资源限制
 }
 
 func NewEnvironment() *Environment {
@@ -32,3 +34,30 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Assign 给已经定义的变量赋值，改的是定义它的那一层环境，这样闭包里修改捕获的变量在下次调用时还能看到。
+// 哪一层都没有定义时跟Set一样定义在当前环境里
+func (e *Environment) Assign(name string, val Object) Object {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			return env.Set(name, val)
+		}
+	}
+	return e.Set(name, val)
+}
+
+// SetBudget 给最外层的环境设置执行限制，函数调用时创建的环境都会沿着outer找到它
+func (e *Environment) SetBudget(b *Budget) {
+	e.root().budget = b
+}
+
+func (e *Environment) Budget() *Budget {
+	return e.root().budget
+}
+
+func (e *Environment) root() *Environment {
+	for e.outer != nil {
+		e = e.outer
+	}
+	return e
+}
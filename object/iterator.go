@@ -0,0 +1,135 @@
+package object
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+const ITERATOR_OBJ = "ITERATOR"
+
+// SortedPairs /**
+/*
+按键排好序的键值对，遍历哈希时用这个顺序，保证每次运行的结果一样。
+先按键的类型排：布尔值、数字、字符串，同一类型的false在true前面，数字按大小（整数和浮点数一起比较），字符串按字典序
+ */
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return lessKey(pairs[i].Key, pairs[j].Key)
+	})
+	return pairs
+}
+
+func keyRank(key Object) int {
+	switch key.(type) {
+	case *Boolean:
+		return 0
+	case *Integer, *Float:
+		return 1
+	case *String:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func lessKey(a, b Object) bool {
+	if ra, rb := keyRank(a), keyRank(b); ra != rb {
+		return ra < rb
+	}
+	switch a := a.(type) {
+	case *Boolean:
+		return !a.Value && b.(*Boolean).Value
+	case *String:
+		return a.Value < b.(*String).Value
+	case *Integer, *Float:
+		if x, ok := a.(*Integer); ok {
+			if y, ok := b.(*Integer); ok {
+				return x.Value < y.Value
+			}
+		}
+		// 1和1.0是同一个键，不会同时出现
+		return FloatValue(a) < FloatValue(b)
+	}
+	return false
+}
+
+// Iterator /**
+/*
+for-in循环用的迭代器，可以遍历数组、哈希和字符串。
+数组按下标遍历，循环中修改元素能看到新的值；哈希在创建迭代器时按SortedPairs的顺序取出所有键值对；
+字符串按字符（而不是字节）遍历，键是字符的序号，值是只有这个字符的字符串
+ */
+type Iterator struct {
+	source Object
+	pairs []HashPair // 遍历哈希时的键值对
+	index int // 下一个元素的序号
+	offset int // 遍历字符串时下一个字符的字节偏移
+}
+
+// NewIterator 不能遍历的对象返回错误
+func NewIterator(obj Object) (*Iterator, error) {
+	it := &Iterator{source: obj}
+	switch obj := obj.(type) {
+	case *Array, *String:
+	case *Hash:
+		it.pairs = obj.SortedPairs()
+	default:
+		return nil, fmt.Errorf("cannot iterate over %s", obj.Type())
+	}
+	return it, nil
+}
+
+func (it *Iterator) Type() ObjectType {
+	return ITERATOR_OBJ
+}
+
+func (it *Iterator) Inspect() string {
+	return fmt.Sprintf("<iterator %s>", it.source.Type())
+}
+
+// Next 取出下一个元素的键和值，没有了返回false。数组和字符串的键是序号
+func (it *Iterator) Next() (key, value Object, ok bool) {
+	switch source := it.source.(type) {
+	case *Array:
+		if it.index >= len(source.Elements) {
+			return nil, nil, false
+		}
+		value = source.Elements[it.index]
+	case *String:
+		if it.offset >= len(source.Value) {
+			return nil, nil, false
+		}
+		r, size := utf8.DecodeRuneInString(source.Value[it.offset:])
+		if r == utf8.RuneError && size == 1 {
+			value = &String{Value: source.Value[it.offset:it.offset+1]}
+		}else {
+			value = &String{Value: string(r)}
+		}
+		it.offset += size
+	case *Hash:
+		if it.index >= len(it.pairs) {
+			return nil, nil, false
+		}
+		pair := it.pairs[it.index]
+		it.index++
+		return pair.Key, pair.Value, true
+	default:
+		return nil, nil, false
+	}
+	key = &Integer{Value: int64(it.index)}
+	it.index++
+	return key, value, true
+}
+
+// Item 只有一个循环变量时它的值：遍历哈希取键，遍历数组和字符串取元素
+func (it *Iterator) Item(key, value Object) Object {
+	if it.source.Type() == HASH_OBJ {
+		return key
+	}
+	return value
+}
@@ -0,0 +1,236 @@
+package object
+
+import (
+	"context"
+	"fmt"
+)
+
+// Limits /**
+/*
+执行脚本时的资源限制，零值表示不限制。VM和解释器共用：VM的一步是一条指令，解释器的一步是对一个AST节点求值。
+MaxMemory只有VM支持，统计的是执行过程中创建数组、哈希和字符串累计分配的字节数（按SizeOf估算），不扣除被回收的内存
+ */
+type Limits struct {
+	MaxInstructions int64 // 最多执行多少步
+	MaxCallDepth int // 函数调用最多嵌套多少层，不算最外层的主程序
+	// MaxMemory 整个执行过程累计最多分配多少字节。这是分配总量的预算，不是同时存活的内存上限：
+	// 不再使用的对象被回收后不会退还，循环里反复创建临时字符串或者数组会一直消耗预算
+	MaxMemory int64
+}
+
+type LimitKind int
+
+const (
+	LimitInstructions LimitKind = iota
+	LimitCallDepth
+	LimitDeadline // context到了截止时间
+	LimitCanceled // context被取消
+	LimitMemory
+)
+
+func (k LimitKind) String() string {
+	switch k {
+	case LimitInstructions:
+		return "instruction limit"
+	case LimitCallDepth:
+		return "call depth limit"
+	case LimitDeadline:
+		return "deadline"
+	case LimitCanceled:
+		return "cancellation"
+	case LimitMemory:
+		return "memory limit"
+	default:
+		return fmt.Sprintf("LimitKind(%d)", int(k))
+	}
+}
+
+// LimitError /**
+/*
+脚本因为触发了某项限制而被中止。Limit是触发的限制值，Deadline和Canceled时为0，Err是context返回的错误，
+所以errors.Is(err, context.DeadlineExceeded)也能判断出超时
+ */
+type LimitError struct {
+	Kind LimitKind
+	Limit int64
+	Err error
+}
+
+func (e *LimitError) Error() string {
+	switch e.Kind {
+	case LimitDeadline, LimitCanceled:
+		return fmt.Sprintf("execution stopped: %s", e.Err)
+	default:
+		return fmt.Sprintf("execution stopped: %s %d exceeded", e.Kind, e.Limit)
+	}
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+const contextCheckInterval = 1024 // 每执行这么多步检查一次context，select的开销不小，不能每步都查
+
+// Budget /**
+/*
+一次执行过程中的计数器，按Limits检查执行的步数和调用深度，并定期检查context是否已经结束。
+第一次触发限制之后一直返回同一个错误，这样即使某处吞掉了错误，接下来的执行也会马上停下来。
+nil的Budget不做任何限制
+ */
+type Budget struct {
+	ctx context.Context
+	limits Limits
+
+	steps int64
+	depth int
+	allocated int64
+	err error
+}
+
+func NewBudget(ctx context.Context, limits Limits) *Budget {
+	return &Budget{ctx: ctx, limits: limits}
+}
+
+// Step 记一步，超过步数限制或者context结束时返回*LimitError
+func (b *Budget) Step() error {
+	if b == nil {
+		return nil
+	}
+	if b.err != nil {
+		return b.err
+	}
+
+	if b.steps%contextCheckInterval == 0 && b.ctx.Done() != nil {
+		select {
+		case <-b.ctx.Done():
+			kind := LimitCanceled
+			if b.ctx.Err() == context.DeadlineExceeded {
+				kind = LimitDeadline
+			}
+			b.err = &LimitError{Kind: kind, Err: b.ctx.Err()}
+			return b.err
+		default:
+		}
+	}
+
+	b.steps++
+	if b.limits.MaxInstructions > 0 && b.steps > b.limits.MaxInstructions {
+		b.err = &LimitError{Kind: LimitInstructions, Limit: b.limits.MaxInstructions}
+	}
+	return b.err
+}
+
+// CheckDepth 检查调用深度depth是否超过限制，VM用栈帧数直接检查
+func (b *Budget) CheckDepth(depth int) error {
+	if b == nil {
+		return nil
+	}
+	if b.err == nil && b.limits.MaxCallDepth > 0 && depth > b.limits.MaxCallDepth {
+		b.err = &LimitError{Kind: LimitCallDepth, Limit: int64(b.limits.MaxCallDepth)}
+	}
+	return b.err
+}
+
+// Enter 进入一层函数调用，成功时要跟Leave成对使用
+func (b *Budget) Enter() error {
+	if b == nil {
+		return nil
+	}
+	if err := b.CheckDepth(b.depth + 1); err != nil {
+		return err
+	}
+	b.depth++
+	return nil
+}
+
+func (b *Budget) Leave() {
+	if b != nil {
+		b.depth--
+	}
+}
+
+// Alloc 记录分配了size字节，超过内存限制时返回*LimitError
+func (b *Budget) Alloc(size int64) error {
+	if b == nil {
+		return nil
+	}
+	if b.err != nil {
+		return b.err
+	}
+	b.allocated += size
+	if b.limits.MaxMemory > 0 && b.allocated > b.limits.MaxMemory {
+		b.err = &LimitError{Kind: LimitMemory, Limit: b.limits.MaxMemory}
+	}
+	return b.err
+}
+
+// LimitsMemory 是否设置了内存限制，没有设置时不用统计分配量
+func (b *Budget) LimitsMemory() bool {
+	return b != nil && b.limits.MaxMemory > 0
+}
+
+// Allocated 已经分配的字节数
+func (b *Budget) Allocated() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.allocated
+}
+
+// Steps 已经执行的步数
+func (b *Budget) Steps() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.steps
+}
+
+// Err 触发的限制，没有触发时返回nil
+func (b *Budget) Err() error {
+	if b == nil {
+		return nil
+	}
+	return b.err
+}
+
+// 估算对象大小用到的常量，按64位平台计
+const (
+	valueSize = 16 // 一个接口值，数组元素、哈希的键和值都是接口
+	headerSize = 24 // 对象本身的头部，切片、字符串、map的描述信息
+	hashEntrySize = 8 + 2*valueSize // HashKey的Value加上HashPair的键和值
+)
+
+// StringSize 长度为n的字符串对象的估算大小
+func StringSize(n int) int64 {
+	return headerSize + int64(n)
+}
+
+// ArraySize 有n个元素的数组对象的估算大小，不含元素本身
+func ArraySize(n int) int64 {
+	return headerSize + int64(n)*valueSize
+}
+
+// HashSize 有n个键值对的哈希对象的估算大小，不含键和值本身
+func HashSize(n int) int64 {
+	return headerSize + int64(n)*hashEntrySize
+}
+
+// SizeOf /**
+/*
+估算对象本身占用的字节数，只统计字符串、数组和哈希这些大小跟内容有关的对象，不递归统计元素，其他对象返回0
+ */
+func SizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *String:
+		return StringSize(len(obj.Value))
+	case *Array:
+		return ArraySize(len(obj.Elements))
+	case *Hash:
+		return HashSize(len(obj.Pairs))
+	case *Iterator:
+		// 遍历哈希时复制了一份键值对
+		return ArraySize(len(obj.pairs))
+	default:
+		return 0
+	}
+}
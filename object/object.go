@@ -6,6 +6,8 @@ import (
 	"glue/code"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"strconv"
 	"strings"
 )
 
@@ -13,6 +15,7 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ = "INTEGER"
+	FLOAT_OBJ = "FLOAT"
 	BOOLEAN_OBJ = "BOOLEAN"
 	NULL_OBJ	= "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
@@ -24,6 +27,8 @@ const (
 	HASH_OBJ = "HASH"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
 	CLOSURE_OBJ = "CLOSURE"
+	BREAK_OBJ = "BREAK"
+	CONTINUE_OBJ = "CONTINUE"
 )
 
 type Object interface {
@@ -44,6 +49,48 @@ func (i *Integer) Type() ObjectType {
 	return INTEGER_OBJ
 }
 
+type Float struct {
+	Value float64
+}
+
+// Inspect 整数值的浮点数也带上小数点，避免和Integer混淆
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") { // 排除科学计数法、Inf和NaN
+		s += ".0"
+	}
+	return s
+}
+
+func (f *Float) Type() ObjectType {
+	return FLOAT_OBJ
+}
+
+// IsNumber 判断对象是不是数值（Integer或Float），整数和浮点数可以混合运算
+func IsNumber(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *Float:
+		return true
+	default:
+		return false
+	}
+}
+
+// FloatValue /**
+/*
+取出数值对象的值，整数提升为float64，用于整数和浮点数的混合运算，不是数值的对象返回0
+ */
+func FloatValue(obj Object) float64 {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value)
+	case *Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -80,6 +127,34 @@ func (rv *ReturnValue) Inspect() string {
 	return rv.Value.Inspect()
 }
 
+// Break /**
+/*
+树遍历解释器用来把break信号从循环体内层一路传递到循环语句，跟ReturnValue的作用类似
+ */
+type Break struct {
+
+}
+
+func (b *Break) Type() ObjectType {
+	return BREAK_OBJ
+}
+
+func (b *Break) Inspect() string {
+	return "break"
+}
+
+type Continue struct {
+
+}
+
+func (c *Continue) Type() ObjectType {
+	return CONTINUE_OBJ
+}
+
+func (c *Continue) Inspect() string {
+	return "continue"
+}
+
 type Error struct {
 	Message string
 }
@@ -138,6 +213,9 @@ type BuiltinFunction func(args ...Object) Object
 type Builtin struct {
 	Fn BuiltinFunction
 	Name string
+	// Alloc 估算用这些参数调用Fn会新分配多少字节，VM在调用之前先按它记账，超出内存限制时不会调用Fn。
+	// 为nil时VM在调用之后按返回值的大小记账，返回值是参数或者参数里的元素时不算新分配
+	Alloc func(args ...Object) int64
 }
 
 func (b *Builtin) Type() ObjectType {
@@ -195,6 +273,14 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey 1 == 1.0，所以值是整数的浮点数跟对应的整数是同一个key（0.0和-0.0也都是整数0的key）
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+		return (&Integer{Value: int64(f.Value)}).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -220,7 +306,7 @@ func (h *Hash) Type() ObjectType {
 func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 	var pairs []string
-	for _, pair := range h.Pairs {
+	for _, pair := range h.SortedPairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			pair.Key.Inspect(), pair.Value.Inspect()))
 	}
@@ -231,10 +317,16 @@ func (h *Hash) Inspect() string {
 }
 
 type CompiledFunction struct {
-	Name string
+	Name string // 函数名，匿名函数为空
 	Instructions code.Instructions
 	NumLocals int
 	NumParameters int
+
+	SourceMap code.SourceMap // 指令到源码位置的映射，用于运行时报错定位
+
+	// 调试信息，按索引排列的局部变量名（包括形参）和自由变量名，不写入字节码文件，加载的函数没有
+	LocalNames []string
+	FreeNames []string
 }
 
 func (cf *CompiledFunction) Type() ObjectType {
@@ -242,7 +334,18 @@ func (cf *CompiledFunction) Type() ObjectType {
 }
 
 func (cf *CompiledFunction) Inspect() string {
-	return fmt.Sprintf("CompiledFunction[%p], %s", cf, cf.Name)
+	return fmt.Sprintf("CompiledFunction[%p], %s", cf, cf.DisplayName())
+}
+
+// DisplayName /**
+/*
+用于调用栈等需要展示函数名的地方，匿名函数没有名字
+ */
+func (cf *CompiledFunction) DisplayName() string {
+	if cf.Name == "" {
+		return "<anonymous>"
+	}
+	return cf.Name
 }
 
 // Closure /**
@@ -1,6 +1,11 @@
 package object
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -17,3 +22,167 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestFloatObject(t *testing.T) {
+	tests := []struct {
+		value float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{2, "2.0"},
+		{-0.25, "-0.25"},
+		{1e21, "1e+21"},
+	}
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %g. want=%q, got=%q", tt.value, tt.expected, f.Inspect())
+		}
+	}
+
+	if (&Float{Value: 0}).HashKey() != (&Float{Value: math.Copysign(0, -1)}).HashKey() {
+		t.Errorf("0.0 and -0.0 have different hash keys")
+	}
+	if (&Float{Value: 1}).HashKey() != (&Integer{Value: 1}).HashKey() {
+		t.Errorf("1.0 and 1 have different hash keys")
+	}
+	if (&Float{Value: 1.5}).HashKey() == (&Integer{Value: 1}).HashKey() {
+		t.Errorf("1.5 and 1 have the same hash key")
+	}
+}
+
+func TestBuiltinRegistry(t *testing.T) {
+	r := NewBuiltinRegistry()
+	double := func(args ...Object) Object {
+		return &Integer{Value: args[0].(*Integer).Value * 2}
+	}
+	if err := r.Register("double", double); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register("noop", func(args ...Object) Object { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同名注册替换函数，索引不变
+	if err := r.Register("double", double); err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 2 {
+		t.Fatalf("wrong length. want=2, got=%d", r.Len())
+	}
+	if b, index, ok := r.Lookup("noop"); !ok || index != 1 || r.At(index) != b {
+		t.Errorf("wrong lookup result. got=%v, %d, %t", b, index, ok)
+	}
+	if _, _, ok := r.Lookup("len"); ok {
+		t.Errorf("empty registry should not contain standard builtins")
+	}
+	if r.At(2) != nil || r.At(-1) != nil {
+		t.Errorf("out of range index should return nil")
+	}
+
+	// 默认注册表每次都是新的，互不影响
+	full := DefaultBuiltins()
+	if err := full.Register("double", double); err != nil {
+		t.Fatal(err)
+	}
+	if DefaultBuiltins().Len() != len(Builtins) || full.Len() != len(Builtins)+1 {
+		t.Errorf("default registries share state")
+	}
+
+	for i := r.Len(); i < MaxBuiltins; i++ {
+		if err := r.Register(fmt.Sprintf("f%d", i), double); err != nil {
+			t.Fatalf("register %d: %s", i, err)
+		}
+	}
+	if err := r.Register("overflow", double); err == nil {
+		t.Errorf("registering more than %d builtins should fail", MaxBuiltins)
+	}
+}
+
+func TestBudgetAlloc(t *testing.T) {
+	b := NewBudget(context.Background(), Limits{MaxMemory: 100})
+	if !b.LimitsMemory() || NewBudget(context.Background(), Limits{MaxInstructions: 10}).LimitsMemory() || (*Budget)(nil).LimitsMemory() {
+		t.Errorf("LimitsMemory should only be true when MaxMemory is set")
+	}
+	if err := b.Alloc(SizeOf(&String{Value: "hello"})); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if b.Allocated() != StringSize(5) {
+		t.Errorf("wrong allocated size. want=%d, got=%d", StringSize(5), b.Allocated())
+	}
+	if SizeOf(&Integer{Value: 1}) != 0 {
+		t.Errorf("integers should not be accounted")
+	}
+
+	err := b.Alloc(SizeOf(&Array{Elements: make([]Object, 10)}))
+	limitErr, ok := err.(*LimitError)
+	if !ok || limitErr.Kind != LimitMemory || limitErr.Limit != 100 {
+		t.Fatalf("expected memory limit error, got=%v", err)
+	}
+	if b.Step() != err {
+		t.Errorf("budget should keep failing after a limit is hit")
+	}
+
+	var unlimited *Budget
+	if err := unlimited.Alloc(1 << 40); err != nil {
+		t.Errorf("nil budget should not limit anything")
+	}
+}
+
+func TestHashSortedPairs(t *testing.T) {
+	pairs := map[HashKey]HashPair{}
+	for _, key := range []Hashable{
+		&String{Value: "b"}, &Integer{Value: 2}, &Float{Value: 1.5}, &Boolean{Value: true},
+		&String{Value: "a"}, &Integer{Value: -1}, &Boolean{Value: false}, &Float{Value: 0.5},
+	} {
+		pairs[key.HashKey()] = HashPair{Key: key.(Object), Value: &Null{}}
+	}
+	hash := &Hash{Pairs: pairs}
+
+	expected := "{false: null, true: null, -1: null, 0.5: null, 1.5: null, 2: null, a: null, b: null}"
+	for i := 0; i < 5; i++ {
+		if hash.Inspect() != expected {
+			t.Fatalf("wrong Inspect. want=%q, got=%q", expected, hash.Inspect())
+		}
+	}
+}
+
+func TestIterator(t *testing.T) {
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	for i, k := range []string{"y", "x"} {
+		key := &String{Value: k}
+		hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: &Integer{Value: int64(i)}}
+	}
+
+	tests := []struct {
+		source Object
+		expected []string // 每个元素是 key:value，后面是只有一个循环变量时的值
+	}{
+		{&Array{Elements: []Object{&Integer{Value: 5}, &String{Value: "s"}}}, []string{"0:5 5", "1:s s"}},
+		{hash, []string{"x:1 x", "y:0 y"}},
+		{&String{Value: "aé"}, []string{"0:a a", "1:é é"}},
+		{&String{Value: ""}, nil},
+	}
+
+	for _, tt := range tests {
+		it, err := NewIterator(tt.source)
+		if err != nil {
+			t.Fatalf("NewIterator(%s): %s", tt.source.Inspect(), err)
+		}
+		var got []string
+		for {
+			key, value, ok := it.Next()
+			if !ok {
+				break
+			}
+			got = append(got, fmt.Sprintf("%s:%s %s", key.Inspect(), value.Inspect(), it.Item(key, value).Inspect()))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+			t.Errorf("iterating %s: want=%v, got=%v", tt.source.Inspect(), tt.expected, got)
+		}
+	}
+
+	if _, err := NewIterator(&Integer{Value: 1}); err == nil || err.Error() != "cannot iterate over INTEGER" {
+		t.Errorf("wrong error for INTEGER: %v", err)
+	}
+}
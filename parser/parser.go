@@ -16,6 +16,8 @@ const (
 	_ int = iota
 	LOWEST
 	ASSIGN
+	LOGICALOR // ||
+	LOGICALAND // &&
 	EQUALS // ==
 	LESSGREATER // > or <
 	SUM // +
@@ -31,10 +33,15 @@ var precedences = map[token.TokenType]int{
 	token.NOT_EQ: EQUALS,
 	token.LT: LESSGREATER,
 	token.GT: LESSGREATER,
+	token.LT_EQ: LESSGREATER,
+	token.GT_EQ: LESSGREATER,
+	token.AND: LOGICALAND,
+	token.OR: LOGICALOR,
 	token.PLUS: SUM,
 	token.MINUS: SUM,
 	token.SLASH: PRODUCT,
 	token.ASTERISK: PRODUCT,
+	token.PERCENT: PRODUCT,
 	token.LPAREN: CALL,
 	token.LBRACKET: INDEX,
 }
@@ -46,6 +53,7 @@ func getNodeIndex() int64 {
 	return NodeIndex
 }
 
+// ParseError 一个语法错误。词法错误也转成ParseError，它们的Token为nil
 type ParseError struct {
 	Token *token.Token
 	LineNum int
@@ -56,20 +64,19 @@ type ParseError struct {
 func (pe *ParseError) String() string {
 	var builder strings.Builder
 	var lineNum, colNum int
-	var tokenInfo string
 
 	builder.Grow(200) // 初始化一下，避免过多内存分配次数
 
-	if pe.Token != nil {
-		tokenInfo = pe.Token.Literal
-	}
 	lineNum = pe.LineNum
 	colNum = pe.ColNum
 	builder.Grow(100)
 	builder.WriteString(pe.msg)
-	builder.WriteString("[Token:")
-	builder.WriteString(tokenInfo)
-	builder.WriteString("]")
+	// 词法错误没有token，格式跟lexer报告的一样
+	if pe.Token != nil {
+		builder.WriteString("[Token:")
+		builder.WriteString(pe.Token.Literal)
+		builder.WriteString("]")
+	}
 	builder.WriteString("[")
 	builder.WriteString(string(strconv.Itoa(lineNum)))
 	builder.WriteString(":")
@@ -79,6 +86,11 @@ func (pe *ParseError) String() string {
 	return builder.String()
 }
 
+// Message 不带token和位置信息的错误描述
+func (pe *ParseError) Message() string {
+	return pe.msg
+}
+
 func (pe *ParseError) Error() string {
 	errorInfo := fmt.Sprintf("parse error, token: %#v, line: %d, error descrition: %s\n",
 		pe.Token, pe.LineNum, pe.msg)
@@ -89,6 +101,7 @@ func (pe *ParseError) Error() string {
 type Parser struct {
 	l *lexer.Lexer
 	errors []string
+	parseErrors []*ParseError // 跟errors一一对应，保留了位置
 
 	curToken token.Token
 	peekToken token.Token
@@ -98,6 +111,11 @@ type Parser struct {
 
 	currLineNum int
 	currColNum int
+
+	loopDepth int // 当前所处的循环嵌套层数，用来检查break/continue是否出现在循环体中
+
+	braceDepth int // curToken之前还没有闭合的左花括号个数，出错后同步时用来判断是不是回到了出错语句所在的块
+	panicking bool // 当前语句已经出错，同步到下一条语句之前不再记录新的错误
 }
 
 type (
@@ -146,6 +164,11 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 	//p.registerInfix(token.ASSIGN, p.parseAssignExpression)
@@ -169,9 +192,146 @@ func (p *Parser) parseWhileStatement() *ast.WhileStatement {
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
+	p.loopDepth++
+	stmt.Body = p.parseBlockStatement()
+	p.loopDepth--
+	return stmt
+}
+
+// parseForStatement /**
+/*
+括号里以"标志符 in"或者"标志符, 标志符 in"开头的是for-in循环，否则是C风格的for循环
+ */
+func (p *Parser) parseForStatement() ast.Statement {
+	tok := p.curToken
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+
+	if p.curTokenIs(token.IDENT) && (p.peekTokenIs(token.IN) || p.peekTokenIs(token.COMMA)) {
+		return p.parseForInStatement(tok)
+	}
+	return p.parseForClauses(tok)
+}
+
+func (p *Parser) parseForInStatement(tok token.Token) *ast.ForInStatement {
+	stmt := &ast.ForInStatement{Token: tok, Id: getNodeIndex()}
+	stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal, Id: getNodeIndex()}
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Key = stmt.Value
+		stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal, Id: getNodeIndex()}
+	}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+	if stmt.Iterable == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	p.loopDepth++
+	stmt.Body = p.parseBlockStatement()
+	p.loopDepth--
+	return stmt
+}
+
+// parseForClauses /**
+/*
+C风格for循环括号里的三部分，当前token是左括号后面的第一个token。
+init可以是let语句、赋值或者表达式，post可以是赋值或者表达式，它们自己会吃掉后面的分号
+ */
+func (p *Parser) parseForClauses(tok token.Token) *ast.ForStatement {
+	stmt := &ast.ForStatement{Token: tok, Id: getNodeIndex()}
+
+	if !p.curTokenIs(token.SEMICOLON) {
+		stmt.Init = p.parseForClause(true)
+		if stmt.Init == nil || reflect.ValueOf(stmt.Init).IsNil() {
+			return nil
+		}
+		if !p.curTokenIs(token.SEMICOLON) {
+			p.peekError(token.SEMICOLON)
+			return nil
+		}
+	}
+
+	p.nextToken()
+	if !p.curTokenIs(token.SEMICOLON) {
+		stmt.Condition = p.parseExpression(LOWEST)
+		if stmt.Condition == nil || !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	p.nextToken()
+	if !p.curTokenIs(token.RPAREN) {
+		stmt.Post = p.parseForClause(false)
+		if stmt.Post == nil || reflect.ValueOf(stmt.Post).IsNil() || !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	p.loopDepth++
 	stmt.Body = p.parseBlockStatement()
+	p.loopDepth--
 	return stmt
 }
+
+func (p *Parser) parseForClause(init bool) ast.Statement {
+	switch {
+	case init && p.curTokenIs(token.LET):
+		return p.parseLetStatement()
+	case p.curTokenIs(token.IDENT) && p.peekIsAssign():
+		return p.parseAssignStatement()
+	default:
+		return p.parseExpressionStatement()
+	}
+}
+
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{Token: p.curToken, Id: getNodeIndex()}
+	if p.loopDepth == 0 {
+		p.loopControlError(p.curToken)
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	stmt.End = p.curToken.End
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{Token: p.curToken, Id: getNodeIndex()}
+	if p.loopDepth == 0 {
+		p.loopControlError(p.curToken)
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	stmt.End = p.curToken.End
+	return stmt
+}
+
+// loopControlError 语句本身是完整的，不需要进入panic模式
+func (p *Parser) loopControlError(t token.Token) {
+	p.addError(t, "%s statement not within a loop.", t.Literal)
+}
 /*
 func (p* Parser) parseAssignExpression(left ast.Expression) ast.Expression {
 	assign := &ast.AssignExpression{Token:p.curToken, Name: left, Operator: p.curToken.Literal, Id: getNodeIndex()}
@@ -210,6 +370,7 @@ func (p *Parser) parseHashLiteral2() ast.Expression {
 
 	if p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
+		hash.End = p.curToken.End
 		return hash
 	}
 
@@ -226,6 +387,7 @@ func (p *Parser) parseHashLiteral2() ast.Expression {
 		p.nextToken()
 		goto label
 	}else if p.expectPeek(token.RBRACE) {
+		hash.End = p.curToken.End
 		return hash
 	}else {
 		return nil
@@ -242,6 +404,7 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.End = p.curToken.End
 	return exp
 }
 
@@ -250,6 +413,7 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 
 	array.Elements = p.parseExpressionList(token.RBRACKET)
 
+	array.End = p.curToken.End
 	return array
 }
 
@@ -280,6 +444,11 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// ParseErrors 跟Errors一样的错误，带有出错的token和位置，不用再从错误信息里解析位置
+func (p *Parser) ParseErrors() []*ParseError {
+	return p.parseErrors
+}
+
 func (p *Parser) HasError() bool {
 	if len(p.errors) > 0 {
 		return true
@@ -294,22 +463,59 @@ func (p *Parser) ReportParseErrors() {
 	}
 }
 
-func (p *Parser) addParseError(pErr *ParseError) {
-	pErr.LineNum = p.l.CurrLineNum
-	pErr.ColNum = p.l.CurrColNum
-
+// addError /**
+/*
+记录一个语法错误，位置取出错的token本身的位置，而不是词法分析器当前读到的位置（它已经在peekToken之后了）。
+当前语句已经出错时不再记录，后面的错误多半是第一个错误引起的
+ */
+func (p *Parser) addError(t token.Token, format string, args ...interface{}) {
+	if p.panicking {
+		return
+	}
+	pErr := &ParseError{
+		Token: &t,
+		LineNum: t.LineNum,
+		ColNum: t.ColumnNum,
+		msg: fmt.Sprintf(format, args...),
+	}
 	p.errors = append(p.errors, pErr.String())
+	p.parseErrors = append(p.parseErrors, pErr)
+}
+
+// errorAt 记录错误并进入panic模式，当前语句剩下的部分已经没法正确解析，由parseStatementWithRecovery同步到下一条语句
+func (p *Parser) errorAt(t token.Token, format string, args ...interface{}) {
+	p.addError(t, format, args...)
+	p.panicking = true
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	pErr := new(ParseError)
-	pErr.Token = &p.curToken
-	pErr.LineNum = p.l.CurrLineNum
-	pErr.ColNum = p.l.CurrColNum
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead.", t, p.peekToken.Type)
-	pErr.msg = msg
+	p.errorAt(p.peekToken, "expected %s, found %s.", describeType(t), describeToken(p.peekToken))
+}
 
-	p.errors = append(p.errors, pErr.String())
+// describeType 错误信息里期望的token
+func describeType(t token.TokenType) string {
+	if t == token.IDENT {
+		return "identifier"
+	}
+	return string(t)
+}
+
+// describeToken 错误信息里实际遇到的token
+func describeToken(t token.Token) string {
+	switch t.Type {
+	case token.EOF:
+		return "end of file"
+	case token.IDENT:
+		return "identifier " + t.Literal
+	case token.INT, token.FLOAT:
+		return "number " + t.Literal
+	case token.STRING:
+		return fmt.Sprintf("string %q", t.Literal)
+	case token.ILLEGAL:
+		return fmt.Sprintf("illegal character %q", t.Literal)
+	default:
+		return t.Literal
+	}
 }
 
 func (p *Parser) curTokenIs(t token.TokenType) bool {
@@ -327,6 +533,14 @@ func (p *Parser) peekTokenIs(t token.TokenType) bool {
 向前走一步
 */
 func (p *Parser) nextToken() {
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.braceDepth++
+	case token.RBRACE:
+		if p.braceDepth > 0 {
+			p.braceDepth--
+		}
+	}
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
 }
@@ -357,25 +571,86 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = [] ast.Statement{}
 
 	for !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil && !reflect.ValueOf(stmt).IsNil(){
-			//fmt.Printf("I am not nil, my value type is:%T,\n my value is %#v\n", stmt, stmt)
-			//fmt.Printf("I am not nil, my value type is:%T,\n my value is %#v\n", stmt, stmt)
-			//fmt.Println("I am nil", stmt)
-
-			//fmt.Printf("I am not nil, I am %#v,my value is %s",stmt, stmt.String())
+		if stmt := p.parseStatementWithRecovery(); stmt != nil {
 			program.Statements = append(program.Statements, stmt)
+		}
+	}
 
-		}else{
-			pErr := new(ParseError)
-			pErr.msg = fmt.Sprintf("can't parse statement.")
-			p.addParseError(pErr)
+	// 词法错误不会中断解析，最后一起报告
+	for _, err := range p.l.LexErrors() {
+		pErr := &ParseError{LineNum: err.LineNum, ColNum: err.ColNum, msg: err.Msg}
+		p.errors = append(p.errors, pErr.String())
+		p.parseErrors = append(p.parseErrors, pErr)
+	}
+
+	return program
+}
+
+// parseStatementWithRecovery /**
+/*
+解析一条语句，成功时curToken移到下一条语句的开头。
+语句出错时丢掉这条语句，在panic模式下往后跳过token，直到回到出错语句所在的块（花括号层数相同）并且遇到：
+分号（跳过它）、块结束的右花括号，或者下一条语句开头的关键字。这样一个错误只报告一次，不会引起后面一连串的错误。
+嵌套的块里出错的语句在块内部就同步好了，不影响外层的语句
+ */
+func (p *Parser) parseStatementWithRecovery() ast.Statement {
+	// 多余的分号当作空语句，比如函数定义和while语句后面习惯性加上的分号
+	if p.curTokenIs(token.SEMICOLON) {
+		p.nextToken()
+		return nil
+	}
+
+	start, depth := p.curToken, p.braceDepth
+	panicking := p.panicking
+	p.panicking = false
+	defer func() {
+		p.panicking = panicking
+	}()
+
+	errors := len(p.errors)
+	stmt := p.parseStatement()
+	if stmt != nil && !reflect.ValueOf(stmt).IsNil() && !p.panicking {
+		p.nextToken()
+		return stmt
+	}
+
+	if len(p.errors) == errors {
+		p.errorAt(start, "can't parse statement starting with %s.", describeToken(start))
+	}
+	p.synchronize(start, depth)
+	return nil
+}
 
-			//fmt.Println("I am nil", stmt)
+func (p *Parser) synchronize(start token.Token, depth int) {
+	// 出错的语句一个token都没有读的话至少跳过一个，否则会在原地反复出错
+	if p.curToken == start {
+		p.nextToken()
+	}
+	for !p.curTokenIs(token.EOF) {
+		if p.braceDepth == depth {
+			switch {
+			case p.curTokenIs(token.SEMICOLON):
+				p.nextToken()
+				return
+			case p.curTokenIs(token.RBRACE) && depth > 0:
+				return
+			case p.isStatementStart():
+				return
+			}
 		}
 		p.nextToken()
 	}
-	return program
+}
+
+// isStatementStart curToken是只能出现在语句开头的关键字
+func (p *Parser) isStatementStart() bool {
+	switch p.curToken.Type {
+	case token.LET, token.RETURN, token.WHILE, token.FOR, token.BREAK, token.CONTINUE:
+		return true
+	case token.FUNCTION:
+		return p.peekTokenIs(token.IDENT)
+	}
+	return false
 }
 
 func (p *Parser) parseStatement() ast.Statement {
@@ -386,19 +661,39 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseReturnStatement()
 	case token.WHILE:
 		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
 	case token.FUNCTION:
 		if p.peekTokenIs(token.IDENT) {
 			return p.parseFunctionDefinitionStatement()
 		}
 		return p.parseExpressionStatement()
 	default:
-		if p.peekTokenIs(token.ASSIGN) {
+		if p.curTokenIs(token.IDENT) && p.peekIsAssign() {
 			return p.parseAssignStatement()
 		}
 		return p.parseExpressionStatement()
 	}
 }
 
+// 赋值运算符，复合赋值a += b相当于a = a + b，只是=左边的目标只求一次值
+var assignOperators = map[token.TokenType]bool{
+	token.ASSIGN: true,
+	token.PLUS_ASSIGN: true,
+	token.MINUS_ASSIGN: true,
+	token.ASTERISK_ASSIGN: true,
+	token.SLASH_ASSIGN: true,
+	token.PERCENT_ASSIGN: true,
+}
+
+func (p *Parser) peekIsAssign() bool {
+	return assignOperators[p.peekToken.Type]
+}
+
 func (p *Parser) parseAssignStatement() *ast.AssignStatement {
 	stmt := &ast.AssignStatement{Token: p.curToken, Id: getNodeIndex()}
 
@@ -410,10 +705,11 @@ func (p *Parser) parseAssignStatement() *ast.AssignStatement {
 	stmt.Lhs = lhs
 	//as := p.parseAssignExpression(left)
 
-	if p.expectPeek(token.ASSIGN) {
-		p.nextToken()
-		stmt.Rhs = p.parseExpression(LOWEST)
-	}else {
+	p.nextToken()
+	stmt.Operator = p.curToken.Literal
+	p.nextToken()
+	stmt.Rhs = p.parseExpression(LOWEST)
+	if stmt.Rhs == nil {
 		return nil
 	}
 
@@ -421,18 +717,50 @@ func (p *Parser) parseAssignStatement() *ast.AssignStatement {
 		p.nextToken()
 	}
 
+	stmt.End = p.curToken.End
 	return stmt
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+// parseExpressionStatement 表达式后面跟着赋值运算符的是索引赋值语句，只有索引表达式可以出现在左边（变量赋值由parseAssignStatement处理）
+func (p *Parser) parseExpressionStatement() ast.Statement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken, Id: getNodeIndex()}
 
 	stmt.Expression = p.parseExpression(LOWEST)
 
+	if stmt.Expression != nil && p.peekIsAssign() {
+		target, ok := stmt.Expression.(*ast.IndexExpression)
+		if !ok {
+			p.errorAt(p.peekToken, "cannot assign to %s.", stmt.Expression.String())
+			return nil
+		}
+		return p.parseIndexAssignStatement(stmt.Token, target)
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		// 表达式后的;不是必须的，有的话就吃掉，没有也不会报错
 		p.nextToken() // consume the current ';'
 	}
+	stmt.End = p.curToken.End
+	return stmt
+}
+
+// parseIndexAssignStatement 当前token是赋值运算符左边索引表达式的最后一个token
+func (p *Parser) parseIndexAssignStatement(start token.Token, target *ast.IndexExpression) *ast.IndexAssignStatement {
+	stmt := &ast.IndexAssignStatement{Token: start, Target: target, Id: getNodeIndex()}
+
+	p.nextToken()
+	stmt.Operator = p.curToken.Literal
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	stmt.End = p.curToken.End
 	return stmt
 }
 
@@ -442,6 +770,8 @@ func (p *Parser) parseEpsilonExpression() ast.Expression {
 		return p.parseIdentifier()
 	case token.INT:
 		return p.parseIntegerLiteral()
+	case token.FLOAT:
+		return p.parseFloatLiteral()
 	case token.TRUE:
 		return p.parseBoolean()
 	case token.FALSE:
@@ -465,15 +795,7 @@ func (p *Parser) parseEpsilonExpression() ast.Expression {
 }
 
 func (p *Parser) parseEpsilonExpressionError(t token.Token) {
-	msg := fmt.Sprintf("unexpected token %s[%s] for espilon expression.", t.Literal, t.Type)
-
-	pErr := new(ParseError)
-	pErr.Token = &p.curToken
-	pErr.LineNum = p.l.CurrLineNum
-	pErr.ColNum = p.l.CurrColNum
-	pErr.msg = msg
-
-	p.errors = append(p.errors, pErr.String())
+	p.errorAt(t, "expected expression, found %s.", describeToken(t))
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
@@ -486,6 +808,9 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	}else {
 		leftExp = p.parseEpsilonExpression()
 	}
+	if leftExp == nil || reflect.ValueOf(leftExp).IsNil() {
+		return nil
+	}
 
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
@@ -532,8 +857,21 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("Could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorAt(p.curToken, "could not parse %q as integer.", p.curToken.Literal)
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken, Id: getNodeIndex()}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.errorAt(p.curToken, "could not parse %q as float.", p.curToken.Literal)
 		return nil
 	}
 
@@ -552,6 +890,7 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	for p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
+	stmt.End = p.curToken.End
 	return stmt
 }
 
@@ -569,7 +908,7 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 		if p.peekTokenIs(token.SEMICOLON) { // 此处看做是声明 let a;用户没有赋值。
 			stmt.Value = nil
 			p.nextToken()
-			p.nextToken()
+			stmt.End = p.curToken.End
 
 			return stmt
 		}
@@ -596,6 +935,7 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 		p.nextToken()
 	}
 
+	stmt.End = p.curToken.End
 	return stmt
 }
 
@@ -650,6 +990,16 @@ func (p *Parser) parseIfExpression() ast.Expression {
 
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
+		// else if直接作为这个if的下一个分支，不用再套一层花括号
+		if p.peekTokenIs(token.IF) {
+			p.nextToken()
+			elseIf, ok := p.parseIfExpression().(*ast.IfExpression)
+			if !ok {
+				return nil
+			}
+			expression.ElseIf = elseIf
+			return expression
+		}
 		if !p.expectPeek(token.LBRACE){
 			return nil
 		}
@@ -664,13 +1014,19 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block.Statements = []ast.Statement{}
 	p.nextToken()
 	for !p.curTokenIs(token.RBRACE) {
-		stmt := p.parseStatement()
-		if stmt != nil {
+		// 缺少右花括号时不能一直读下去，编辑器里边输入边解析，这种情况很常见。
+		// 已经解析出来的语句都是完整的，不进入panic模式，外层的语句照常保留
+		if p.curTokenIs(token.EOF) {
+			p.addError(p.curToken, "expected } to close the block opened at %d:%d, found end of file.",
+				block.Token.LineNum, block.Token.ColumnNum)
+			break
+		}
+		if stmt := p.parseStatementWithRecovery(); stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
-		p.nextToken()
 	}
 
+	block.End = p.curToken.End
 	return block
 }
 
@@ -685,11 +1041,23 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Body = p.parseBlockStatement()
+	lit.Body = p.parseFunctionBody()
 
 	return lit
 }
 
+/*
+函数体是一个新的边界，外层的循环对函数体内部不可见，所以解析函数体时把循环层数清零，解析完再恢复
+ */
+func (p *Parser) parseFunctionBody() *ast.BlockStatement {
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
+	body := p.parseBlockStatement()
+	p.loopDepth = loopDepth
+
+	return body
+}
+
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	//identifiers := [] *ast.Identifier{}
 	var identifiers []*ast.Identifier
@@ -699,7 +1067,9 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 		return identifiers
 	}
 
-	p.nextToken()
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
 	ident := &ast.Identifier{
 		Token: p.curToken,
 		Value: p.curToken.Literal,
@@ -709,7 +1079,9 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
-		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
 		ident := &ast.Identifier{
 			Token: p.curToken,
 			Value: p.curToken.Literal,
@@ -730,6 +1102,7 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function, Id: getNodeIndex()}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
 
+	exp.End = p.curToken.End
 	return exp
 }
 
@@ -777,13 +1150,17 @@ func (p *Parser) parseFunctionDefinitionStatement() *ast.FunctionDefinitionState
 		Value: p.curToken.Literal,
 		Id: getNodeIndex(),
 	}
-	p.nextToken()
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
 
 	params := p.parseFunctionParameters()
 	fnLiteral.Parameters = params
 
-	p.nextToken()
-	body := p.parseBlockStatement()
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	body := p.parseFunctionBody()
 	fnLiteral.Body = body
 
 	fnStatement := &ast.FunctionDefinitionStatement{
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"glue/ast"
 	"glue/lexer"
+	"strings"
 	"testing"
 )
 
@@ -246,6 +247,40 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e3;", 1000},
+		{"2.5E-1;", 0.25},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has not enough statements. got=%d",
+				len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input string
@@ -374,6 +409,22 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"!-a",
 			"(!(-a))",
 		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a * b % c",
+			"((a * b) % c)",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a == 1 && b % 2 != 0 || !c",
+			"(((a == 1) && ((b % 2) != 0)) || (!c))",
+		},
 		{
 			"a + b + c",
 			"((a + b) + c)",
@@ -530,6 +581,63 @@ func TestIfExpression(t *testing.T) {
 
 }
 
+func TestElseIfExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+		branches int // else if链上if的个数
+		alternative bool
+	}{
+		{"if (a) { 1 } else if (b) { 2 }", "ifa 1else ifb 2", 2, false},
+		{"if (a) { 1 } else if (b) { 2 } else { 3 }", "ifa 1else ifb 2else 3", 2, true},
+		{"if (a) { 1 } else if (b) { 2 } else if (c) { 3 } else { 4 }", "ifa 1else ifb 2else ifc 3else 4", 3, true},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements doesn't contain 1 statement. got=%d", len(program.Statements))
+		}
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.IfExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.IfExpression. got=%T", stmt.Expression)
+		}
+		if exp.String() != tt.expected {
+			t.Errorf("wrong expression. expected=%q, got=%q", tt.expected, exp.String())
+		}
+		if span := exp.Span(); tt.input[span.Start.Offset:span.End.Offset] != tt.input {
+			t.Errorf("wrong span %s for %q", span, tt.input)
+		}
+
+		branches := 1
+		for ; exp.ElseIf != nil; exp = exp.ElseIf {
+			if exp.Alternative != nil {
+				t.Errorf("if with else if should not have an alternative block")
+			}
+			branches++
+		}
+		if branches != tt.branches {
+			t.Errorf("wrong number of branches. expected=%d, got=%d", tt.branches, branches)
+		}
+		if (exp.Alternative != nil) != tt.alternative {
+			t.Errorf("wrong alternative of the last branch. expected=%t, got=%t", tt.alternative, exp.Alternative != nil)
+		}
+	}
+
+	for _, input := range []string{"if (a) { 1 } else if { 2 }", "if (a) { 1 } else if (b) 2", "if (a) { 1 } else while (b) { }"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if !p.HasError() {
+			t.Errorf("input %q: expected parser errors", input)
+		}
+	}
+}
+
 func TestIfElseExpression(t *testing.T) {
 	input := `if (x < y) { x } else { y }`
 
@@ -653,6 +761,22 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestInvalidEscapeSequence(t *testing.T) {
+	l := lexer.New(`let s = "a\qb"; s;`)
+	p := New(l)
+	program := p.ParseProgram()
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 error, got=%d %q", len(errors), errors)
+	}
+	if !strings.Contains(errors[0], `invalid escape sequence "\\q"`) {
+		t.Errorf("wrong error message. got=%q", errors[0])
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 	l := lexer.New(input)
@@ -847,4 +971,399 @@ func TestFunctionLiteralWithName(t *testing.T) {
 		t.Fatalf("function literal name wrong. want 'myFunction', got=%q\n",
 			function.Name)
 	}
-}
\ No newline at end of file
+}
+func TestBreakAndContinueStatements(t *testing.T) {
+	input := `
+while (true) {
+	continue;
+	while (false) { break }
+	break;
+}
+`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements doesn't contain 1 statement. got=%d", len(program.Statements))
+	}
+	loop, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.WhileStatement. got=%T", program.Statements[0])
+	}
+	if len(loop.Body.Statements) != 3 {
+		t.Fatalf("loop body doesn't contain 3 statements. got=%d", len(loop.Body.Statements))
+	}
+	if _, ok := loop.Body.Statements[0].(*ast.ContinueStatement); !ok {
+		t.Errorf("stmt not *ast.ContinueStatement. got=%T", loop.Body.Statements[0])
+	}
+	inner := loop.Body.Statements[1].(*ast.WhileStatement)
+	if _, ok := inner.Body.Statements[0].(*ast.BreakStatement); !ok {
+		t.Errorf("stmt not *ast.BreakStatement. got=%T", inner.Body.Statements[0])
+	}
+	if _, ok := loop.Body.Statements[2].(*ast.BreakStatement); !ok {
+		t.Errorf("stmt not *ast.BreakStatement. got=%T", loop.Body.Statements[2])
+	}
+}
+
+func TestIndexAssignStatements(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+	}{
+		{"a[0] = 1", "a[0] = 1;"},
+		{`h["k"] = v + 1;`, "h[k] = (v + 1);"},
+		{"a[i][j + 1] = f(x)", "(a[i])[(j + 1)] = f(x);"},
+		{"get()[0] = [1, 2];", "get()[0] = [1, 2];"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements doesn't contain 1 statement. got=%d", len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.IndexAssignStatement)
+		if !ok {
+			t.Fatalf("stmt not *ast.IndexAssignStatement. got=%T", program.Statements[0])
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("wrong statement. expected=%q, got=%q", tt.expected, stmt.String())
+		}
+		if span := stmt.Span(); tt.input[span.Start.Offset:span.End.Offset] != tt.input {
+			t.Errorf("wrong span %s for %q", span, tt.input)
+		}
+	}
+}
+
+func TestCompoundAssignStatements(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+	}{
+		{"i += 1", "i += 1;"},
+		{"x -= y * 2;", "x -= (y * 2);"},
+		{"s *= 2 /= 3", ""},
+		{"a[0] /= 2", "a[0] /= 2;"},
+		{"h[k][1] %= n + 1;", "(h[k])[1] %= (n + 1);"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		if tt.expected == "" {
+			// 赋值不是表达式，不能连写
+			if !p.HasError() {
+				t.Errorf("input %q: expected parse errors", tt.input)
+			}
+			continue
+		}
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements doesn't contain 1 statement. got=%d", len(program.Statements))
+		}
+		if program.Statements[0].String() != tt.expected {
+			t.Errorf("wrong statement. expected=%q, got=%q", tt.expected, program.Statements[0].String())
+		}
+	}
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []struct {
+		input string
+		expected string
+	}{
+		{"for (let i = 0; i < 10; i += 1) { x }", "for (let i = 0; (i < 10); i += 1) {\nx\n}"},
+		{"for (i = 0; i < n; i = i + 1) { continue; }", "for (i = 0; (i < n); i = (i + 1)) {\ncontinue;\n}"},
+		{"for (;;) { break }", "for (; ; ) {\nbreak;\n}"},
+		{"for (; ok;) { }", "for (; ok; ) {\n\n}"},
+		{"for (f(); ; a[0] += 1) { }", "for (f(); ; a[0] += 1) {\n\n}"},
+		{"for (x in [1, 2]) { x }", "for (x in [1, 2]) {\nx\n}"},
+		{"for (k, v in h) { k; v }", "for (k, v in h) {\nkv\n}"},
+		{"for (c in \"abc\") { break; }", "for (c in abc) {\nbreak;\n}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements doesn't contain 1 statement. got=%d", len(program.Statements))
+		}
+		stmt := program.Statements[0]
+		switch stmt.(type) {
+		case *ast.ForStatement, *ast.ForInStatement:
+		default:
+			t.Fatalf("stmt not a for statement. got=%T", stmt)
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("wrong statement. expected=%q, got=%q", tt.expected, stmt.String())
+		}
+		if span := stmt.Span(); tt.input[span.Start.Offset:span.End.Offset] != tt.input {
+			t.Errorf("wrong span %s for %q", span, tt.input)
+		}
+	}
+}
+
+func TestForStatementErrors(t *testing.T) {
+	tests := []string{
+		"for i < 10 { }",
+		"for (let i = 0) { }",
+		"for (let i = 0; i < 10) { }",
+		"for (i; i < 10; let j = 1) { }",
+		"for (k, in h) { }",
+		"for (k, v h) { }",
+		"for (1 in h) { }",
+		"for (x in h) x",
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+		p.ParseProgram()
+
+		if !p.HasError() {
+			t.Errorf("input %q: expected parser errors", input)
+		}
+	}
+}
+
+func TestBreakAndContinueOutsideLoop(t *testing.T) {
+	tests := []string{
+		"break;",
+		"continue;",
+		"if (true) { break; }",
+		"while (true) { let f = fn() { break; }; }",
+		"while (true) { fn f() { continue; } }",
+		"for (x in a) { } break;",
+		"for (;;) { let f = fn() { continue; }; }",
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+		p.ParseProgram()
+
+		if len(p.Errors()) != 1 {
+			t.Errorf("input %q: expected 1 parser error, got=%d (%q)", input, len(p.Errors()), p.Errors())
+		}
+	}
+}
+
+func TestUnclosedBlock(t *testing.T) {
+	tests := []string{
+		"if (x) { let a = 1;",
+		"fn add(a, b) { a + b",
+		"let f = fn(x) { while (x) { x",
+		"while (true) {",
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+		p.ParseProgram()
+
+		if !p.HasError() {
+			t.Errorf("input %q: expected parser errors", input)
+			continue
+		}
+		found := false
+		for _, msg := range p.Errors() {
+			if strings.HasPrefix(msg, "expected } to close the block") {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("input %q: missing unclosed block error, got=%q", input, p.Errors())
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input string
+		expectedErrors []string
+		expectedStatements int
+	}{
+		{
+			"let a = 1;\nlet = 2;\nlet b = (a + ;\nprint(a b);\nlet c = 3;",
+			[]string{
+				"expected identifier, found =.[Token:=][2:5]",
+				"expected expression, found ;.[Token:;][3:14]",
+				"expected ), found identifier b.[Token:b][4:9]",
+			},
+			2,
+		},
+		// 出错的语句跳到分号为止，下一行的语句不受影响
+		{
+			"let h = {\"a\" 1, \"b\": 2}; let x = h;",
+			[]string{"expected :, found number 1.[Token:1][1:14]"},
+			1,
+		},
+		// 只有变量和索引表达式可以被赋值
+		{
+			"f() = 1; let x = 2; a[0] = ;",
+			[]string{
+				"cannot assign to f().[Token:=][1:5]",
+				"expected expression, found ;.[Token:;][1:28]",
+			},
+			1,
+		},
+		// 没有分号时同步到下一条语句开头的关键字
+		{
+			"let x = let y = 2\nfn f(a, 1) { a }\nwhile (y) { break }",
+			[]string{
+				"expected expression, found let.[Token:let][1:9]",
+				"expected identifier, found number 1.[Token:1][2:9]",
+			},
+			2,
+		},
+		// 块里的错误在块内部同步，外层的语句照常解析
+		{
+			"fn f(x) {\n    let c = x +* 2;\n    return c;\n}\nf(1);",
+			[]string{"expected expression, found *.[Token:*][2:16]"},
+			2,
+		},
+		{
+			"if (x) { let = 1; } else { return ) }",
+			[]string{
+				"expected identifier, found =.[Token:=][1:14]",
+				"expected expression, found ).[Token:)][1:35]",
+			},
+			1,
+		},
+		{
+			"} let a = 1;",
+			[]string{"expected expression, found }.[Token:}][1:1]"},
+			1,
+		},
+		{
+			"let a = \"x\" + @ + 1;",
+			[]string{"expected expression, found illegal character \"@\".[Token:@][1:15]"},
+			0,
+		},
+		// 多余的分号是空语句，不算错误
+		{
+			"fn f() { 1 }; while (false) {};;",
+			nil,
+			2,
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("input %q: wrong number of errors. want=%d, got=%d (%q)", tt.input, len(tt.expectedErrors), len(errors), errors)
+			continue
+		}
+		for i, expected := range tt.expectedErrors {
+			if errors[i] != expected {
+				t.Errorf("input %q: wrong error %d. want=%q, got=%q", tt.input, i, expected, errors[i])
+			}
+		}
+		if len(program.Statements) != tt.expectedStatements {
+			t.Errorf("input %q: wrong number of statements. want=%d, got=%d", tt.input, tt.expectedStatements, len(program.Statements))
+		}
+	}
+}
+
+// TestParseErrors 结构化的错误跟Errors一一对应，词法错误没有token
+func TestParseErrors(t *testing.T) {
+	p := New(lexer.New("let = 2;\nlet s = \"\\q\";"))
+	p.ParseProgram()
+
+	errors := p.ParseErrors()
+	if len(errors) != 2 || len(p.Errors()) != 2 {
+		t.Fatalf("wrong errors: %q", p.Errors())
+	}
+	for i, err := range errors {
+		if err.String() != p.Errors()[i] {
+			t.Errorf("error %d does not match. want=%q, got=%q", i, p.Errors()[i], err.String())
+		}
+	}
+
+	if err := errors[0]; err.Token == nil || err.Token.Literal != "=" || err.LineNum != 1 || err.ColNum != 5 ||
+		err.Message() != "expected identifier, found =." {
+		t.Errorf("wrong parse error: %+v", err)
+	}
+	if err := errors[1]; err.Token != nil || err.LineNum != 2 || err.ColNum != 10 || err.Message() != `invalid escape sequence "\\q"` {
+		t.Errorf("wrong lexical error: %+v", err)
+	}
+}
+
+// TestNodeSpans 用节点范围截取源码，检查每种节点的起止位置
+func TestNodeSpans(t *testing.T) {
+	input := `let a = 1 + 2 * b;
+fn add(x, y) {
+  return x + y
+}
+let h = {"k": [1, 2][0]};
+while (a < 10) { a = a + 1; break; }
+add(-a, fn(z) { z })`
+
+	program := parseProgramForSpans(t, input)
+	text := func(node ast.Node) string {
+		span := node.Span()
+		return input[span.Start.Offset:span.End.Offset]
+	}
+
+	let := program.Statements[0].(*ast.LetStatement)
+	fnDef := program.Statements[1].(*ast.FunctionDefinitionStatement)
+	ret := fnDef.FnLiteral.Body.Statements[0].(*ast.ReturnStatement)
+	hash := program.Statements[2].(*ast.LetStatement).Value.(*ast.HashLiteral)
+	index := hash.Pairs[hash.Keys()[0]].(*ast.IndexExpression)
+	while := program.Statements[3].(*ast.WhileStatement)
+	assign := while.Body.Statements[0].(*ast.AssignStatement)
+	call := program.Statements[4].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+
+	tests := []struct {
+		node ast.Node
+		expected string
+	}{
+		{let, "let a = 1 + 2 * b;"},
+		{let.Value, "1 + 2 * b"},
+		{let.Value.(*ast.InfixExpression).Right, "2 * b"},
+		{fnDef, "fn add(x, y) {\n  return x + y\n}"},
+		{fnDef.FnLiteral.Body, "{\n  return x + y\n}"},
+		{ret, "return x + y"},
+		{hash, `{"k": [1, 2][0]}`},
+		{index, "[1, 2][0]"},
+		{index.Left, "[1, 2]"},
+		{while, "while (a < 10) { a = a + 1; break; }"},
+		{assign, "a = a + 1;"},
+		{while.Body.Statements[1], "break;"},
+		{call, "add(-a, fn(z) { z })"},
+		{call.Arguments[0], "-a"},
+		{call.Arguments[1], "fn(z) { z }"},
+		{program, input},
+	}
+
+	for i, tt := range tests {
+		if got := text(tt.node); got != tt.expected {
+			t.Errorf("test[%d] - wrong span %s. want=%q, got=%q", i, tt.node.Span(), tt.expected, got)
+		}
+	}
+
+	if span := call.Span(); span.Start.Line != 7 || span.Start.Column != 1 || span.End.Line != 7 || span.End.Column != 21 {
+		t.Errorf("wrong call span. got=%s", span)
+	}
+}
+
+func parseProgramForSpans(t *testing.T, input string) *ast.Program {
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	return program
+}
@@ -0,0 +1,129 @@
+package glue
+
+import (
+	"fmt"
+	"glue/object"
+	"math"
+	"glue/vm"
+)
+
+// ToObject /**
+/*
+把Go的值转换成glue对象：
+	nil                       -> null
+	bool                      -> Boolean（使用VM的单例，VM按指针比较布尔值）
+	各种整数类型                -> Integer（超出int64范围的uint、uint64返回错误）
+	float32、float64           -> Float
+	string                    -> String
+	[]interface{}             -> Array
+	map[string]interface{}    -> Hash
+	object.Object             -> 原样返回
+	object.BuiltinFunction    -> Builtin
+ */
+func ToObject(value interface{}) (object.Object, error) {
+	switch v := value.(type) {
+	case nil:
+		return vm.Null, nil
+	case object.Object:
+		return v, nil
+	case bool:
+		if v {
+			return vm.True, nil
+		}
+		return vm.False, nil
+	case int:
+		return &object.Integer{Value: int64(v)}, nil
+	case int8:
+		return &object.Integer{Value: int64(v)}, nil
+	case int16:
+		return &object.Integer{Value: int64(v)}, nil
+	case int32:
+		return &object.Integer{Value: int64(v)}, nil
+	case int64:
+		return &object.Integer{Value: v}, nil
+	case uint:
+		return uintToObject(uint64(v))
+	case uint64:
+		return uintToObject(v)
+	case uint8:
+		return &object.Integer{Value: int64(v)}, nil
+	case uint16:
+		return &object.Integer{Value: int64(v)}, nil
+	case uint32:
+		return &object.Integer{Value: int64(v)}, nil
+	case float32:
+		return &object.Float{Value: float64(v)}, nil
+	case float64:
+		return &object.Float{Value: v}, nil
+	case string:
+		return &object.String{Value: v}, nil
+	case []interface{}:
+		elements := make([]object.Object, len(v))
+		for i, e := range v {
+			obj, err := ToObject(e)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = obj
+		}
+		return &object.Array{Elements: elements}, nil
+	case map[string]interface{}:
+		pairs := make(map[object.HashKey]object.HashPair, len(v))
+		for k, e := range v {
+			key := &object.String{Value: k}
+			obj, err := ToObject(e)
+			if err != nil {
+				return nil, err
+			}
+			pairs[key.HashKey()] = object.HashPair{Key: key, Value: obj}
+		}
+		return &object.Hash{Pairs: pairs}, nil
+	case object.BuiltinFunction:
+		return &object.Builtin{Fn: v}, nil
+	case func(args ...object.Object) object.Object:
+		return &object.Builtin{Fn: v}, nil
+	default:
+		return nil, fmt.Errorf("unsupported Go type %T", value)
+	}
+}
+
+func uintToObject(v uint64) (object.Object, error) {
+	if v > math.MaxInt64 {
+		return nil, fmt.Errorf("integer %d overflows int64", v)
+	}
+	return &object.Integer{Value: int64(v)}, nil
+}
+
+// ToValue /**
+/*
+把glue对象转换成Go的值，是ToObject的逆过程。Hash转换成map[interface{}]interface{}，因为key不一定是字符串；
+函数等没有对应Go类型的对象原样返回
+ */
+func ToValue(obj object.Object) interface{} {
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return nil
+	case *object.Boolean:
+		return obj.Value
+	case *object.Integer:
+		return obj.Value
+	case *object.Float:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+	case *object.Array:
+		values := make([]interface{}, len(obj.Elements))
+		for i, e := range obj.Elements {
+			values[i] = ToValue(e)
+		}
+		return values
+	case *object.Hash:
+		values := make(map[interface{}]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			values[ToValue(pair.Key)] = ToValue(pair.Value)
+		}
+		return values
+	default:
+		return obj
+	}
+}
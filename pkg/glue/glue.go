@@ -0,0 +1,229 @@
+// Package glue 把glue语言嵌入到Go程序中使用：宿主程序注册函数和变量，编译、执行脚本，再从脚本中取出全局变量或者调用脚本里定义的函数。
+//
+//	script := glue.NewScript([]byte(`let discount = fn(price) { price * rate };`))
+//	script.Add("rate", 0.8)
+//	compiled, err := script.Compile()
+//	...
+//	err = compiled.Run()
+//	result, err := compiled.CallByName("discount", 100)
+//
+// Script和Compiled都不是并发安全的，同一个Compiled不要在多个goroutine里同时使用。
+package glue
+
+import (
+	"context"
+	"fmt"
+	"glue/compiler"
+	"glue/lexer"
+	"glue/object"
+	"glue/parser"
+	"glue/vm"
+	"strings"
+)
+
+// Script /**
+/*
+一段待编译的脚本，以及编译前注册的宿主变量和函数。宿主变量在脚本里就是普通的全局变量，脚本可以读，也可以重新赋值
+ */
+type Script struct {
+	source []byte
+	names []string // 注册顺序，决定全局变量的索引
+	values map[string]object.Object
+	builtins *object.BuiltinRegistry
+	limits object.Limits
+}
+
+func NewScript(source []byte) *Script {
+	return &Script{
+		source: source,
+		values: make(map[string]object.Object),
+		builtins: object.DefaultBuiltins(),
+	}
+}
+
+// SetBuiltins /**
+/*
+替换脚本可以使用的内置函数表，默认是标准内置函数表。比如给不受信任的脚本只提供一个去掉了print的沙箱注册表。
+同一个注册表可以给多个脚本共用
+ */
+func (s *Script) SetBuiltins(r *object.BuiltinRegistry) {
+	s.builtins = r
+}
+
+// Add /**
+/*
+注册一个宿主变量，value会用ToObject转换成glue对象。同名的变量后注册的覆盖先注册的，跟内置函数同名时覆盖内置函数。
+没有名字的函数用注册的名字命名，错误信息里会用到它
+ */
+func (s *Script) Add(name string, value interface{}) error {
+	obj, err := ToObject(value)
+	if err != nil {
+		return fmt.Errorf("glue: add %q: %w", name, err)
+	}
+	if builtin, ok := obj.(*object.Builtin); ok && builtin.Name == "" {
+		// 复制一份，不修改调用方传进来的对象
+		named := *builtin
+		named.Name = name
+		obj = &named
+	}
+
+	if _, ok := s.values[name]; !ok {
+		s.names = append(s.names, name)
+	}
+	s.values[name] = obj
+
+	return nil
+}
+
+// AddFunc 注册一个宿主函数，脚本里像调用内置函数一样调用它
+func (s *Script) AddFunc(name string, fn object.BuiltinFunction) {
+	s.values[name] = &object.Builtin{Name: name, Fn: fn}
+	for _, n := range s.names {
+		if n == name {
+			return
+		}
+	}
+	s.names = append(s.names, name)
+}
+
+// SetLimits 设置执行编译结果时的资源限制，对之后Compile得到的Compiled生效
+func (s *Script) SetLimits(limits object.Limits) {
+	s.limits = limits
+}
+
+// ParseError 脚本有语法错误时Compile返回的错误，包含所有的错误信息
+type ParseError struct {
+	Messages []string
+}
+
+func (e *ParseError) Error() string {
+	return "glue: parse error:\n" + strings.Join(e.Messages, "\n")
+}
+
+// Compile /**
+/*
+编译脚本。语法错误返回*ParseError，编译错误返回compiler.Diagnostics。
+每次调用都会生成一个独立的Compiled，它们的全局变量互不影响
+ */
+func (s *Script) Compile() (*Compiled, error) {
+	p := parser.New(lexer.New(string(s.source)))
+	program := p.ParseProgram()
+	if p.HasError() {
+		return nil, &ParseError{Messages: p.Errors()}
+	}
+
+	if len(s.names) > vm.GlobalSize {
+		return nil, fmt.Errorf("glue: %d host values exceed the limit of %d globals", len(s.names), vm.GlobalSize)
+	}
+
+	symbolTable := compiler.NewSymbolTable()
+	symbolTable.DefineBuiltins(s.builtins)
+	globals := make([]object.Object, vm.GlobalSize)
+	for _, name := range s.names {
+		symbol := symbolTable.Define(name)
+		globals[symbol.Index] = s.values[name]
+	}
+
+	c := compiler.NewWithState(symbolTable, []object.Object{})
+	if err := c.Compile(program); err != nil {
+		return nil, err
+	}
+
+	return &Compiled{
+		bytecode: c.Bytecode(),
+		symbolTable: symbolTable,
+		globals: globals,
+		limits: s.limits,
+	}, nil
+}
+
+// Compiled /**
+/*
+编译好的脚本，持有全局变量和执行它的VM。Run之后全局变量保留着脚本执行完的状态，可以继续取值或者调用其中的函数
+ */
+type Compiled struct {
+	bytecode *compiler.Bytecode
+	symbolTable *compiler.SymbolTable
+	globals []object.Object
+	limits object.Limits
+
+	machine *vm.VM
+}
+
+// Run 执行脚本的顶层代码，运行时错误返回*vm.RuntimeError
+func (c *Compiled) Run() error {
+	return c.RunContext(context.Background())
+}
+
+// RunContext /**
+/*
+同Run，ctx结束或者超出Script.SetLimits设置的限制时中止执行，可以用errors.As从返回的错误里取出*object.LimitError
+ */
+func (c *Compiled) RunContext(ctx context.Context) error {
+	c.machine = c.newMachine()
+	return c.machine.RunContext(ctx)
+}
+
+func (c *Compiled) newMachine() *vm.VM {
+	machine := vm.NewWithGlobalsStore(c.bytecode, c.globals)
+	machine.SetLimits(c.limits)
+	return machine
+}
+
+// Get /**
+/*
+按名字取出全局变量的值，包括宿主注册的变量。变量不存在，或者定义它的语句还没有执行，返回false
+ */
+func (c *Compiled) Get(name string) (object.Object, bool) {
+	symbol, ok := c.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		return nil, false
+	}
+	value := c.globals[symbol.Index]
+	if value == nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// Call /**
+/*
+调用一个glue函数（object.Closure或者object.Builtin），参数用ToObject转换。可以在Run之后调用，
+也可以在宿主函数里调用脚本传进来的回调函数。返回的*object.Error是glue层面的错误值，不会转换成Go的error
+ */
+func (c *Compiled) Call(fn object.Object, args ...interface{}) (object.Object, error) {
+	return c.CallContext(context.Background(), fn, args...)
+}
+
+// CallContext 同Call，宿主直接调用时受ctx和资源限制的约束，在宿主函数里回调时算作正在进行的那次执行的一部分
+func (c *Compiled) CallContext(ctx context.Context, fn object.Object, args ...interface{}) (object.Object, error) {
+	switch fn.(type) {
+	case *object.Closure, *object.Builtin:
+	default:
+		return nil, fmt.Errorf("glue: %s is not callable", fn.Type())
+	}
+
+	objs := make([]object.Object, len(args))
+	for i, arg := range args {
+		obj, err := ToObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("glue: argument %d: %w", i, err)
+		}
+		objs[i] = obj
+	}
+
+	if c.machine == nil {
+		// 还没有Run过，只能调用宿主注册的函数，脚本里定义的函数要执行过顶层代码才存在
+		c.machine = c.newMachine()
+	}
+	return c.machine.CallContext(ctx, fn, objs...)
+}
+
+// CallByName 调用名为name的全局函数
+func (c *Compiled) CallByName(name string, args ...interface{}) (object.Object, error) {
+	fn, ok := c.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("glue: undefined function %s", name)
+	}
+	return c.Call(fn, args...)
+}
@@ -0,0 +1,280 @@
+package glue
+
+import (
+	"context"
+	"errors"
+	"glue/compiler"
+	"glue/object"
+	"glue/vm"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestScriptWithHostValuesAndFunctions(t *testing.T) {
+	script := NewScript([]byte(`
+let total = 0;
+let discount = fn(price) { price * rate };
+let apply = fn(prices) {
+	let i = 0;
+	while (i < len(prices)) {
+		total = total + discount(prices[i]);
+		i = i + 1;
+	}
+	total
+};
+apply(prices);
+let label = shout(name);
+`))
+	if err := script.Add("rate", 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := script.Add("prices", []interface{}{10, 20, 30}); err != nil {
+		t.Fatal(err)
+	}
+	if err := script.Add("name", "glue"); err != nil {
+		t.Fatal(err)
+	}
+	script.AddFunc("shout", func(args ...object.Object) object.Object {
+		return &object.String{Value: args[0].Inspect() + "!"}
+	})
+
+	compiled, err := script.Compile()
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	if err := compiled.Run(); err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		expected interface{}
+	}{
+		{"total", 30.0},
+		{"label", "glue!"},
+		{"rate", 0.5},
+	}
+	for _, tt := range tests {
+		obj, ok := compiled.Get(tt.name)
+		if !ok {
+			t.Errorf("global %s not found", tt.name)
+			continue
+		}
+		if got := ToValue(obj); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("global %s: want=%v, got=%v", tt.name, tt.expected, got)
+		}
+	}
+
+	if _, ok := compiled.Get("undefined"); ok {
+		t.Errorf("undefined global should not be found")
+	}
+
+	result, err := compiled.CallByName("discount", 8)
+	if err != nil {
+		t.Fatalf("call error: %s", err)
+	}
+	if got := ToValue(result); got != 4.0 {
+		t.Errorf("discount(8): want=4.0, got=%v", got)
+	}
+}
+
+func TestCallClosureFromHost(t *testing.T) {
+	// 脚本把回调函数传给宿主函数，宿主在vm.Run执行过程中调用它
+	var calls []interface{}
+	script := NewScript([]byte(`
+let makeAdder = fn(a) { fn(b) { a + b } };
+let add10 = makeAdder(10);
+each([1, 2, 3], fn(x) { x * x });
+`))
+	var compiled *Compiled
+	script.AddFunc("each", func(args ...object.Object) object.Object {
+		for _, e := range args[0].(*object.Array).Elements {
+			result, err := compiled.Call(args[1], e)
+			if err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+			calls = append(calls, ToValue(result))
+		}
+		return nil
+	})
+
+	compiled, err := script.Compile()
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	if err := compiled.Run(); err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	if !reflect.DeepEqual(calls, []interface{}{int64(1), int64(4), int64(9)}) {
+		t.Errorf("wrong callback results: %v", calls)
+	}
+
+	add10, _ := compiled.Get("add10")
+	if _, ok := add10.(*object.Closure); !ok {
+		t.Fatalf("add10 is not a closure. got=%T", add10)
+	}
+	for i := 0; i < 3; i++ {
+		result, err := compiled.Call(add10, i)
+		if err != nil {
+			t.Fatalf("call error: %s", err)
+		}
+		if got := ToValue(result); got != int64(10+i) {
+			t.Errorf("add10(%d): want=%d, got=%v", i, 10+i, got)
+		}
+	}
+
+	// 出错之后VM还能继续用
+	_, err = compiled.Call(add10, "x")
+	var runtimeErr *vm.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected runtime error, got=%v", err)
+	}
+	if _, err := compiled.Call(add10, 1); err != nil {
+		t.Errorf("call after error failed: %s", err)
+	}
+
+	if _, err := compiled.Call(&object.Integer{Value: 1}); err == nil {
+		t.Errorf("calling an integer should fail")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	_, err := NewScript([]byte(`let a = ;`)).Compile()
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Errorf("expected *ParseError, got=%T %v", err, err)
+	}
+
+	_, err = NewScript([]byte(`missing + 1;`)).Compile()
+	var diagnostics compiler.Diagnostics
+	if !errors.As(err, &diagnostics) {
+		t.Errorf("expected compiler.Diagnostics, got=%T %v", err, err)
+	}
+
+	if err := NewScript(nil).Add("bad", struct{}{}); err == nil {
+		t.Errorf("adding an unsupported value should fail")
+	}
+
+	script := NewScript([]byte(`1`))
+	for i := 0; i <= vm.GlobalSize; i++ {
+		script.names = append(script.names, "v")
+	}
+	if _, err := script.Compile(); err == nil {
+		t.Errorf("expected an error for too many host values")
+	}
+}
+
+func TestConversions(t *testing.T) {
+	values := []interface{}{
+		nil,
+		true,
+		int64(42),
+		3.5,
+		"text",
+		[]interface{}{int64(1), "two", false},
+	}
+	for _, v := range values {
+		obj, err := ToObject(v)
+		if err != nil {
+			t.Fatalf("ToObject(%v): %s", v, err)
+		}
+		if got := ToValue(obj); !reflect.DeepEqual(got, v) {
+			t.Errorf("round trip of %v: got=%v", v, got)
+		}
+	}
+
+	obj, err := ToObject(map[string]interface{}{"a": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ToValue(obj); !reflect.DeepEqual(got, map[interface{}]interface{}{"a": int64(1)}) {
+		t.Errorf("wrong hash conversion: %v", got)
+	}
+	if obj, _ := ToObject(false); obj != vm.False {
+		t.Errorf("false should convert to the VM singleton")
+	}
+
+	if obj, err := ToObject(uint64(math.MaxInt64)); err != nil || obj.(*object.Integer).Value != math.MaxInt64 {
+		t.Errorf("wrong uint64 conversion: %v %v", obj, err)
+	}
+	for _, v := range []interface{}{uint64(math.MaxInt64) + 1, uint(math.MaxUint64)} {
+		if _, err := ToObject(v); err == nil {
+			t.Errorf("expected an overflow error for %v", v)
+		}
+	}
+}
+
+func TestAddNamesBuiltins(t *testing.T) {
+	script := NewScript([]byte(`fail(1)`))
+	anonymous := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return &object.Error{Message: "failed"}
+	}}
+	if err := script.Add("fail", anonymous); err != nil {
+		t.Fatal(err)
+	}
+	if anonymous.Name != "" {
+		t.Errorf("Add should not modify the caller's builtin")
+	}
+
+	compiled, err := script.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn, _ := compiled.Get("fail")
+	if builtin, ok := fn.(*object.Builtin); !ok || builtin.Name != "fail" {
+		t.Errorf("builtin should be named after its host variable, got=%+v", fn)
+	}
+}
+
+func TestSandboxBuiltins(t *testing.T) {
+	sandbox := object.NewBuiltinRegistry()
+	std, _, _ := object.DefaultBuiltins().Lookup("len")
+	sandbox.Add(std)
+
+	script := NewScript([]byte(`let n = len("glue");`))
+	script.SetBuiltins(sandbox)
+	compiled, err := script.Compile()
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	if err := compiled.Run(); err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	if n, _ := compiled.Get("n"); ToValue(n) != int64(4) {
+		t.Errorf("wrong result: %v", ToValue(n))
+	}
+
+	script = NewScript([]byte(`print("escape");`))
+	script.SetBuiltins(sandbox)
+	if _, err := script.Compile(); err == nil {
+		t.Errorf("print should not be available in the sandbox")
+	}
+}
+
+func TestRunWithLimits(t *testing.T) {
+	script := NewScript([]byte(`
+let spin = fn() { while (true) {} };
+let double = fn(x) { x * 2 };
+spin();
+`))
+	script.SetLimits(object.Limits{MaxInstructions: 10000})
+	compiled, err := script.Compile()
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	var limitErr *object.LimitError
+	if err := compiled.RunContext(context.Background()); !errors.As(err, &limitErr) || limitErr.Kind != object.LimitInstructions {
+		t.Fatalf("expected instruction limit error, got=%v", err)
+	}
+
+	// 宿主直接调用时重新计数
+	result, err := compiled.CallByName("double", 21)
+	if err != nil {
+		t.Fatalf("call error: %s", err)
+	}
+	if got := ToValue(result); got != int64(42) {
+		t.Errorf("double(21): want=42, got=%v", got)
+	}
+}
@@ -23,9 +23,7 @@ func Start(in io.Reader, out io.Writer) {
 	var constants []object.Object
 	globals := make([]object.Object, vm.GlobalSize)
 	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	symbolTable.DefineBuiltins(object.DefaultBuiltins())
 
 	color.Green(POEM)
 
@@ -77,8 +75,9 @@ addTwo(3);`
 
 		comp :=compiler.NewWithState(symbolTable, constants)
 		err := comp.Compile(program)
+		printDiagnostics(out, comp.Diagnostics())
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
+			fmt.Fprintf(out, "Woops! Compilation failed.\n")
 			continue
 		}
 
@@ -88,7 +87,11 @@ addTwo(3);`
 		machine := vm.NewWithGlobalsStore(byteCode, globals)
 		err = machine.Run()
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+			if re, ok := err.(*vm.RuntimeError); ok {
+				fmt.Fprintf(out, "Woops! Executing bytecode failed:\n%s", re.StackTrace())
+			}else {
+				fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+			}
 			continue
 		}
 
@@ -118,4 +121,10 @@ func printParserErrors(out io.Writer, errors []string) {
 		io.WriteString(out, "\t"+msg+"\n")
 	}
 	defer color.Unset()
-}
\ No newline at end of file
+}
+
+func printDiagnostics(out io.Writer, diagnostics compiler.Diagnostics) {
+	for _, d := range diagnostics {
+		io.WriteString(out, "\t"+d.String()+"\n")
+	}
+}
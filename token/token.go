@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
@@ -7,17 +9,43 @@ type Token struct {
 	Type TokenType
 	Literal string
 	LineNum int
-	ColumnNum int
+	ColumnNum int // 按字符计数，一个汉字算一列
+
+	File string // 所在的源文件，不是从文件读入的源码为空
+	Offset int // 第一个字节在源码中的字节偏移
+	End Position // 紧跟在token最后一个字符之后的位置，字符串的Literal是解码过的，不能用它的长度推算结束位置
+}
+
+// Position 源码中的一个位置，行号和列号从1开始，列号按字符计数（一个汉字算一列），Offset是从源码开头算起的字节偏移
+type Position struct {
+	File string
+	Line int
+	Column int
+	Offset int
+}
+
+func (p Position) String() string {
+	if p.File != "" {
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Pos token开始的位置
+func (tok Token) Pos() Position {
+	return Position{File: tok.File, Line: tok.LineNum, Column: tok.ColumnNum, Offset: tok.Offset}
 }
 
 // token types
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF 	= "EOF"
+	COMMENT = "COMMENT" // 只有Lexer.KeepComments为true时才会产生
 
 	//Identifiers and literals
 	IDENT	= "IDENT"
 	INT 	= "INT"
+	FLOAT	= "FLOAT"
 
 	//Operators
 	ASSIGN 	= "="
@@ -26,11 +54,24 @@ const (
 	BANG	= "!"
 	ASTERISK = "*"
 	SLASH	= "/"
+	PERCENT	= "%"
+
+	// 复合赋值
+	PLUS_ASSIGN	= "+="
+	MINUS_ASSIGN	= "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN	= "/="
+	PERCENT_ASSIGN	= "%="
 
 	LT		= "<"
 	GT		= ">"
 	EQ		= "=="
 	NOT_EQ	= "!="
+	LT_EQ	= "<="
+	GT_EQ	= ">="
+
+	AND		= "&&"
+	OR		= "||"
 
 	//delimiters
 	COMMA	= ","
@@ -53,6 +94,8 @@ const (
 	RETURN = "RETURN"
 	STRING = "STRING"
 	WHILE = "WHILE"
+	FOR = "FOR"
+	IN = "IN"
 	BREAK = "BREAK"
 	CONTINUE = "CONTINUE"
 
@@ -67,6 +110,8 @@ var keywords = map[string]TokenType{
 	"else": ELSE,
 	"return": RETURN,
 	"while": WHILE,
+	"for": FOR,
+	"in": IN,
 	"break": BREAK,
 	"continue": CONTINUE,
 }
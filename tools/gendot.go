@@ -191,10 +191,18 @@ func walk(node ast.Node, lines *[]string) {
 		*lines = append(*lines, genEdgeToNode(node, node.Lhs))
 		walk(node.Lhs, lines)
 
-		*lines = append(*lines, genEdgeToLeaf(node, "="))
+		*lines = append(*lines, genEdgeToLeaf(node, node.Operator))
 
 		*lines = append(*lines, genEdgeToNode(node, node.Rhs))
 		walk(node.Rhs, lines)
+	case *ast.IndexAssignStatement:
+		*lines = append(*lines, genEdgeToNode(node, node.Target))
+		walk(node.Target, lines)
+
+		*lines = append(*lines, genEdgeToLeaf(node, node.Operator))
+
+		*lines = append(*lines, genEdgeToNode(node, node.Value))
+		walk(node.Value, lines)
 	case *ast.ReturnStatement:
 		*lines = append(*lines, genEdgeToLeaf(node, "return"))
 
@@ -215,6 +223,11 @@ func walk(node ast.Node, lines *[]string) {
 		*lines = append(*lines, genEdgeToNode(node, node.Consequence))
 		walk(node.Consequence, lines)
 
+		if node.ElseIf != nil {
+			*lines = append(*lines, genEdgeToLeaf(node, "else"))
+			*lines = append(*lines, genEdgeToNode(node, node.ElseIf))
+			walk(node.ElseIf, lines)
+		}
 		if node.Alternative != nil {
 			*lines = append(*lines, genEdgeToLeaf(node, "else"))
 			*lines = append(*lines, genEdgeToNode(node, node.Alternative))
@@ -257,6 +270,9 @@ func walk(node ast.Node, lines *[]string) {
 		//*lines = append(*lines, genLeaf(node.Value))
 		*lines = append(*lines, genEdgeToLeaf(node, node.Value))
 		return
+	case *ast.FloatLiteral:
+		*lines = append(*lines, genEdgeToLeaf(node, node.Value))
+		return
 	case *ast.StringLiteral:
 		//*lines = append(*lines, genNode(node))
 		//*lines = append(*lines, genLeaf(node.Value))
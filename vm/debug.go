@@ -0,0 +1,105 @@
+package vm
+
+import (
+	"fmt"
+	"glue/code"
+	"glue/object"
+)
+
+// DebugHook /**
+/*
+调试钩子，VM每执行一条指令之前调用一次，此时当前栈帧的ip指向即将执行的指令。
+钩子可以阻塞（等待调试器的命令），也可以通过VM的Frames、Locals等方法查看执行状态，返回错误时VM中止执行
+ */
+type DebugHook func(vm *VM) error
+
+// SetDebugHook 设置调试钩子，nil表示不调试
+func (vm *VM) SetDebugHook(hook DebugHook) {
+	vm.debugHook = hook
+}
+
+// Variable 调试时展示的一个变量，Value为nil表示还没有赋值
+type Variable struct {
+	Name string
+	Value object.Object
+}
+
+// CallDepth 当前的函数调用深度，执行主程序时为0
+func (vm *VM) CallDepth() int {
+	return vm.frameIndex - 1
+}
+
+// IP 当前栈帧里即将执行的指令的位置
+func (vm *VM) IP() int {
+	return vm.currentFrame().ip
+}
+
+// Position 即将执行的指令对应的源码位置
+func (vm *VM) Position() (code.SourcePosition, bool) {
+	frame := vm.currentFrame()
+	return frame.cl.Fn.SourceMap.Lookup(frame.ip)
+}
+
+// Frames /**
+/*
+当前的调用栈，最内层的栈帧在最前面，跟RuntimeError.Trace的格式一样。
+Locals和FreeVariables的frame参数就是这里的下标
+ */
+func (vm *VM) Frames() []TraceEntry {
+	return vm.stackTrace()
+}
+
+func (vm *VM) frameAt(frame int) (*Frame, error) {
+	if frame < 0 || frame >= vm.frameIndex {
+		return nil, fmt.Errorf("no frame %d", frame)
+	}
+	return vm.frames[vm.frameIndex-1-frame], nil
+}
+
+// Locals /**
+/*
+第frame个栈帧（0是最内层）的局部变量，包括形参，就是stack[basePointer:basePointer+NumLocals]。
+函数没有调试信息（从字节码文件加载）时变量名是local0、local1……
+ */
+func (vm *VM) Locals(frame int) ([]Variable, error) {
+	f, err := vm.frameAt(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	fn := f.cl.Fn
+	locals := make([]Variable, fn.NumLocals)
+	for i := range locals {
+		locals[i] = Variable{Name: debugName(fn.LocalNames, i, "local"), Value: vm.stack[f.basePointer+i]}
+	}
+	return locals, nil
+}
+
+// FreeVariables 第frame个栈帧所属闭包捕获的自由变量
+func (vm *VM) FreeVariables(frame int) ([]Variable, error) {
+	f, err := vm.frameAt(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	free := make([]Variable, len(f.cl.Free))
+	for i, value := range f.cl.Free {
+		free[i] = Variable{Name: debugName(f.cl.Fn.FreeNames, i, "free"), Value: value}
+	}
+	return free, nil
+}
+
+// Global 按索引取全局变量，变量名到索引的对应关系在编译器的符号表里
+func (vm *VM) Global(index int) object.Object {
+	if index < 0 || index >= len(vm.globals) {
+		return nil
+	}
+	return vm.globals[index]
+}
+
+func debugName(names []string, i int, prefix string) string {
+	if i < len(names) && names[i] != "" {
+		return names[i]
+	}
+	return fmt.Sprintf("%s%d", prefix, i)
+}
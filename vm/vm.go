@@ -1,16 +1,19 @@
 package vm
 
 import (
+	"context"
 	"fmt"
 	"github.com/fatih/color"
 	"glue/code"
 	"glue/compiler"
 	"glue/object"
+	"math"
+	"strings"
 )
 
 
 const StackSize = 2048
-const GlobalSize = 65536
+const GlobalSize = compiler.GlobalSize
 const MaxFrames = 1024 // 预分配调用栈大小，要什么自行车，差不多够用了
 
 var True = &object.Boolean{Value: true}
@@ -60,15 +63,41 @@ type VM struct {
 	sp int
 
 	globals []object.Object
+	builtins *object.BuiltinRegistry // OpGetBuiltin按索引从这里取内置函数，跟编译时用的是同一个
 
 	frames [] *Frame // the stack for frame
 	frameIndex int // always pointing to the next available frame, equals len(frames)
+
+	limits object.Limits
+	budget *object.Budget // 当前这次执行的计数器，RunContext和最外层的CallContext各自新建一个
+	running int // 正在进行的execute层数，大于0说明是在内置函数里回调
+
+	debugHook DebugHook
 }
 
+const maxTraceEntries = 32 // 打印调用栈时最多显示的栈帧数量，栈溢出时不至于刷屏
+
+// RuntimeError /**
+/*
+VM执行出错时返回的错误，带有出错的指令、对应的源码位置，以及出错时的调用栈（最内层的栈帧在最前面）
+ */
 type RuntimeError struct {
-	opcode code.Opcode
-	operands object.Object
+	Opcode code.Opcode
+	Line int
+	Column int
+	Trace []TraceEntry
 	msg string
+	err error // 原始错误，比如*object.LimitError，可以用errors.As取出来
+}
+
+// TraceEntry /**
+/*
+调用栈中的一帧，位置是该帧正在执行的指令对应的源码位置，对于调用者来说就是调用发生的位置
+ */
+type TraceEntry struct {
+	Function string
+	Line int
+	Column int
 }
 
 func (re *RuntimeError) Error() string {
@@ -77,6 +106,41 @@ func (re *RuntimeError) Error() string {
 	return re.msg
 }
 
+func (re *RuntimeError) Unwrap() error {
+	return re.err
+}
+
+// StackTrace /**
+/*
+带源码位置和调用栈的完整错误信息
+ */
+func (re *RuntimeError) StackTrace() string {
+	var out strings.Builder
+
+	opName := fmt.Sprintf("opcode %d", re.Opcode)
+	if def, err := code.Lookup(byte(re.Opcode)); err == nil {
+		opName = def.Name
+	}
+	fmt.Fprintf(&out, "runtime error: %s (%s at %s)\n", re.msg, opName, formatPosition(re.Line, re.Column))
+
+	for i, entry := range re.Trace {
+		if i == maxTraceEntries {
+			fmt.Fprintf(&out, "    ... %d more\n", len(re.Trace)-maxTraceEntries)
+			break
+		}
+		fmt.Fprintf(&out, "    at %s (%s)\n", entry.Function, formatPosition(entry.Line, entry.Column))
+	}
+
+	return out.String()
+}
+
+func formatPosition(line, column int) string {
+	if line == 0 {
+		return "unknown position"
+	}
+	return fmt.Sprintf("line %d, column %d", line, column)
+}
+
 // RuntimeInfo /**
 /*
 For debug
@@ -89,7 +153,9 @@ type RuntimeInfo struct {
 
 func New(bytecode *compiler.Bytecode) *VM {
 	mainFn := &object.CompiledFunction{
+		Name: "<main>",
 		Instructions: bytecode.Instructions,
+		SourceMap: bytecode.SourceMap,
 	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
@@ -97,6 +163,11 @@ func New(bytecode *compiler.Bytecode) *VM {
 	frames := make([]*Frame, MaxFrames)
 	frames[0] = mainFrame //初始化一下VM的call stack，代码默认相当于写在一个主函数里
 
+	builtins := bytecode.Builtins
+	if builtins == nil { // 手工构造的Bytecode
+		builtins = object.DefaultBuiltins()
+	}
+
 	return &VM{
 		//instructions: bytecode.Instructions,
 		constants: bytecode.Constants,
@@ -104,6 +175,7 @@ func New(bytecode *compiler.Bytecode) *VM {
 		stack: make([]object.Object, StackSize),
 		sp :0,
 		globals: make([]object.Object, GlobalSize),
+		builtins: builtins,
 
 		frames: frames,
 		frameIndex: 1, //总是指向下一个可用栈帧(stack frame)
@@ -120,14 +192,18 @@ func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.frameIndex-1]
 }
-func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.frameIndex] = f
-	vm.frameIndex++
+func (vm *VM) pushFrame(f *Frame) error {
+	// 主栈帧不算调用深度，压入之前的栈帧数就是新栈帧的调用深度
+	if err := vm.budget.CheckDepth(vm.frameIndex); err != nil {
+		return err
+	}
 	if vm.frameIndex >= MaxFrames {
-		err := new(RuntimeError)
-		err.msg = fmt.Sprintf("stack overflow, max stack size %d, 你妈喊你回家吃饭！", MaxFrames)
-		panic(err)
+		return fmt.Errorf("stack overflow, max stack size %d, 你妈喊你回家吃饭！", MaxFrames)
 	}
+	vm.frames[vm.frameIndex] = f
+	vm.frameIndex++
+
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -190,20 +266,109 @@ func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.sp - 1]
 }
 
+// SetLimits 设置之后的RunContext和CallContext使用的资源限制
+func (vm *VM) SetLimits(limits object.Limits) {
+	vm.limits = limits
+}
+
 func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext /**
+/*
+执行主程序，ctx结束或者超出SetLimits设置的限制时中止执行，返回的RuntimeError里包着*object.LimitError
+ */
+func (vm *VM) RunContext(ctx context.Context) error {
+	vm.budget = object.NewBudget(ctx, vm.limits)
+	return vm.execute(0)
+}
+
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	return vm.CallContext(context.Background(), fn, args...)
+}
+
+// CallContext /**
+/*
+在Run之外（Run结束之后，或者在内置函数里回调）调用一个glue函数，fn可以是Closure或者Builtin。
+被调用的函数返回时就停下来，不会接着执行调用前所在栈帧的指令，返回值从栈上取走。
+出错时返回RuntimeError，栈恢复成调用前的状态，VM可以继续使用。
+
+宿主直接调用时按ctx和SetLimits的限制重新计数；在内置函数里回调时算作正在进行的那次执行的一部分，ctx不起作用
+ */
+func (vm *VM) CallContext(ctx context.Context, fn object.Object, args ...object.Object) (result object.Object, err error) {
+	if vm.running == 0 {
+		vm.budget = object.NewBudget(ctx, vm.limits)
+	}
+
+	baseFrame := vm.frameIndex
+	baseSp := vm.sp
+	defer func() {
+		if err != nil {
+			vm.frameIndex = baseFrame
+			vm.sp = baseSp
+		}
+	}()
+
+	if err = vm.push(fn); err != nil {
+		return nil, vm.newRuntimeError(code.OpCall, err)
+	}
+	for _, arg := range args {
+		if err = vm.push(arg); err != nil {
+			return nil, vm.newRuntimeError(code.OpCall, err)
+		}
+	}
+	if err = vm.executeCall(len(args)); err != nil {
+		return nil, vm.newRuntimeError(code.OpCall, err)
+	}
+
+	// 调用Closure会压入一个新的栈帧，执行到它返回为止；Builtin在executeCall里就已经执行完了
+	if err = vm.execute(baseFrame); err != nil {
+		return nil, err
+	}
+
+	return vm.pop(), nil
+}
+
+// execute /**
+/*
+执行指令直到栈帧数回落到baseFrame，或者当前栈帧的指令执行完。Run从主栈帧开始执行，baseFrame是0；
+Call只执行被调用的函数，baseFrame是调用前的栈帧数，函数返回、栈帧出栈之后就停下来
+ */
+func (vm *VM) execute(baseFrame int) (err error) {
 	var ip int
 	var instructions code.Instructions
 	var op code.Opcode
 
+	vm.running++
+
+	// 所有执行错误都在这里统一包装成RuntimeError，此时栈帧还保持着出错时的状态，可以据此还原出错位置和调用栈
+	defer func() {
+		vm.running--
+		if err != nil {
+			err = vm.newRuntimeError(op, err)
+		}
+	}()
+
 	//mn := monitor.SingletonNew()
 
 	//VM进入运行状态，ip是指令指针，每次向前移动一个字节，
 	//每条指令的宽度可能不同，所以每条指令执行完后必须jump over合适的operands，如果碰到无法识别的指令，就会造成指令的执行出错，因为
 	//可能会导致IP指向操作数，会导致后续所有指令的执行不可预测，出一些奇怪的错误
 	//for ip := 0; ip < len(vm.instructions); ip++ {
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+	for vm.frameIndex > baseFrame && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		if err = vm.budget.Step(); err != nil {
+			return err
+		}
+
 		vm.currentFrame().ip++ // 这里就是为什么上面for条件中指令长度-1的原因
 
+		if vm.debugHook != nil {
+			if err = vm.debugHook(vm); err != nil {
+				return err
+			}
+		}
+
 		ip = vm.currentFrame().ip
 		instructions = vm.currentFrame().Instructions()
 		//取出当前ip指向的指令，指令长度一字节，直接强制类型转换为Opcode类型，不会有信息丢失
@@ -226,7 +391,7 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
@@ -243,7 +408,7 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual, code.OpLessThan, code.OpLessThanOrEqual:
 			err := vm.executeComparison(op)
 			if err != nil {
 				return err
@@ -255,10 +420,10 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpMinus:
-			vo := vm.pop()
-			vi := vo.(*object.Integer)
-			v := vi.Value
-			vm.push(&object.Integer{Value: -v})
+			err := vm.executeMinusOperator()
+			if err != nil {
+				return err
+			}
 		case code.OpJump:
 			// 取出OpJump指令的操作数，也就是跳转的目的地址（是一个相对于指令序列0位置的绝对偏移量）
 			pos := int(code.ReadUint16(instructions[ip+1:]))
@@ -314,8 +479,11 @@ func (vm *VM) Run() error {
 			builtinIndex := code.ReadUint8(instructions[ip+1:])
 			vm.currentFrame().ip += 1
 
-			definition := object.Builtins[builtinIndex]
-			err := vm.push(definition.Builtin)
+			definition := vm.builtins.At(int(builtinIndex))
+			if definition == nil {
+				return fmt.Errorf("unknown builtin %d", builtinIndex)
+			}
+			err := vm.push(definition)
 			if err != nil {
 				return err
 			}
@@ -328,6 +496,11 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(instructions[ip+1:])
+			vm.currentFrame().ip += 1
+
+			vm.currentFrame().cl.Free[freeIndex] = vm.pop()
 		case code.OpArray:
 			// OpArray 用来计算（构造）数组字面量，没错，数组的构造过程是在运行时进行的，因为数组每个元素可能是某个表达式的
 			// 计算结果，所以只能无法在编译期确定数组的具体元素内容
@@ -335,10 +508,13 @@ func (vm *VM) Run() error {
 			numElements := int(code.ReadUint16(instructions[ip+1:]))
 			vm.currentFrame().ip += 2
 			//这里有个顺序，数组原始是根据指令的顺序执行压栈的，所以前面的元素会先压栈，因为指令先生成
-			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			array, err := vm.buildArray(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
 			vm.sp = vm.sp - numElements // 数组构造完成后让栈指针下移数组长度的距离，代表数组出栈了
 
-			err := vm.push(array) // 把构造好的数组对象压栈
+			err = vm.push(array) // 把构造好的数组对象压栈
 			if err != nil {
 				return err
 			}
@@ -362,6 +538,67 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpIndexKeep:
+			index := vm.stack[vm.sp-1]
+			left := vm.stack[vm.sp-2]
+
+			err := vm.executeIndexExpression(left, index)
+			if err != nil {
+				return err
+			}
+		case code.OpIter:
+			iterable := vm.pop()
+			iterator, err := object.NewIterator(iterable)
+			if err != nil {
+				return err
+			}
+			if err := vm.budget.Alloc(object.SizeOf(iterator)); err != nil {
+				return err
+			}
+			err = vm.push(iterator)
+			if err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(instructions[ip+1:]))
+			count := code.ReadUint8(instructions[ip+3:])
+			vm.currentFrame().ip += 3
+
+			var iterator *object.Iterator
+			if vm.sp > 0 {
+				iterator, _ = vm.stack[vm.sp-1].(*object.Iterator)
+			}
+			if iterator == nil {
+				return fmt.Errorf("OpIterNext without an iterator on the stack")
+			}
+			key, value, ok := iterator.Next()
+			if !ok {
+				// 取完了，弹出迭代器，跳出循环
+				vm.pop()
+				vm.currentFrame().ip = pos - 1
+				break
+			}
+			if count == 2 {
+				err := vm.push(key)
+				if err != nil {
+					return err
+				}
+			}else {
+				value = iterator.Item(key, value)
+			}
+			err := vm.push(value)
+			if err != nil {
+				return err
+			}
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
 		case code.OpClosure:
 			constIndex := code.ReadUint16(instructions[ip+1:])
 			numFree := code.ReadUint8(instructions[ip+3:])
@@ -435,6 +672,41 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+func (vm *VM) newRuntimeError(op code.Opcode, err error) *RuntimeError {
+	if re, ok := err.(*RuntimeError); ok {
+		return re
+	}
+
+	re := &RuntimeError{Opcode: op, msg: err.Error(), err: err}
+	re.Trace = vm.stackTrace()
+	if len(re.Trace) > 0 {
+		re.Line = re.Trace[0].Line
+		re.Column = re.Trace[0].Column
+	}
+
+	return re
+}
+
+// stackTrace /**
+/*
+从调用栈顶部（当前栈帧）开始，逐帧查出正在执行的指令对应的源码位置。
+栈帧的ip指向正在执行的指令（或者已经跳过了它的操作数，仍然在这条指令范围内），调用者的ip停在OpCall上
+ */
+func (vm *VM) stackTrace() []TraceEntry {
+	trace := make([]TraceEntry, 0, vm.frameIndex)
+	for i := vm.frameIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		entry := TraceEntry{Function: frame.cl.Fn.DisplayName()}
+		if pos, ok := frame.cl.Fn.SourceMap.Lookup(frame.ip); ok {
+			entry.Line = pos.Line
+			entry.Column = pos.Column
+		}
+		trace = append(trace, entry)
+	}
+
+	return trace
+}
+
 // pushClosure /**
 /*
 构造Closure对象，然后压栈
@@ -485,18 +757,64 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
 	}
 	frame := NewFrame(cl, vm.sp - numArgs)
-	vm.pushFrame(frame)
+	err := vm.pushFrame(frame)
+	if err != nil {
+		return err
+	}
 
 	vm.sp = frame.basePointer + cl.Fn.NumLocals // NumLocals =（包括局部变量+形参）两者的数量，所以这里不必在加上numArgs
 
 	return nil
 }
 
+// isFromArguments 返回值是不是某个参数本身，或者是数组参数的元素、哈希参数的键或值
+func isFromArguments(result object.Object, args []object.Object) bool {
+	if result == nil {
+		return false
+	}
+	for _, arg := range args {
+		if arg == result {
+			return true
+		}
+		switch arg := arg.(type) {
+		case *object.Array:
+			for _, el := range arg.Elements {
+				if el == result {
+					return true
+				}
+			}
+		case *object.Hash:
+			for _, pair := range arg.Pairs {
+				if pair.Key == result || pair.Value == result {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
+	// 没有内存限制时不用记账，也就不用为了判断返回值是不是新分配的去扫描参数
+	limited := vm.budget.LimitsMemory()
+	// 能估算分配量的内置函数在调用之前记账，超出限制时不会真的分配
+	if limited && builtin.Alloc != nil {
+		if err := vm.budget.Alloc(builtin.Alloc(args...)); err != nil {
+			return err
+		}
+	}
 	result := builtin.Fn(args...)
 	vm.sp = vm.sp - numArgs -1
+	// 其他内置函数只能在返回之后按返回值记账，返回参数里已有的对象时没有新分配，不记
+	if limited && builtin.Alloc == nil {
+		if size := object.SizeOf(result); size > 0 && !isFromArguments(result, args) {
+			if err := vm.budget.Alloc(size); err != nil {
+				return err
+			}
+		}
+	}
 	var err error
 	if result != nil {
 		err = vm.push(result)
@@ -548,7 +866,45 @@ func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	return vm.push(pair.Value)
 }
 
+// executeSetIndex /**
+/*
+给数组的元素或者哈希的键赋值，直接修改原来的对象。数组的索引必须是整数并且不能越界，不能用来追加元素；
+哈希的键必须可以做哈希键，新加的键按一个键值对计入内存限额
+ */
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("array index must be INTEGER, got %s", index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return fmt.Errorf("index out of range: %d with length %d", i.Value, len(left.Elements))
+		}
+		left.Elements[i.Value] = value
+		return nil
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		hashKey := key.HashKey()
+		if _, ok := left.Pairs[hashKey]; !ok {
+			if err := vm.budget.Alloc(object.HashSize(1) - object.HashSize(0)); err != nil {
+				return err
+			}
+		}
+		left.Pairs[hashKey] = object.HashPair{Key: index, Value: value}
+		return nil
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+}
+
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	if err := vm.budget.Alloc(object.HashSize((endIndex - startIndex) / 2)); err != nil {
+		return nil, err
+	}
 	hashedPairs := make(map[object.HashKey]object.HashPair)
 	for i:= startIndex; i< endIndex; i+=2 {
 		key :=vm.stack[i]
@@ -565,22 +921,30 @@ func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
 	return &object.Hash{Pairs: hashedPairs}, nil
 }
 
-func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+func (vm *VM) buildArray(startIndex, endIndex int) (object.Object, error) {
+	if err := vm.budget.Alloc(object.ArraySize(endIndex - startIndex)); err != nil {
+		return nil, err
+	}
 	elements := make([]object.Object, endIndex - startIndex)
 
 	for i:= startIndex; i < endIndex; i++ {
 		elements[i - startIndex] = vm.stack[i]
 	}
 
-	return &object.Array{Elements: elements}
+	return &object.Array{Elements: elements}, nil
 }
 
+// isTruthy 跟evaluator的规则一样：false、null和数字0（包括0.0）为假，其余都为真
 func isTruthy(obj object.Object) bool {
 	switch obj := obj.(type) {
 	case *object.Boolean:
 		return obj.Value
 	case *object.Null:
 		return false
+	case *object.Integer:
+		return obj.Value != 0
+	case *object.Float:
+		return obj.Value != 0
 	default:
 		return true
 	}
@@ -589,6 +953,9 @@ func isTruthy(obj object.Object) bool {
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
 
+	if operand, ok := operand.(*object.Float); ok {
+		return vm.push(&object.Float{Value: -operand.Value})
+	}
 	if operand.Type() != object.INTEGER_OBJ {
 		return fmt.Errorf("unsupported type for negation: %s(type:%s)", operand.Inspect(), operand.Type())
 	}
@@ -602,16 +969,7 @@ func (vm *VM) executeMinusOperator() error {
 func (vm *VM) executeBangOperator() error {
 	operand := vm.pop()
 
-	switch operand {
-	case True:
-		return vm.push(False)
-	case False:
-		return vm.push(True)
-	case Null:
-		return vm.push(True)
-	default:
-		return vm.push(False)
-	}
+	return vm.push(nativeBoolToBooleanObject(!isTruthy(operand)))
 }
 
 func (vm *VM) executeComparison(op code.Opcode) error {
@@ -620,6 +978,9 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
 		return vm.executeIntegerComparison(op, left, right)
 	}
+	if object.IsNumber(left) && object.IsNumber(right) {
+		return vm.executeFloatComparison(op, left, right)
+	}
 
 	switch op {
 	case code.OpEqual:
@@ -642,11 +1003,39 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object
 		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpLessThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
 	default:
 		return fmt.Errorf("unknown operator: %d", op)
 	}
 }
 
+// executeFloatComparison 至少有一边是浮点数，整数先提升为浮点数再比较
+func (vm *VM) executeFloatComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := object.FloatValue(left)
+	rightValue := object.FloatValue(right)
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpLessThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
 
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
@@ -665,6 +1054,8 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	switch {
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case object.IsNumber(left) && object.IsNumber(right):
+		return vm.executeBinaryFloatOperation(op, left, right)
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
@@ -679,6 +1070,10 @@ func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Ob
 	}
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
+	// 先记账再拼接，超出限制时不会真的分配这块内存
+	if err := vm.budget.Alloc(object.StringSize(len(leftValue) + len(rightValue))); err != nil {
+		return err
+	}
 	//好像go的+在处理字符串相加时效率较低，后续考虑优化一下。 todo:
 	return vm.push(&object.String{Value: leftValue + rightValue})
 }
@@ -696,7 +1091,15 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 	case code.OpMul:
 		result = leftValue * rightValue
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
 		result = leftValue / rightValue
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftValue % rightValue
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
@@ -704,6 +1107,30 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 	return vm.push(&object.Integer{Value: result})
 }
 
+// executeBinaryFloatOperation 至少有一边是浮点数，整数先提升为浮点数，结果总是浮点数
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Object) error {
+	leftValue := object.FloatValue(left)
+	rightValue := object.FloatValue(right)
+	var result float64
+
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		result = leftValue / rightValue
+	case code.OpMod:
+		result = math.Mod(leftValue, rightValue)
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+
+	return vm.push(&object.Float{Value: result})
+}
+
 func (vm *VM) push(o object.Object) error {
 	if vm.sp >= StackSize {
 		return fmt.Errorf("stack overflow")
@@ -1,13 +1,18 @@
 package vm
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"glue/ast"
+	"glue/code"
 	"glue/compiler"
 	"glue/lexer"
 	"glue/object"
 	"glue/parser"
 	"fmt"
 	"testing"
+	"time"
 )
 
 func parse(input string) *ast.Program {
@@ -64,6 +69,11 @@ func testExpectedObject(
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -129,6 +139,19 @@ func testExpectedObject(
 	}
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)",
+			actual, actual)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+	}
+	return nil
+}
+
 func testStringObject(expected string, actual object.Object) error {
 	result, ok := actual.(*object.String)
 	if !ok {
@@ -178,6 +201,34 @@ func TestIntegerArithmetic(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 2.25", 3.75},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"7 / 2", 3},
+		{"1e2 - 1", 99.0},
+		{"(50 * 0.2) / 4", 2.5},
+		{"1.5 > 1", true},
+		{"1 > 1.5", false},
+		{"2 < 2.5", true},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"0.1 + 0.2 == 0.3", false},
+		{"if (0.0) { 1 } else { 2 }", 2},
+		{"if (0.5) { 1 } else { 2 }", 1},
+		{"!0.0", true},
+		{"!-0.0", true},
+		// 值相等的整数和浮点数是同一个键
+		{"{1: 5}[1.0]", 5},
+		{"let h = {2.0: 7}; h[2] = h[2] + 1; h[2.0]", 8},
+	}
+	runVmTests(t, tests)
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -205,11 +256,64 @@ func TestBooleanExpressions(t *testing.T) {
 		{"!!true", true},
 		{"!!false", false},
 		{"!!5", true},
+		{"!0", true},
+		{"!!0", false},
 		{"!(if (false) { 5; })", true},
 	}
 	runVmTests(t, tests)
 }
 
+func TestComparisonAndLogicalOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"2.5 >= 2", true},
+		{"2 <= 1.5", false},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"7.5 % 2", 1.5},
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"!true || 1 % 2 == 1", true},
+		{"if (1 < 2 && 3 >= 3) { 10 } else { 20 }", 10},
+		// 短路求值：右边的函数不会被调用
+		{"let x = 0; let f = fn() { x = 1; true }; false && f(); x", 0},
+		{"let x = 0; let f = fn() { x = 1; true }; true || f(); x", 0},
+		{"let x = 0; let f = fn() { x = 1; true }; true && f(); x", 1},
+		{"2.5 < 3", true},
+		{"1.5 <= 1.5", true},
+		{"3 < 2.5", false},
+		// 比较运算都是先求左边再求右边，跟evaluator一样
+		{"let x = []; let f = fn(v) { x = push(x, v); v }; f(1) <= f(2); x", []int{1, 2}},
+		{"let x = []; let f = fn(v) { x = push(x, v); v }; f(1) < f(2); x", []int{1, 2}},
+		{"let x = []; let f = fn(v) { x = push(x, v); v }; f(1) >= f(2); f(3) > f(4); x", []int{1, 2, 3, 4}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestDivisionByZero(t *testing.T) {
+	for _, input := range []string{"1 / 0", "1 % 0"} {
+		program := parse(input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+		if err == nil || err.Error() != "division by zero" {
+			t.Errorf("input %q: expected division by zero error, got=%v", input, err)
+		}
+	}
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []vmTestCase{
 		{"if (true) { 10 }", 10},
@@ -225,11 +329,135 @@ func TestConditionals(t *testing.T) {
 		//非常垃圾的语法，可读性非常差，if表达式必须去掉，只能用if语句，if表达式的等价效果用三元表达式表示，虽然本质上一样，
 		//但是应该从概念上把表达式和语句分开，否则容易引起理解和使用的混乱
 		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 } else if (1 < 2) { 20 } else { 30 }", 20},
+		{"if (1 > 2) { 10 } else if (false) { 20 } else { 30 }", 30},
+		{"if (false) { 10 } else if (false) { 20 }", Null},
+		{"let grade = fn(n) { if (n >= 90) { 4 } else if (n >= 80) { 3 } else if (n >= 70) { 2 } else { 0 } }; grade(95) * 100 + grade(85) * 10 + grade(10)", 430},
+		{"let f = fn(x) { if (x == 0) { return 0; } else if (x == 1) { let y = 1; } else { return 2; } }; f(1)", Null},
 
 	}
 	runVmTests(t, tests)
 }
 
+func TestWhileStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+let i = 0;
+let sum = 0;
+while (i < 5) {
+sum = sum + i;
+i = i + 1;
+}
+sum
+`, 10},
+		{`
+let i = 0;
+while (false) {
+i = i + 1;
+}
+i
+`, 0},
+		{`
+let i = 0;
+let total = 0;
+while (i < 3) {
+let j = 0;
+while (j < 3) {
+total = total + i * j;
+j = j + 1;
+}
+i = i + 1;
+}
+total
+`, 9},
+		{`
+let find = fn(arr, target) {
+let i = 0;
+while (i < len(arr)) {
+if (arr[i] == target) {
+return i;
+}
+i = i + 1;
+}
+return -1;
+};
+find([5, 6, 7], 7) + find([5, 6, 7], 8)
+`, 1},
+		{`
+let counter = fn(n) {
+let i = 0;
+let step = fn(x) { x + n };
+while (i < 10) {
+i = step(i);
+}
+i
+};
+counter(3)
+`, 12},
+		{"if (true) { while (false) { } }", Null},
+		// 整数0为假，跟evaluator一致
+		{"let i = 3; let n = 0; while (i) { i = i - 1; n = n + 1; if (n > 10) { break; } } n", 3},
+	}
+	runVmTests(t, tests)
+}
+
+func TestBreakAndContinue(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (true) { i = i + 1; if (i == 3) { break; } } i", 3},
+		{`
+let i = 0;
+let sum = 0;
+while (i < 10) {
+i = i + 1;
+if (i / 2 * 2 == i) { continue; }
+sum = sum + i;
+}
+sum
+`, 25},
+		{`
+let i = 0;
+let count = 0;
+while (i < 3) {
+let j = 0;
+while (true) {
+if (j == 2) { break; }
+j = j + 1;
+count = count + 1;
+}
+i = i + 1;
+}
+count
+`, 6},
+		{`
+let f = fn() {
+let i = 0;
+while (true) {
+let g = fn(x) { x * 2 };
+i = g(i + 1);
+if (i > 10) { break; }
+}
+i
+};
+f()
+`, 14},
+		{`
+let f = fn(n) {
+let i = 0;
+let odd = 0;
+while (i < n) {
+i = i + 1;
+if (i / 2 * 2 == i) { continue; }
+let inc = fn() { odd + 1 };
+odd = inc();
+}
+odd
+};
+f(7)
+`, 4},
+	}
+	runVmTests(t, tests)
+}
+
 func TestGlobalLetStatements(t *testing.T) {
 	tests := []vmTestCase{
 		{"let one = 1; one", 1},
@@ -244,6 +472,8 @@ func TestStringExpressions(t *testing.T) {
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"say \"hi\"\n"`, "say \"hi\"\n"},
+		{"`raw\\n` + \"\\t\"", "raw\\n\t"},
 	}
 	runVmTests(t, tests)
 }
@@ -296,6 +526,152 @@ func TestIndexExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestIndexAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1, 2, 3]; a[1] = 20; a", []int{1, 20, 3}},
+		{"let a = [[1, 2], [3]]; a[0][1] = a[1][0] * 10; a[0]", []int{1, 30}},
+		{"let h = {1: 1}; h[1] = 10; h[2] = 20; h", map[object.HashKey]int64{
+			(&object.Integer{Value: 1}).HashKey(): 10,
+			(&object.Integer{Value: 2}).HashKey(): 20,
+		}},
+		{`let h = {}; h["a"] = 1; h["a"] = h["a"] + 1; h["a"]`, 2},
+		// 数组和哈希是引用，函数里修改的是同一个对象
+		{"let a = [0]; let set = fn(arr, v) { arr[0] = v; }; set(a, 5); a[0]", 5},
+		{"let f = fn() { let a = [1, 2]; let i = 1; a[i] = 7; a }; f()", []int{1, 7}},
+		// 在循环里建查找表，不用每次重建整个哈希
+		{`let squares = {}; let i = 0; while (i < 5) { squares[i] = i * i; i = i + 1; } squares[4]`, 16},
+	}
+	runVmTests(t, tests)
+}
+
+func TestCompoundAssignment(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 1; i += 2; i", 3},
+		{"let i = 10; i -= 4; i *= 3; i /= 2; i %= 5; i", 4},
+		{"let f = 1.5; f *= 2; f", 3.0},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{"let f = fn() { let i = 0; let n = 0; while (i < 5) { i += 1; n += i; } n }; f()", 15},
+		{"let a = [1, 2]; a[1] += 10; a", []int{1, 12}},
+		{`let h = {"n": 1}; h["n"] *= 5; h["n"]`, 5},
+		// 目标中的数组和索引只求一次
+		{"let calls = [0]; let next = fn() { calls[0] += 1; 0 }; let a = [10]; a[next()] += 5; [a[0], calls[0]]", []int{15, 1}},
+		{"let calls = [0]; let get = fn() { calls[0] += 1; [[1, 2]] }; get()[0][1] -= 2; calls[0]", 1},
+		// 闭包里给捕获的变量赋值
+		{"let counter = fn() { let c = 0; fn() { c += 1; c } }; let next = counter(); next(); next(); next()", 3},
+		{"let mk = fn() { let n = 10; let g = fn() { n += 1; n }; g() }; mk()", 11},
+		{"let mk = fn(n) { fn() { n = n * 2; n } }; let g = mk(3); g(); g()", 12},
+	}
+	runVmTests(t, tests)
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let sum = 0; for (let i = 0; i < 5; i += 1) { sum += i; } sum", 10},
+		{"let i = 0; for (; i < 3;) { i += 1; } i", 3},
+		{"let i = 0; for (;;) { i += 1; if (i == 4) { break; } } i", 4},
+		{"let sum = 0; for (let i = 0; i < 10; i += 1) { if (i % 2 == 0) { continue; } sum += i; } sum", 25},
+		{`
+let f = fn(n) {
+let total = 0;
+for (let i = 0; i < n; i += 1) {
+for (let j = 0; j < i; j += 1) { total += 1; }
+}
+total
+};
+f(4)
+`, 6},
+		{"let sum = 0; for (x in [1, 2, 3]) { sum += x; } sum", 6},
+		{"let s = 0; for (i, x in [10, 20, 30]) { s += i * x; } s", 80},
+		{`let out = ""; for (k in {"b": 1, "a": 2, "c": 3}) { out += k; } out`, "abc"},
+		{`let out = ""; for (k, v in {2: "b", 1: "a", true: "t"}) { out += v; } out`, "tab"},
+		{`let n = 0; for (i, c in "héllo") { n = i; } n`, 4},
+		{`let out = ""; for (c in "héllo") { out = c + out; } out`, "olléh"},
+		{"let last = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } last = x; } last", 2},
+		{"let sum = 0; for (x in [1, 2, 3, 4]) { if (x % 2 == 0) { continue; } sum += x; } sum", 4},
+		{`
+let find = fn(arr, target) {
+for (i, x in arr) {
+if (x == target) { return i; }
+}
+return -1;
+};
+find([5, 6, 7], 7) + find([5, 6, 7], 8)
+`, 1},
+		{`
+let sum = 0;
+for (row in [[1, 2], [3], []]) {
+for (x in row) { if (x == 2) { break; } sum += x; }
+}
+sum
+`, 4},
+		{"let a = [1, 2, 3]; for (i, x in a) { a[i] = x * 2; } a", []int{2, 4, 6}},
+		{"let n = 0; for (x in []) { n += 1; } for (x in {}) { n += 1; } for (c in \"\") { n += 1; } n", 0},
+	}
+	runVmTests(t, tests)
+}
+
+func TestForInErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"for (x in 1) { }", "cannot iterate over INTEGER"},
+		{"for (k, v in fn() { 1 }) { }", "cannot iterate over CLOSURE"},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("input %q: expected VM error but resulted in none.", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: wrong VM error: want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+
+	// 手写的字节码在栈顶不是迭代器时执行OpIterNext，要报错而不是panic
+	for _, ins := range []code.Instructions{
+		code.Make(code.OpIterNext, 4, 1),
+		append(code.Make(code.OpTrue), code.Make(code.OpIterNext, 5, 1)...),
+	} {
+		vm := New(&compiler.Bytecode{Instructions: ins, Builtins: object.DefaultBuiltins()})
+		if err := vm.Run(); err == nil {
+			t.Errorf("OpIterNext without an iterator should fail")
+		}
+	}
+}
+
+func TestIndexAssignmentErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = [1, 2]; a[2] = 3;", "index out of range: 2 with length 2"},
+		{"let a = [1, 2]; a[-1] = 3;", "index out of range: -1 with length 2"},
+		{`let a = [1, 2]; a["0"] = 3;`, "array index must be INTEGER, got STRING"},
+		{"let h = {}; h[fn(x) { x }] = 1;", "unusable as hash key: CLOSURE"},
+		{`let s = "abc"; s[0] = "x";`, "index assignment not supported: STRING"},
+		{`let h = {}; h["a"] += 1;`, "unsupported types for binary operation: NULL, INTEGER"},
+		{`let a = [1]; a[0] += "x";`, "unsupported types for binary operation: INTEGER, STRING"},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("input %q: expected VM error but resulted in none.", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: wrong VM error: want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
 func TestCallingFunctionsWithoutArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -686,4 +1062,359 @@ fibonacci(15);
 		},
 	}
 	runVmTests(t, tests)
-}
\ No newline at end of file
+}
+func TestRuntimeErrorPositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+a + b
+};
+let wrap = fn() {
+add(1, "x")
+};
+wrap();`
+
+	program := parse(input)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+	re, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("err is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+	if re.Opcode != code.OpAdd {
+		t.Errorf("wrong opcode. want=%d, got=%d", code.OpAdd, re.Opcode)
+	}
+	if re.Line != 2 || re.Column != 3 {
+		t.Errorf("wrong position. want=2:3, got=%d:%d", re.Line, re.Column)
+	}
+
+	expected := []TraceEntry{
+		{Function: "add", Line: 2, Column: 3},
+		{Function: "wrap", Line: 5, Column: 4},
+		{Function: "<main>", Line: 7, Column: 5},
+	}
+	if len(re.Trace) != len(expected) {
+		t.Fatalf("wrong trace length. want=%d, got=%d (%+v)", len(expected), len(re.Trace), re.Trace)
+	}
+	for i, entry := range expected {
+		if re.Trace[i] != entry {
+			t.Errorf("wrong trace entry %d. want=%+v, got=%+v", i, entry, re.Trace[i])
+		}
+	}
+}
+
+func TestStackOverflowIsRuntimeError(t *testing.T) {
+	input := `let f = fn(x) { f(x + 1) }; f(0);`
+
+	program := parse(input)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	re, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("err is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+	if len(re.Trace) != MaxFrames {
+		t.Errorf("wrong trace length. want=%d, got=%d", MaxFrames, len(re.Trace))
+	}
+	if re.Trace[0].Function != "f" {
+		t.Errorf("wrong innermost function. want=f, got=%s", re.Trace[0].Function)
+	}
+}
+
+func TestRunDecodedBytecode(t *testing.T) {
+	input := `
+let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) };
+let scale = fn(x) { x * 0.5 };
+[fib(10), scale(3), "ok", len("abc")]
+`
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := comp.Bytecode().Encode(&buf, "fib.gl"); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	bytecode, _, err := compiler.DecodeBytecode(&buf, nil)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	vm := New(bytecode)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if got := vm.LastPoppedStackElem().Inspect(); got != "[55, 1.5, ok, 3]" {
+		t.Errorf("wrong result. got=%s", got)
+	}
+}
+
+func TestBuiltinRegistries(t *testing.T) {
+	// 两个注册表里同一个索引是不同的函数，各自编译执行互不干扰
+	sandbox := object.NewBuiltinRegistry()
+	sandbox.Register("answer", func(args ...object.Object) object.Object {
+		return &object.Integer{Value: 42}
+	})
+	full := object.DefaultBuiltins()
+	full.Register("answer", func(args ...object.Object) object.Object {
+		return &object.Integer{Value: 43}
+	})
+
+	tests := []struct {
+		builtins *object.BuiltinRegistry
+		input string
+		expected interface{}
+	}{
+		{sandbox, `answer()`, 42},
+		{full, `answer()`, 43},
+		{full, `len("abc") + answer()`, 46},
+	}
+	for _, tt := range tests {
+		comp := compiler.NewWithBuiltins(tt.builtins)
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+
+	// 沙箱里没有的内置函数在编译时就报错
+	comp := compiler.NewWithBuiltins(sandbox)
+	if err := comp.Compile(parse(`len("abc")`)); err == nil {
+		t.Errorf("expected compile error for builtin missing from sandbox")
+	}
+
+	// 字节码只能在兼容的注册表上加载
+	comp = compiler.NewWithBuiltins(full)
+	if err := comp.Compile(parse(`answer()`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := comp.Bytecode().Encode(&buf, ""); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if _, _, err := compiler.DecodeBytecode(bytes.NewReader(buf.Bytes()), sandbox); err == nil {
+		t.Errorf("expected error decoding with an incompatible registry")
+	}
+	bytecode, _, err := compiler.DecodeBytecode(bytes.NewReader(buf.Bytes()), full)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	vm := New(bytecode)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 43, vm.LastPoppedStackElem())
+}
+
+func TestExecutionLimits(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelExpired()
+
+	tests := []struct {
+		input string
+		ctx context.Context
+		limits object.Limits
+		kind object.LimitKind
+		cause error
+	}{
+		{`while (true) {}`, context.Background(), object.Limits{MaxInstructions: 1000}, object.LimitInstructions, nil},
+		{`let f = fn(n) { f(n + 1) }; f(0);`, context.Background(), object.Limits{MaxCallDepth: 10}, object.LimitCallDepth, nil},
+		{`while (true) {}`, expired, object.Limits{}, object.LimitDeadline, context.DeadlineExceeded},
+		{`1 + 2`, canceled, object.Limits{}, object.LimitCanceled, context.Canceled},
+		{`let a = []; while (true) { a = push(a, 1); }`, context.Background(), object.Limits{MaxMemory: 1 << 16}, object.LimitMemory, nil},
+		{`let s = "x"; while (true) { s = s + s; }`, context.Background(), object.Limits{MaxMemory: 1 << 20}, object.LimitMemory, nil},
+		{`[1, 2, 3, 4, 5]`, context.Background(), object.Limits{MaxMemory: 64}, object.LimitMemory, nil},
+		{`{"a": 1, "b": 2, "c": 3}`, context.Background(), object.Limits{MaxMemory: 64}, object.LimitMemory, nil},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		vm.SetLimits(tt.limits)
+		err := vm.RunContext(tt.ctx)
+
+		var limitErr *object.LimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%q: expected *object.LimitError, got=%T (%v)", tt.input, err, err)
+			continue
+		}
+		if limitErr.Kind != tt.kind {
+			t.Errorf("%q: wrong limit. want=%s, got=%s", tt.input, tt.kind, limitErr.Kind)
+		}
+		if tt.cause != nil && !errors.Is(err, tt.cause) {
+			t.Errorf("%q: error should wrap %v", tt.input, tt.cause)
+		}
+		if _, ok := err.(*RuntimeError); !ok {
+			t.Errorf("%q: limit error should be reported as *RuntimeError, got=%T", tt.input, err)
+		}
+	}
+}
+
+func TestBuiltinAllocations(t *testing.T) {
+	called := false
+	builtins := object.DefaultBuiltins()
+	builtins.Add(&object.Builtin{
+		Name: "alloc",
+		Fn: func(args ...object.Object) object.Object {
+			called = true
+			return &object.Array{}
+		},
+		Alloc: func(args ...object.Object) int64 { return 1 << 20 },
+	})
+	builtins.Register("id", func(args ...object.Object) object.Object { return args[0] })
+
+	tests := []struct {
+		input string
+		limits object.Limits
+		exceeded bool
+	}{
+		// 返回参数里已有的对象不算新分配，多少次都不会超
+		{`let m = [[1, 2, 3, 4, 5, 6, 7, 8], [1]]; let i = 0; while (i < 1000) { first(m); last(m); i = i + 1; } i`, object.Limits{MaxMemory: 1024}, false},
+		{`let s = "0123456789012345678901234567890123456789"; let i = 0; while (i < 1000) { id(s); i = i + 1; } i`, object.Limits{MaxMemory: 1024}, false},
+		{`let a = [1, 2, 3]; let i = 0; while (true) { a = rest(push(a, i)); i = i + 1; }`, object.Limits{MaxMemory: 1 << 12}, true},
+		// 能估算分配量的内置函数在调用之前记账，超出限制时不会被调用
+		{`alloc()`, object.Limits{MaxMemory: 1 << 10}, true},
+	}
+
+	for _, tt := range tests {
+		called = false
+		comp := compiler.NewWithBuiltins(builtins)
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		vm.SetLimits(tt.limits)
+		err := vm.RunContext(context.Background())
+
+		var limitErr *object.LimitError
+		if exceeded := errors.As(err, &limitErr) && limitErr.Kind == object.LimitMemory; exceeded != tt.exceeded {
+			t.Errorf("%q: memory limit exceeded=%t, want=%t (err=%v)", tt.input, exceeded, tt.exceeded, err)
+		}
+		if !tt.exceeded && err != nil {
+			t.Errorf("%q: vm error: %s", tt.input, err)
+		}
+	}
+	if called {
+		t.Errorf("builtin should not be called after its allocation exceeded the memory limit")
+	}
+}
+
+func TestLimitsAllowLongLoops(t *testing.T) {
+	input := `let i = 0; while (i < 5000) { i = i + 1; } i`
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	vm.SetLimits(object.Limits{MaxInstructions: 100000, MaxCallDepth: 10})
+	if err := vm.RunContext(context.Background()); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 5000, vm.LastPoppedStackElem())
+}
+
+func TestDebugHookInspectsFrames(t *testing.T) {
+	input := `
+let mk = fn(x) {
+fn(y) {
+let sum = x + y;
+sum
+}
+};
+mk(1)(2);`
+
+	program := parse(input)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+
+	// 第一次执行到第5行（sum已经赋值）时记录下变量
+	var locals, free []Variable
+	var frames []TraceEntry
+	vm.SetDebugHook(func(vm *VM) error {
+		pos, ok := vm.Position()
+		if !ok || pos.Line != 5 || locals != nil {
+			return nil
+		}
+		frames = vm.Frames()
+		if vm.CallDepth() != 1 {
+			t.Errorf("wrong call depth. want=1, got=%d", vm.CallDepth())
+		}
+		if locals, err = vm.Locals(0); err != nil {
+			return err
+		}
+		if free, err = vm.FreeVariables(0); err != nil {
+			return err
+		}
+		if _, err := vm.Locals(2); err == nil {
+			t.Errorf("expected error for frame 2")
+		}
+		return nil
+	})
+	if err = vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	if len(frames) != 2 || frames[1].Function != "<main>" {
+		t.Fatalf("wrong frames: %+v", frames)
+	}
+	if len(locals) != 2 || locals[0].Name != "y" || locals[1].Name != "sum" {
+		t.Fatalf("wrong locals: %+v", locals)
+	}
+	if err := testIntegerObject(3, locals[1].Value); err != nil {
+		t.Errorf("wrong local sum: %s", err)
+	}
+	if len(free) != 1 || free[0].Name != "x" {
+		t.Fatalf("wrong free variables: %+v", free)
+	}
+	if err := testIntegerObject(1, free[0].Value); err != nil {
+		t.Errorf("wrong free variable x: %s", err)
+	}
+
+	symbol, ok := comp.SymbolTable().Resolve("mk")
+	if !ok {
+		t.Fatalf("global mk not defined")
+	}
+	if _, ok := vm.Global(symbol.Index).(*object.Closure); !ok {
+		t.Errorf("global mk is not *object.Closure. got=%T", vm.Global(symbol.Index))
+	}
+}
+
+func TestDebugHookErrorStopsExecution(t *testing.T) {
+	program := parse(`let a = 1; let b = 2;`)
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+
+	stop := errors.New("stop")
+	vm.SetDebugHook(func(vm *VM) error { return stop })
+	err := vm.Run()
+	if !errors.Is(err, stop) {
+		t.Fatalf("expected hook error, got=%v", err)
+	}
+}